@@ -0,0 +1,235 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/faas-nomad/auth"
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// Outcomes recorded for an operation
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+	OutcomeDenied  = "denied"
+)
+
+// redacted replaces sensitive values in the request summary
+const redacted = "<redacted>"
+
+// sensitiveFields are request fields whose values are always redacted,
+// fields which contain maps have each value redacted and keep their keys.
+// Handlers decode field names without matching case so neither does the
+// summary.
+var sensitiveFields = []string{"value", "registryAuth", "envVars"}
+
+// Event is a single audited control-plane operation
+type Event struct {
+	Time           time.Time              `json:"time"`
+	Identity       string                 `json:"identity"`
+	SourceIP       string                 `json:"sourceIP"`
+	ForwardedFor   string                 `json:"forwardedFor,omitempty"`
+	Operation      string                 `json:"operation"`
+	Function       string                 `json:"function,omitempty"`
+	Request        map[string]interface{} `json:"request,omitempty"`
+	EvalID         string                 `json:"evalID,omitempty"`
+	JobModifyIndex uint64                 `json:"jobModifyIndex,omitempty"`
+	Status         int                    `json:"status"`
+	Outcome        string                 `json:"outcome"`
+}
+
+// Sink writes audit events
+type Sink interface {
+	Write(e Event) error
+}
+
+// Log records events to all of its sinks
+type Log struct {
+	sinks  []Sink
+	logger hclog.Logger
+}
+
+// NewLog creates a Log which writes to the given sinks
+func NewLog(logger hclog.Logger, sinks ...Sink) *Log {
+	return &Log{sinks: sinks, logger: logger.Named("audit")}
+}
+
+// Record writes an event to every sink, errors are logged so that a failing
+// sink does not fail the operation
+func (l *Log) Record(e Event) {
+	for _, s := range l.sinks {
+		if err := s.Write(e); err != nil {
+			l.logger.Error("Unable to write audit event", "operation", e.Operation, "function", e.Function, "error", err)
+		}
+	}
+}
+
+// nomadResult holds the Nomad evaluation created by an operation
+type nomadResult struct {
+	mutex          sync.Mutex
+	evalID         string
+	jobModifyIndex uint64
+}
+
+type resultKey struct{}
+
+// SetNomadResult records the evaluation and job modify index returned by
+// Nomad for the audited request, it does nothing when the request is not audited
+func SetNomadResult(ctx context.Context, evalID string, jobModifyIndex uint64) {
+	if res, ok := ctx.Value(resultKey{}).(*nomadResult); ok {
+		res.mutex.Lock()
+		defer res.mutex.Unlock()
+
+		res.evalID = evalID
+		res.jobModifyIndex = jobModifyIndex
+	}
+}
+
+// Operation returns the name of the operation a request performs, an empty
+// name means the request is not audited
+type Operation func(r *http.Request) string
+
+// StaticOperation creates an Operation which always returns the same name
+func StaticOperation(name string) Operation {
+	return func(r *http.Request) string {
+		return name
+	}
+}
+
+// SecretsOperation names secret writes, reading secrets is not audited
+func SecretsOperation(r *http.Request) string {
+	switch r.Method {
+	case http.MethodPost:
+		return "secret.create"
+	case http.MethodPut:
+		return "secret.update"
+	case http.MethodDelete:
+		return "secret.delete"
+	}
+
+	return ""
+}
+
+// MakeAuditMiddleware creates a handler which records an event after next
+// has handled the request
+func MakeAuditMiddleware(l *Log, operation Operation, function auth.FunctionName, next http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		op := operation(r)
+		if op == "" {
+			next(rw, r)
+			return
+		}
+
+		e := Event{
+			Time:         time.Now().UTC(),
+			Identity:     auth.IdentityFromRequest(r).String(),
+			SourceIP:     sourceIP(r),
+			ForwardedFor: r.Header.Get("X-Forwarded-For"),
+			Operation:    op,
+			Request:      summarise(r),
+		}
+
+		if function != nil {
+			e.Function = function(r)
+		}
+
+		res := &nomadResult{}
+		recorder := &statusRecorder{ResponseWriter: rw, status: http.StatusOK}
+
+		next(recorder, r.WithContext(context.WithValue(r.Context(), resultKey{}, res)))
+
+		res.mutex.Lock()
+		e.EvalID = res.evalID
+		e.JobModifyIndex = res.jobModifyIndex
+		res.mutex.Unlock()
+
+		e.Status = recorder.status
+		e.Outcome = outcome(recorder.status)
+
+		l.Record(e)
+	}
+}
+
+func outcome(status int) string {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return OutcomeDenied
+	case status >= http.StatusBadRequest:
+		return OutcomeFailure
+	}
+
+	return OutcomeSuccess
+}
+
+func sourceIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// summarise returns the JSON request body with sensitive values redacted,
+// the body is restored for the next handler
+func summarise(r *http.Request) map[string]interface{} {
+	if r.Body == nil {
+		return nil
+	}
+
+	body, _ := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	summary := map[string]interface{}{}
+	if err := json.Unmarshal(body, &summary); err != nil {
+		return nil
+	}
+
+	for k, v := range summary {
+		if !sensitive(k) {
+			continue
+		}
+
+		if m, ok := v.(map[string]interface{}); ok {
+			for mk := range m {
+				m[mk] = redacted
+			}
+
+			continue
+		}
+
+		summary[k] = redacted
+	}
+
+	return summary
+}
+
+func sensitive(field string) bool {
+	for _, f := range sensitiveFields {
+		if strings.EqualFold(f, field) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// statusRecorder captures the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,196 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/faas-nomad/auth"
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+type memorySink struct {
+	events []Event
+}
+
+func (m *memorySink) Write(e Event) error {
+	m.events = append(m.events, e)
+	return nil
+}
+
+func setupAudit(method, body string, next http.HandlerFunc) (*memorySink, *httptest.ResponseRecorder) {
+	sink := &memorySink{}
+	l := NewLog(hclog.Default(), sink)
+
+	rw := httptest.NewRecorder()
+	r := httptest.NewRequest(method, "/system/functions", bytes.NewReader([]byte(body)))
+	r.RemoteAddr = "10.0.0.1:51234"
+	r.Header.Set("X-Forwarded-For", "192.168.1.10")
+	r.SetBasicAuth("ci", "password")
+
+	name := auth.RequestField(func(body []byte) string {
+		secret := struct{ Name string }{}
+		json.Unmarshal(body, &secret)
+		return secret.Name
+	})
+
+	h := auth.MakeBasicAuthIdentity(MakeAuditMiddleware(l, SecretsOperation, name, next))
+	h(rw, r)
+
+	return sink, rw
+}
+
+func TestMiddlewareRecordsRedactedEvent(t *testing.T) {
+	var received string
+	sink, rw := setupAudit(http.MethodPost, `{"name":"db_password","value":"hunter2"}`, func(rw http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		received = string(b)
+
+		SetNomadResult(r.Context(), "eval-1", 42)
+		rw.WriteHeader(http.StatusCreated)
+	})
+
+	assert.Equal(t, http.StatusCreated, rw.Code)
+	assert.Contains(t, received, "hunter2", "the body must be restored for the handler")
+
+	e := sink.events[0]
+	assert.Equal(t, "user:ci", e.Identity)
+	assert.Equal(t, "10.0.0.1", e.SourceIP)
+	assert.Equal(t, "192.168.1.10", e.ForwardedFor)
+	assert.Equal(t, "secret.create", e.Operation)
+	assert.Equal(t, "db_password", e.Function)
+	assert.Equal(t, redacted, e.Request["value"])
+	assert.Equal(t, "eval-1", e.EvalID)
+	assert.Equal(t, uint64(42), e.JobModifyIndex)
+	assert.Equal(t, OutcomeSuccess, e.Outcome)
+}
+
+func TestMiddlewareRecordsOutcome(t *testing.T) {
+	statuses := map[int]string{
+		http.StatusForbidden:           OutcomeDenied,
+		http.StatusBadRequest:          OutcomeFailure,
+		http.StatusInternalServerError: OutcomeFailure,
+	}
+
+	for status, expected := range statuses {
+		sink, _ := setupAudit(http.MethodDelete, `{"name":"x"}`, func(rw http.ResponseWriter, r *http.Request) {
+			rw.WriteHeader(status)
+		})
+
+		assert.Equal(t, expected, sink.events[0].Outcome)
+		assert.Equal(t, status, sink.events[0].Status)
+	}
+}
+
+func TestMiddlewareDoesNotRecordReads(t *testing.T) {
+	sink, _ := setupAudit(http.MethodGet, "", func(rw http.ResponseWriter, r *http.Request) {})
+
+	assert.Empty(t, sink.events)
+}
+
+func TestSummaryRedactsMapValues(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"service":"f","envVars":{"TOKEN":"abc"},"registryAuth":"dXNlcjpwYXNz"}`))
+
+	summary := summarise(r)
+
+	assert.Equal(t, "f", summary["service"])
+	assert.Equal(t, map[string]interface{}{"TOKEN": redacted}, summary["envVars"])
+	assert.Equal(t, redacted, summary["registryAuth"])
+}
+
+func TestSummaryRedactsFieldsInAnyCase(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"name":"x","Value":"hunter2","EnvVars":{"TOKEN":"abc"},"REGISTRYAUTH":"dXNlcjpwYXNz"}`))
+
+	summary := summarise(r)
+
+	assert.Equal(t, redacted, summary["Value"])
+	assert.Equal(t, map[string]interface{}{"TOKEN": redacted}, summary["EnvVars"])
+	assert.Equal(t, redacted, summary["REGISTRYAUTH"])
+}
+
+func TestFileSinkRotatesFiles(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "audit")
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.log")
+	sink, err := NewFileSink(path, 200, 2)
+	assert.Nil(t, err)
+	defer sink.Close()
+
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, sink.Write(Event{Operation: "deploy", Function: "figlet", Time: time.Now()}))
+	}
+
+	for _, f := range []string{path, path + ".1", path + ".2"} {
+		data, err := ioutil.ReadFile(f)
+		assert.Nil(t, err, f)
+
+		e := Event{}
+		assert.Nil(t, json.Unmarshal([]byte(strings.Split(string(data), "\n")[0]), &e))
+		assert.Equal(t, "figlet", e.Function)
+	}
+
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestWebhookSinkPostsEvents(t *testing.T) {
+	received := make(chan Event, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		e := Event{}
+		json.NewDecoder(r.Body).Decode(&e)
+		received <- e
+	}))
+	defer server.Close()
+
+	sink := NewWebhookSink(server.URL, hclog.Default())
+	defer sink.Close()
+
+	assert.Nil(t, sink.Write(Event{Operation: "delete", Function: "figlet"}))
+
+	select {
+	case e := <-received:
+		assert.Equal(t, "delete", e.Operation)
+	case <-time.After(time.Second):
+		t.Fatal("Expected the webhook to receive the event")
+	}
+}
+
+func TestWebhookSinkCloseSendsQueuedEvents(t *testing.T) {
+	received := make(chan Event, 3)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		e := Event{}
+		json.NewDecoder(r.Body).Decode(&e)
+		received <- e
+	}))
+	defer server.Close()
+
+	sink := NewWebhookSink(server.URL, hclog.Default())
+	for i := 0; i < 3; i++ {
+		sink.Write(Event{Operation: "delete", Function: "figlet"})
+	}
+
+	sink.Close()
+
+	assert.Len(t, received, 3)
+}
+
+func TestWebhookSinkDropsEventsAfterClose(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	sink := NewWebhookSink(server.URL, hclog.Default())
+	sink.Close()
+
+	// handlers which outlive the shutdown timeout still record their event
+	assert.NotNil(t, sink.Write(Event{Operation: "delete", Function: "figlet"}))
+	sink.Close()
+}
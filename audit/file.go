@@ -0,0 +1,111 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// FileSink writes events as JSON lines to a file, the file is rotated when
+// it reaches the maximum size and the oldest backups are removed
+type FileSink struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mutex sync.Mutex
+	file  *os.File
+	size  int64
+}
+
+// NewFileSink opens or creates the audit file, a maxSize of 0 disables rotation
+func NewFileSink(path string, maxSize int64, maxBackups int) (*FileSink, error) {
+	s := &FileSink{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Write implements the Sink interface
+func (s *FileSink) Write(e Event) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	line = append(line, '\n')
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.maxSize > 0 && s.size > 0 && s.size+int64(len(line)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+
+	return err
+}
+
+// Close closes the audit file
+func (s *FileSink) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.file.Close()
+}
+
+func (s *FileSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	s.file = f
+	s.size = info.Size()
+
+	return nil
+}
+
+// rotate renames the current file to path.1, shifting older backups up by
+// one and removing any beyond maxBackups
+func (s *FileSink) rotate() error {
+	s.file.Close()
+
+	for i := s.maxBackups; i > 0; i-- {
+		from := backupName(s.path, i-1)
+		if i == s.maxBackups {
+			os.Remove(backupName(s.path, i))
+		}
+
+		if _, err := os.Stat(from); err == nil {
+			os.Rename(from, backupName(s.path, i))
+		}
+	}
+
+	if s.maxBackups == 0 {
+		os.Remove(s.path)
+	}
+
+	return s.open()
+}
+
+func backupName(path string, i int) string {
+	if i == 0 {
+		return path
+	}
+
+	return fmt.Sprintf("%s.%d", path, i)
+}
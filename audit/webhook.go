@@ -0,0 +1,115 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// webhookQueueSize is the number of events buffered for the webhook
+const webhookQueueSize = 1000
+
+// webhookCloseTimeout is the maximum time Close waits for queued events to be sent
+var webhookCloseTimeout = 10 * time.Second
+
+// WebhookSink posts each event as JSON to an HTTP endpoint, events are sent
+// in the background so that a slow endpoint does not delay operations
+type WebhookSink struct {
+	url    string
+	client *http.Client
+	queue  chan Event
+	done   chan struct{}
+	logger hclog.Logger
+
+	// closed is set by Close, handlers which outlive the server shutdown
+	// can still write events
+	mutex  sync.RWMutex
+	closed bool
+}
+
+// NewWebhookSink creates a WebhookSink and starts sending events
+func NewWebhookSink(url string, logger hclog.Logger) *WebhookSink {
+	s := &WebhookSink{
+		url:    url,
+		client: &http.Client{Timeout: 5 * time.Second},
+		queue:  make(chan Event, webhookQueueSize),
+		done:   make(chan struct{}),
+		logger: logger.Named("audit_webhook"),
+	}
+
+	go s.run()
+
+	return s
+}
+
+// Write implements the Sink interface, an error is returned when the queue
+// is full or the sink is closed and the event is dropped
+func (s *WebhookSink) Write(e Event) error {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if s.closed {
+		return fmt.Errorf("Audit webhook is closed, event dropped")
+	}
+
+	select {
+	case s.queue <- e:
+		return nil
+	default:
+		return fmt.Errorf("Audit webhook queue is full, event dropped")
+	}
+}
+
+// Close stops accepting events and waits for the queued events to be sent,
+// events which have not been sent when the timeout is reached are dropped
+func (s *WebhookSink) Close() {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return
+	}
+
+	s.closed = true
+	close(s.queue)
+	s.mutex.Unlock()
+
+	select {
+	case <-s.done:
+	case <-time.After(webhookCloseTimeout):
+		s.logger.Warn("Timeout sending queued audit events", "dropped", len(s.queue))
+	}
+}
+
+func (s *WebhookSink) run() {
+	defer close(s.done)
+
+	for e := range s.queue {
+		if err := s.send(e); err != nil {
+			s.logger.Error("Unable to send audit event", "operation", e.Operation, "function", e.Function, "error", err)
+		}
+	}
+}
+
+func (s *WebhookSink) send(e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("Webhook returned unexpected response: %v", resp.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,162 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/hashicorp/faas-nomad/metrics"
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/openfaas/faas/gateway/requests"
+)
+
+// DefaultNamespace is the namespace of every function, the provider does not
+// support namespaces so it is not read from the request
+const DefaultNamespace = "default"
+
+// RequestMapper returns the action a request performs and the function it
+// targets, scoped is false when the request is not for a single function
+type RequestMapper func(r *http.Request) (action, function string, scoped bool)
+
+// FunctionName returns the function a request targets
+type FunctionName func(r *http.Request) string
+
+// Action creates a RequestMapper for a handler which always performs the
+// same action, function can be nil when the handler is not for a single function
+func Action(action string, function FunctionName) RequestMapper {
+	return func(r *http.Request) (string, string, bool) {
+		if function == nil {
+			return action, "", false
+		}
+
+		return action, function(r), true
+	}
+}
+
+// SecretsAction maps requests to the secrets endpoint, reading secrets
+// requires secrets:read and all other methods secrets:write
+func SecretsAction(r *http.Request) (string, string, bool) {
+	if r.Method == http.MethodGet {
+		return ActionSecretsRead, "", false
+	}
+
+	return ActionSecretsWrite, "", false
+}
+
+// RequestField returns a FunctionName which decodes the JSON request body
+// and returns the name read by field, the body is restored for the next
+// handler. The body must be decoded into the type used by the handler so
+// that both see the same name.
+func RequestField(field func(body []byte) string) FunctionName {
+	return func(r *http.Request) string {
+		if r.Body == nil {
+			return ""
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		return field(body)
+	}
+}
+
+// DeployFunction reads the function of a deploy or update request
+var DeployFunction = RequestField(func(body []byte) string {
+	req := requests.CreateFunctionRequest{}
+	json.Unmarshal(body, &req)
+
+	return req.Service
+})
+
+// DeleteFunction reads the function of a delete request
+var DeleteFunction = RequestField(func(body []byte) string {
+	req := requests.DeleteFunctionRequest{}
+	json.Unmarshal(body, &req)
+
+	return req.FunctionName
+})
+
+type userKey struct{}
+type functionKey struct{}
+
+// WithUser returns a copy of the context containing the basic auth user
+func WithUser(ctx context.Context, user string) context.Context {
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+// MakeBasicAuthIdentity creates a handler which adds the basic auth user to
+// the request context, it must only wrap handlers which are called after the
+// credentials have been verified
+func MakeBasicAuthIdentity(next http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if user, _, ok := r.BasicAuth(); ok {
+			r = r.WithContext(WithUser(r.Context(), user))
+		}
+
+		next(rw, r)
+	}
+}
+
+// FunctionFromContext returns the function which the request was authorized
+// for, handlers use it instead of decoding the name again
+func FunctionFromContext(ctx context.Context) (string, bool) {
+	f, ok := ctx.Value(functionKey{}).(string)
+	return f, ok
+}
+
+// IdentityFromRequest returns the caller of a request from the verified
+// basic auth user or the claims of a validated bearer token, the
+// Authorization header is not read directly as it may not have been verified
+func IdentityFromRequest(r *http.Request) Identity {
+	id := Identity{}
+	if user, ok := r.Context().Value(userKey{}).(string); ok {
+		id.User = user
+	}
+
+	if claims, ok := ClaimsFromContext(r.Context()); ok {
+		id.Claims = claims
+	}
+
+	return id
+}
+
+// MakeAuthorizer creates a handler which only calls next when the policy
+// allows the caller to perform the action of the request
+func MakeAuthorizer(policy *Policy, mapper RequestMapper, next http.HandlerFunc, logger hclog.Logger, stats metrics.StatsD) http.HandlerFunc {
+	log := logger.Named("authorizer")
+
+	return func(rw http.ResponseWriter, r *http.Request) {
+		action, function, scoped := mapper(r)
+		namespace := DefaultNamespace
+		id := IdentityFromRequest(r)
+
+		// an empty name would otherwise match every function pattern
+		if scoped && function == "" {
+			log.Warn("Request denied", "identity", id.String(), "action", action, "reason", "no function name")
+			stats.Incr("auth.policy.denied", []string{"action:" + action}, 1)
+
+			http.Error(rw, "Forbidden: the request does not name a function", http.StatusForbidden)
+			return
+		}
+
+		allowed, reason := policy.Allowed(id, action, namespace, function)
+		if !allowed {
+			log.Warn("Request denied", "identity", id.String(), "action", action, "namespace", namespace, "function", function, "reason", reason)
+			stats.Incr("auth.policy.denied", []string{"action:" + action}, 1)
+
+			http.Error(rw, "Forbidden: "+reason, http.StatusForbidden)
+			return
+		}
+
+		stats.Incr("auth.policy.allowed", []string{"action:" + action}, 1)
+
+		if scoped {
+			r = r.WithContext(context.WithValue(r.Context(), functionKey{}, function))
+		}
+
+		next(rw, r)
+	}
+}
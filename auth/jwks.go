@@ -0,0 +1,299 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// keyRefreshInterval is the minimum time between fetches of the issuer keys
+// when a token is signed with an unknown key or the previous fetch failed
+var keyRefreshInterval = time.Minute
+
+// KeySource returns the public key used to verify a token signature
+type KeySource interface {
+	Key(kid string) (crypto.PublicKey, error)
+}
+
+// jwk is a single JSON Web Key, only the fields for RSA and EC keys are used
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// KeySet contains public keys indexed by key id
+type KeySet map[string]crypto.PublicKey
+
+// ParseJWKS parses a JSON Web Key Set, keys which are not used for
+// signatures or have an unsupported type are ignored
+func ParseJWKS(data []byte) (KeySet, error) {
+	jwks := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return nil, fmt.Errorf("Unable to parse JWKS: %s", err)
+	}
+
+	keys := KeySet{}
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse key %q: %s", k.Kid, err)
+		}
+
+		if key != nil {
+			keys[k.Kid] = key
+		}
+	}
+
+	return keys, nil
+}
+
+// Key returns the key with the given id, when the token has no key id the
+// key set must contain a single key
+func (ks KeySet) Key(kid string) (crypto.PublicKey, error) {
+	if key, ok := ks[kid]; ok {
+		return key, nil
+	}
+
+	if kid == "" && len(ks) == 1 {
+		for _, key := range ks {
+			return key, nil
+		}
+	}
+
+	return nil, fmt.Errorf("Unknown signing key %q", kid)
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+
+	return nil, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
+
+// FileKeySource reads keys from a local JWKS file, the file is read again
+// when a token is signed with an unknown key and the file has changed
+type FileKeySource struct {
+	path    string
+	mutex   sync.Mutex
+	keys    KeySet
+	modTime time.Time
+}
+
+// NewFileKeySource creates a FileKeySource, an error is returned when the
+// file can not be read
+func NewFileKeySource(path string) (*FileKeySource, error) {
+	s := &FileKeySource{path: path}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Key implements the KeySource interface
+func (s *FileKeySource) Key(kid string) (crypto.PublicKey, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key, err := s.keys.Key(kid)
+	if err == nil {
+		return key, nil
+	}
+
+	if info, statErr := os.Stat(s.path); statErr != nil || !info.ModTime().After(s.modTime) {
+		return nil, err
+	}
+
+	if loadErr := s.load(); loadErr != nil {
+		return nil, loadErr
+	}
+
+	return s.keys.Key(kid)
+}
+
+func (s *FileKeySource) load() error {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+
+	data, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+
+	keys, err := ParseJWKS(data)
+	if err != nil {
+		return err
+	}
+
+	s.keys = keys
+	s.modTime = info.ModTime()
+
+	return nil
+}
+
+// IssuerKeySource fetches keys from the jwks_uri in the OpenID Connect
+// discovery document of an issuer, keys are fetched again when a token is
+// signed with an unknown key
+type IssuerKeySource struct {
+	issuer    string
+	client    *http.Client
+	mutex     sync.Mutex
+	keys      KeySet
+	lastFetch time.Time
+	lastErr   error
+}
+
+// NewIssuerKeySource creates an IssuerKeySource, keys are fetched on first use
+func NewIssuerKeySource(issuer string) *IssuerKeySource {
+	return &IssuerKeySource{
+		issuer: strings.TrimSuffix(issuer, "/"),
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Key implements the KeySource interface, the keys are fetched at most once
+// every keyRefreshInterval whether or not the previous fetch succeeded, and
+// the fetch is made without holding the lock so that requests signed with a
+// known key are not blocked by it
+func (s *IssuerKeySource) Key(kid string) (crypto.PublicKey, error) {
+	s.mutex.Lock()
+
+	if s.keys != nil {
+		if key, err := s.keys.Key(kid); err == nil {
+			s.mutex.Unlock()
+			return key, nil
+		}
+	}
+
+	if time.Since(s.lastFetch) < keyRefreshInterval {
+		err := s.lastErr
+		s.mutex.Unlock()
+
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("Unknown signing key %q", kid)
+	}
+
+	s.lastFetch = time.Now()
+	s.mutex.Unlock()
+
+	keys, err := s.fetch()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.lastErr = err
+	if err != nil {
+		return nil, err
+	}
+
+	s.keys = keys
+	return keys.Key(kid)
+}
+
+func (s *IssuerKeySource) fetch() (KeySet, error) {
+	discovery := struct {
+		JWKSURI string `json:"jwks_uri"`
+	}{}
+
+	if err := s.get(s.issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, err
+	}
+
+	if discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("Issuer %s does not publish a jwks_uri", s.issuer)
+	}
+
+	var raw json.RawMessage
+	if err := s.get(discovery.JWKSURI, &raw); err != nil {
+		return nil, err
+	}
+
+	return ParseJWKS(raw)
+}
+
+func (s *IssuerKeySource) get(url string, out interface{}) error {
+	resp, err := s.client.Get(url)
+	if err != nil {
+		return fmt.Errorf("Error fetching issuer keys: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Issuer returned unexpected response: %v", resp.StatusCode)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,259 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	_ "crypto/sha256" // registers SHA-256 for crypto.Hash
+	_ "crypto/sha512" // registers SHA-384 and SHA-512 for crypto.Hash
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/faas-nomad/metrics"
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// Claims contains the claims of a validated token
+type Claims map[string]interface{}
+
+// String returns a string claim, an empty string is returned when the claim
+// is missing or not a string
+func (c Claims) String(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+// Strings returns a claim which may be a single string or a list of strings
+func (c Claims) Strings(name string) []string {
+	switch v := c[name].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		values := []string{}
+		for _, i := range v {
+			if s, ok := i.(string); ok {
+				values = append(values, s)
+			}
+		}
+
+		return values
+	}
+
+	return nil
+}
+
+// Subject returns the sub claim
+func (c Claims) Subject() string {
+	return c.String("sub")
+}
+
+func (c Claims) time(name string) (time.Time, bool) {
+	switch v := c[name].(type) {
+	case float64:
+		return time.Unix(int64(v), 0), true
+	case json.Number:
+		n, err := v.Int64()
+		return time.Unix(n, 0), err == nil
+	}
+
+	return time.Time{}, false
+}
+
+type claimsKey struct{}
+
+// ClaimsFromContext returns the claims of the token used to authenticate the
+// request, false is returned when the request was not authenticated with a token
+func ClaimsFromContext(ctx context.Context) (Claims, bool) {
+	c, ok := ctx.Value(claimsKey{}).(Claims)
+	return c, ok
+}
+
+// WithClaims returns a copy of the context containing the claims
+func WithClaims(ctx context.Context, claims Claims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+// Validator validates JWT bearer tokens
+type Validator struct {
+	// Keys returns the keys used to verify token signatures
+	Keys KeySource
+	// Issuer is compared to the iss claim when set
+	Issuer string
+	// Audience must be contained in the aud claim when set
+	Audience string
+	// Leeway allows for clock skew when checking exp and nbf
+	Leeway time.Duration
+}
+
+var signingHashes = map[string]crypto.Hash{
+	"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512,
+	"PS256": crypto.SHA256, "PS384": crypto.SHA384, "PS512": crypto.SHA512,
+	"ES256": crypto.SHA256, "ES384": crypto.SHA384, "ES512": crypto.SHA512,
+}
+
+// Validate verifies the signature of a compact serialised token and checks
+// its expiry, issuer and audience, the claims are returned when it is valid
+func (v *Validator) Validate(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("Token is malformed")
+	}
+
+	header := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("Token header is malformed: %s", err)
+	}
+
+	hash, ok := signingHashes[header.Alg]
+	if !ok {
+		return nil, fmt.Errorf("Token signing algorithm %q is not supported", header.Alg)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("Token signature is malformed: %s", err)
+	}
+
+	key, err := v.Keys.Key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := verify(header.Alg, hash, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+
+	claims := Claims{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("Token claims are malformed: %s", err)
+	}
+
+	return claims, v.checkClaims(claims)
+}
+
+func (v *Validator) checkClaims(claims Claims) error {
+	now := time.Now()
+
+	exp, ok := claims.time("exp")
+	if !ok {
+		return fmt.Errorf("Token has no expiry")
+	}
+
+	if now.After(exp.Add(v.Leeway)) {
+		return fmt.Errorf("Token has expired")
+	}
+
+	if nbf, ok := claims.time("nbf"); ok && now.Add(v.Leeway).Before(nbf) {
+		return fmt.Errorf("Token is not valid yet")
+	}
+
+	if v.Issuer != "" && strings.TrimSuffix(claims.String("iss"), "/") != strings.TrimSuffix(v.Issuer, "/") {
+		return fmt.Errorf("Token issuer %q is not trusted", claims.String("iss"))
+	}
+
+	if v.Audience != "" && !contains(claims.Strings("aud"), v.Audience) {
+		return fmt.Errorf("Token audience does not include %q", v.Audience)
+	}
+
+	return nil
+}
+
+func verify(alg string, hash crypto.Hash, key crypto.PublicKey, signed, signature []byte) error {
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		switch alg[:2] {
+		case "RS":
+			if rsa.VerifyPKCS1v15(k, hash, digest, signature) == nil {
+				return nil
+			}
+		case "PS":
+			if rsa.VerifyPSS(k, hash, digest, signature, nil) == nil {
+				return nil
+			}
+		}
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if alg[:2] == "ES" && len(signature) == 2*size {
+			r := new(big.Int).SetBytes(signature[:size])
+			s := new(big.Int).SetBytes(signature[size:])
+
+			if ecdsa.Verify(k, digest, r, s) {
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("Token signature is invalid")
+}
+
+func decodeSegment(segment string, out interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, out)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MakeJWTMiddleware creates a handler which only calls next when the request
+// has a valid bearer token, the token claims are added to the request context
+func MakeJWTMiddleware(v *Validator, next http.HandlerFunc, logger hclog.Logger, stats metrics.StatsD) http.HandlerFunc {
+	log := logger.Named("jwt_middleware")
+
+	return func(rw http.ResponseWriter, r *http.Request) {
+		token := bearerToken(r)
+		if token == "" {
+			stats.Incr("auth.jwt.missing", nil, 1)
+
+			rw.Header().Set("WWW-Authenticate", `Bearer`)
+			http.Error(rw, "Bearer token required", http.StatusUnauthorized)
+			return
+		}
+
+		claims, err := v.Validate(token)
+		if err != nil {
+			log.Warn("Rejected bearer token", "path", r.URL.Path, "remote_addr", r.RemoteAddr, "error", err)
+			stats.Incr("auth.jwt.invalid", nil, 1)
+
+			rw.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(rw, "Invalid bearer token", http.StatusUnauthorized)
+			return
+		}
+
+		stats.Incr("auth.jwt.success", nil, 1)
+		next(rw, r.WithContext(WithClaims(r.Context(), claims)))
+	}
+}
+
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		return ""
+	}
+
+	return strings.TrimSpace(header[7:])
+}
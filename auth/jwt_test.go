@@ -0,0 +1,277 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/faas-nomad/metrics"
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var (
+	rsaKey, _ = rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _  = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+)
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func testJWKS() []byte {
+	size := (ecKey.Curve.Params().BitSize + 7) / 8
+
+	jwks := map[string]interface{}{
+		"keys": []map[string]string{
+			{"kid": "rsa", "kty": "RSA", "use": "sig", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{"kid": "ec", "kty": "EC", "crv": "P-256", "x": b64(padded(ecKey.X, size)), "y": b64(padded(ecKey.Y, size))},
+			{"kid": "enc", "kty": "RSA", "use": "enc", "n": "AQAB", "e": "AQAB"},
+		},
+	}
+
+	data, _ := json.Marshal(jwks)
+	return data
+}
+
+func padded(i *big.Int, size int) []byte {
+	b := i.Bytes()
+	return append(make([]byte, size-len(b)), b...)
+}
+
+func sign(alg, kid string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := b64(header) + "." + b64(payload)
+
+	digest := crypto.SHA256.New()
+	digest.Write([]byte(signed))
+
+	var signature []byte
+	switch alg {
+	case "RS256":
+		signature, _ = rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest.Sum(nil))
+	case "ES256":
+		r, s, _ := ecdsa.Sign(rand.Reader, ecKey, digest.Sum(nil))
+		signature = append(padded(r, 32), padded(s, 32)...)
+	}
+
+	return signed + "." + b64(signature)
+}
+
+func validClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"sub": "ci",
+		"iss": "https://issuer.example.com",
+		"aud": []string{"faas-nomad", "other"},
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func setupValidator(t *testing.T) *Validator {
+	keys, err := ParseJWKS(testJWKS())
+	assert.Nil(t, err)
+
+	return &Validator{Keys: keys, Issuer: "https://issuer.example.com", Audience: "faas-nomad"}
+}
+
+func TestValidatesRSAAndECTokens(t *testing.T) {
+	v := setupValidator(t)
+
+	for _, alg := range []string{"RS256", "ES256"} {
+		kid := map[string]string{"RS256": "rsa", "ES256": "ec"}[alg]
+
+		claims, err := v.Validate(sign(alg, kid, validClaims()))
+
+		assert.Nil(t, err, alg)
+		assert.Equal(t, "ci", claims.Subject())
+	}
+}
+
+func TestRejectsInvalidTokens(t *testing.T) {
+	v := setupValidator(t)
+
+	expired := validClaims()
+	expired["exp"] = time.Now().Add(-time.Minute).Unix()
+
+	notYet := validClaims()
+	notYet["nbf"] = time.Now().Add(time.Hour).Unix()
+
+	wrongAudience := validClaims()
+	wrongAudience["aud"] = "someone-else"
+
+	wrongIssuer := validClaims()
+	wrongIssuer["iss"] = "https://evil.example.com"
+
+	noExpiry := validClaims()
+	delete(noExpiry, "exp")
+
+	tampered := sign("RS256", "rsa", validClaims())
+	tampered = tampered[:len(tampered)-4] + "AAAA"
+
+	tokens := map[string]string{
+		"expired":       sign("RS256", "rsa", expired),
+		"not yet valid": sign("RS256", "rsa", notYet),
+		"audience":      sign("RS256", "rsa", wrongAudience),
+		"issuer":        sign("RS256", "rsa", wrongIssuer),
+		"no expiry":     sign("RS256", "rsa", noExpiry),
+		"unknown key":   sign("RS256", "missing", validClaims()),
+		"wrong key":     sign("RS256", "ec", validClaims()),
+		"tampered":      tampered,
+		"none":          b64([]byte(`{"alg":"none"}`)) + "." + b64([]byte(`{"sub":"x"}`)) + ".",
+		"malformed":     "not-a-token",
+	}
+
+	for name, token := range tokens {
+		_, err := v.Validate(token)
+		assert.NotNil(t, err, name)
+	}
+}
+
+func TestFileKeySourceReloadsChangedFile(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "jwks")
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "jwks.json")
+	ioutil.WriteFile(path, []byte(`{"keys":[]}`), 0600)
+
+	source, err := NewFileKeySource(path)
+	assert.Nil(t, err)
+
+	_, err = source.Key("rsa")
+	assert.NotNil(t, err)
+
+	ioutil.WriteFile(path, testJWKS(), 0600)
+	os.Chtimes(path, time.Now().Add(time.Minute), time.Now().Add(time.Minute))
+
+	key, err := source.Key("rsa")
+	assert.Nil(t, err)
+	assert.NotNil(t, key)
+}
+
+func TestIssuerKeySourceFetchesKeysFromDiscovery(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			fmt.Fprintf(rw, `{"issuer":"%s","jwks_uri":"%s/keys"}`, server.URL, server.URL)
+		case "/keys":
+			rw.Write(testJWKS())
+		default:
+			rw.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	claims := validClaims()
+	claims["iss"] = server.URL
+
+	v := &Validator{Keys: NewIssuerKeySource(server.URL), Issuer: server.URL}
+	_, err := v.Validate(sign("ES256", "ec", claims))
+
+	assert.Nil(t, err)
+}
+
+func TestIssuerKeySourceDoesNotRefetchAfterFailure(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		requests++
+		rw.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	source := NewIssuerKeySource(server.URL)
+
+	_, err := source.Key("ec")
+	assert.NotNil(t, err)
+
+	_, err = source.Key("ec")
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, requests)
+}
+
+func TestIssuerKeySourceRefetchesFailedKeysAfterInterval(t *testing.T) {
+	defer func(i time.Duration) { keyRefreshInterval = i }(keyRefreshInterval)
+	keyRefreshInterval = 0
+
+	fail := true
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch {
+		case fail:
+			rw.WriteHeader(http.StatusInternalServerError)
+		case r.URL.Path == "/.well-known/openid-configuration":
+			fmt.Fprintf(rw, `{"issuer":"%s","jwks_uri":"%s/keys"}`, server.URL, server.URL)
+		default:
+			rw.Write(testJWKS())
+		}
+	}))
+	defer server.Close()
+
+	source := NewIssuerKeySource(server.URL)
+
+	_, err := source.Key("ec")
+	assert.NotNil(t, err)
+
+	fail = false
+	key, err := source.Key("ec")
+	assert.Nil(t, err)
+	assert.NotNil(t, key)
+}
+
+func setupMiddleware(t *testing.T, header string) (*httptest.ResponseRecorder, *Claims) {
+	stats := &metrics.MockStatsD{}
+	stats.On("Incr", mock.Anything, mock.Anything, mock.Anything)
+
+	var received *Claims
+	next := func(rw http.ResponseWriter, r *http.Request) {
+		c, _ := ClaimsFromContext(r.Context())
+		received = &c
+	}
+
+	rw := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/system/functions", nil)
+	if header != "" {
+		r.Header.Set("Authorization", header)
+	}
+
+	MakeJWTMiddleware(setupValidator(t), next, hclog.Default(), stats)(rw, r)
+
+	return rw, received
+}
+
+func TestMiddlewareAddsClaimsToContext(t *testing.T) {
+	rw, claims := setupMiddleware(t, "Bearer "+sign("RS256", "rsa", validClaims()))
+
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, "ci", claims.Subject())
+}
+
+func TestMiddlewareRejectsMissingToken(t *testing.T) {
+	rw, claims := setupMiddleware(t, "")
+
+	assert.Equal(t, http.StatusUnauthorized, rw.Code)
+	assert.Equal(t, "Bearer", rw.Header().Get("WWW-Authenticate"))
+	assert.Nil(t, claims)
+}
+
+func TestMiddlewareRejectsInvalidToken(t *testing.T) {
+	rw, claims := setupMiddleware(t, "Bearer not-a-token")
+
+	assert.Equal(t, http.StatusUnauthorized, rw.Code)
+	assert.Nil(t, claims)
+}
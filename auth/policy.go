@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"sync"
+
+	"github.com/hashicorp/hcl"
+)
+
+// Actions which can be granted by a policy rule
+const (
+	ActionRead         = "read"
+	ActionDeploy       = "deploy"
+	ActionUpdate       = "update"
+	ActionDelete       = "delete"
+	ActionScale        = "scale"
+	ActionInvoke       = "invoke"
+	ActionSecretsRead  = "secrets:read"
+	ActionSecretsWrite = "secrets:write"
+)
+
+var validActions = map[string]bool{
+	ActionRead: true, ActionDeploy: true, ActionUpdate: true, ActionDelete: true,
+	ActionScale: true, ActionInvoke: true, ActionSecretsRead: true, ActionSecretsWrite: true, "*": true,
+}
+
+// Policy grants actions to identities, a request is allowed when any rule
+// matches the caller, the action, the namespace and the function
+type Policy struct {
+	Rules []*Rule `hcl:"rule"`
+
+	mutex sync.RWMutex
+}
+
+// Rule grants actions on the functions matching a set of name patterns to
+// basic auth users or to callers whose token contains the listed claims
+type Rule struct {
+	Name string `hcl:",key"`
+	// Users are basic auth user names, * matches any authenticated user
+	Users []string `hcl:"users"`
+	// Claims must all be present in the caller's token, list claims such as
+	// groups match when they contain the value
+	Claims map[string]string `hcl:"claims"`
+	// Actions granted by the rule, * grants all actions
+	Actions []string `hcl:"actions"`
+	// Functions are glob patterns of function names, defaults to all functions
+	Functions []string `hcl:"functions"`
+	// Namespaces are glob patterns of namespaces, defaults to all namespaces
+	Namespaces []string `hcl:"namespaces"`
+}
+
+// Identity is the authenticated caller of a request
+type Identity struct {
+	User   string
+	Claims Claims
+}
+
+// String returns a description of the identity for logging
+func (i Identity) String() string {
+	switch {
+	case i.User != "":
+		return "user:" + i.User
+	case i.Claims != nil:
+		return "sub:" + i.Claims.Subject()
+	}
+
+	return "anonymous"
+}
+
+// LoadPolicy reads a policy from an HCL or JSON file
+func LoadPolicy(file string) (*Policy, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParsePolicy(string(data))
+}
+
+// ParsePolicy parses and validates an HCL or JSON policy
+func ParsePolicy(data string) (*Policy, error) {
+	p := &Policy{}
+	if err := hcl.Decode(p, data); err != nil {
+		return nil, fmt.Errorf("Unable to parse policy: %s", err)
+	}
+
+	for _, r := range p.Rules {
+		if len(r.Users) == 0 && len(r.Claims) == 0 {
+			return nil, fmt.Errorf("Rule %q must set users or claims", r.Name)
+		}
+
+		if len(r.Actions) == 0 {
+			return nil, fmt.Errorf("Rule %q must set actions", r.Name)
+		}
+
+		for _, a := range r.Actions {
+			if !validActions[a] {
+				return nil, fmt.Errorf("Rule %q has unknown action %q", r.Name, a)
+			}
+		}
+
+		for _, pattern := range append(r.Functions, r.Namespaces...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("Rule %q has invalid pattern %q", r.Name, pattern)
+			}
+		}
+	}
+
+	return p, nil
+}
+
+// Replace replaces the rules with those of another policy, it allows the
+// policy to be reloaded while requests are being authorized
+func (p *Policy) Replace(other *Policy) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.Rules = other.Rules
+}
+
+// Allowed returns true when a rule grants the action, a reason is returned
+// when the request is denied. An empty function is used for actions which
+// are not specific to a function, such as listing functions or secrets, they
+// are only granted by rules which apply to every function.
+func (p *Policy) Allowed(id Identity, action, namespace, function string) (bool, string) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	matched := false
+
+	for _, r := range p.Rules {
+		if !r.matchesIdentity(id) {
+			continue
+		}
+
+		matched = true
+
+		if contains(r.Actions, action) || contains(r.Actions, "*") {
+			if matchAny(r.Namespaces, namespace) && r.matchesFunction(function) {
+				return true, ""
+			}
+		}
+	}
+
+	if !matched {
+		return false, fmt.Sprintf("no policy rule matches %s", id)
+	}
+
+	if function == "" {
+		return false, fmt.Sprintf("no policy rule grants %s to %s", action, id)
+	}
+
+	return false, fmt.Sprintf("no policy rule grants %s on %s/%s to %s", action, namespace, function, id)
+}
+
+// matchesFunction returns true when the rule applies to the function, a rule
+// which is limited to some functions does not grant unscoped actions
+func (r *Rule) matchesFunction(function string) bool {
+	if function == "" {
+		return len(r.Functions) == 0 || contains(r.Functions, "*")
+	}
+
+	return matchAny(r.Functions, function)
+}
+
+func (r *Rule) matchesIdentity(id Identity) bool {
+	if id.User != "" && (contains(r.Users, id.User) || contains(r.Users, "*")) {
+		return true
+	}
+
+	if id.Claims == nil || len(r.Claims) == 0 {
+		return false
+	}
+
+	for name, value := range r.Claims {
+		if !contains(id.Claims.Strings(name), value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// matchAny returns true when the value matches one of the glob patterns, an
+// empty list of patterns matches everything
+func matchAny(patterns []string, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, value); ok {
+			return true
+		}
+	}
+
+	return false
+}
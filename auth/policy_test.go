@@ -0,0 +1,205 @@
+package auth
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/faas-nomad/metrics"
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+const testPolicy = `
+rule "ci" {
+  users     = ["ci"]
+  actions   = ["read", "deploy", "update", "delete", "scale"]
+  functions = ["ci-*"]
+}
+
+rule "humans" {
+  claims {
+    groups = "developers"
+  }
+
+  actions = ["read", "invoke"]
+}
+`
+
+func setupPolicy(t *testing.T) *Policy {
+	p, err := ParsePolicy(testPolicy)
+	assert.Nil(t, err)
+
+	return p
+}
+
+func TestPolicyAllowsCIToDeployMatchingFunctions(t *testing.T) {
+	p := setupPolicy(t)
+	ci := Identity{User: "ci"}
+
+	allowed, _ := p.Allowed(ci, ActionDeploy, DefaultNamespace, "ci-figlet")
+	assert.True(t, allowed)
+
+	allowed, reason := p.Allowed(ci, ActionDeploy, DefaultNamespace, "figlet")
+	assert.False(t, allowed)
+	assert.Contains(t, reason, "deploy on default/figlet")
+
+	allowed, _ = p.Allowed(ci, ActionInvoke, DefaultNamespace, "ci-figlet")
+	assert.False(t, allowed)
+}
+
+func TestPolicyDoesNotGrantUnscopedActionsToScopedRules(t *testing.T) {
+	p := setupPolicy(t)
+	ci := Identity{User: "ci"}
+
+	allowed, reason := p.Allowed(ci, ActionRead, DefaultNamespace, "")
+	assert.False(t, allowed)
+	assert.Equal(t, "no policy rule grants read to user:ci", reason)
+
+	allowed, _ = p.Allowed(ci, ActionRead, DefaultNamespace, "ci-figlet")
+	assert.True(t, allowed)
+}
+
+func TestPolicyReplaceChangesRules(t *testing.T) {
+	p := setupPolicy(t)
+	ci := Identity{User: "ci"}
+
+	other, err := ParsePolicy(`rule "ci" {
+  users   = ["ci"]
+  actions = ["*"]
+}`)
+	assert.Nil(t, err)
+
+	p.Replace(other)
+
+	allowed, _ := p.Allowed(ci, ActionInvoke, DefaultNamespace, "figlet")
+	assert.True(t, allowed)
+}
+
+func TestPolicyAllowsHumansToInvokeOnly(t *testing.T) {
+	p := setupPolicy(t)
+	human := Identity{Claims: Claims{"sub": "alice", "groups": []interface{}{"developers", "ops"}}}
+
+	allowed, _ := p.Allowed(human, ActionInvoke, DefaultNamespace, "figlet")
+	assert.True(t, allowed)
+
+	allowed, _ = p.Allowed(human, ActionRead, DefaultNamespace, "")
+	assert.True(t, allowed)
+
+	allowed, _ = p.Allowed(human, ActionDeploy, DefaultNamespace, "figlet")
+	assert.False(t, allowed)
+}
+
+func TestPolicyDeniesUnknownIdentities(t *testing.T) {
+	p := setupPolicy(t)
+
+	allowed, reason := p.Allowed(Identity{User: "mallory"}, ActionRead, DefaultNamespace, "")
+
+	assert.False(t, allowed)
+	assert.Equal(t, "no policy rule matches user:mallory", reason)
+}
+
+func TestParsePolicyRejectsInvalidRules(t *testing.T) {
+	policies := map[string]string{
+		"unknown action": `rule "a" { users = ["x"] actions = ["explode"] }`,
+		"no identity":    `rule "a" { actions = ["read"] }`,
+		"no actions":     `rule "a" { users = ["x"] }`,
+		"bad pattern":    `rule "a" { users = ["x"] actions = ["read"] functions = ["["] }`,
+	}
+
+	for name, policy := range policies {
+		_, err := ParsePolicy(policy)
+		assert.NotNil(t, err, name)
+	}
+}
+
+func TestParsePolicyAcceptsJSON(t *testing.T) {
+	p, err := ParsePolicy(`{"rule": {"ci": {"users": ["ci"], "actions": ["*"]}}}`)
+
+	assert.Nil(t, err)
+	allowed, _ := p.Allowed(Identity{User: "ci"}, ActionSecretsWrite, DefaultNamespace, "")
+	assert.True(t, allowed)
+}
+
+func setupAuthorizer(t *testing.T, mapper RequestMapper, user string, body string) (*httptest.ResponseRecorder, *[]byte) {
+	stats := &metrics.MockStatsD{}
+	stats.On("Incr", mock.Anything, mock.Anything, mock.Anything)
+
+	var received *[]byte
+	next := func(rw http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		received = &b
+	}
+
+	rw := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/system/functions", bytes.NewReader([]byte(body)))
+	r.SetBasicAuth(user, "password")
+
+	MakeBasicAuthIdentity(MakeAuthorizer(setupPolicy(t), mapper, next, hclog.Default(), stats))(rw, r)
+
+	return rw, received
+}
+
+func TestAuthorizerAllowsRequestAndRestoresBody(t *testing.T) {
+	body := `{"service":"ci-figlet"}`
+
+	rw, received := setupAuthorizer(t, Action(ActionDeploy, DeployFunction), "ci", body)
+
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, body, string(*received))
+}
+
+func TestAuthorizerDeniesRequest(t *testing.T) {
+	rw, received := setupAuthorizer(t, Action(ActionDeploy, DeployFunction), "ci", `{"service":"figlet"}`)
+
+	assert.Equal(t, http.StatusForbidden, rw.Code)
+	assert.Nil(t, received)
+}
+
+func TestAuthorizerMapsSecretsActions(t *testing.T) {
+	rw, _ := setupAuthorizer(t, SecretsAction, "ci", `{"name":"x","value":"y"}`)
+
+	assert.Equal(t, http.StatusForbidden, rw.Code)
+}
+
+func TestAuthorizerDecodesNameAsHandler(t *testing.T) {
+	// the handler decodes field names without matching case
+	rw, received := setupAuthorizer(t, Action(ActionDeploy, DeployFunction), "ci", `{"service":"ci-figlet","Service":"figlet"}`)
+
+	assert.Equal(t, http.StatusForbidden, rw.Code)
+	assert.Nil(t, received)
+}
+
+func TestAuthorizerDeniesScopedActionWithoutFunction(t *testing.T) {
+	rw, received := setupAuthorizer(t, Action(ActionDeploy, DeployFunction), "ci", `{"image":"figlet"}`)
+
+	assert.Equal(t, http.StatusForbidden, rw.Code)
+	assert.Nil(t, received)
+}
+
+func TestAuthorizerPassesFunctionToHandler(t *testing.T) {
+	stats := &metrics.MockStatsD{}
+	stats.On("Incr", mock.Anything, mock.Anything, mock.Anything)
+
+	var function string
+	next := func(rw http.ResponseWriter, r *http.Request) {
+		function, _ = FunctionFromContext(r.Context())
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/system/functions", bytes.NewReader([]byte(`{"SERVICE":"ci-figlet"}`)))
+	r = r.WithContext(WithUser(r.Context(), "ci"))
+
+	MakeAuthorizer(setupPolicy(t), Action(ActionDeploy, DeployFunction), next, hclog.Default(), stats)(httptest.NewRecorder(), r)
+
+	assert.Equal(t, "ci-figlet", function)
+}
+
+func TestIdentityIgnoresUnverifiedBasicAuth(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/system/functions", nil)
+	r.SetBasicAuth("admin", "password")
+
+	assert.Equal(t, "", IdentityFromRequest(r).User)
+}
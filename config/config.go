@@ -0,0 +1,210 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl"
+)
+
+// EnvPrefix is the prefix of the environment variables which override the
+// configuration file, the remainder of the name is the upper case flag name
+// e.g. FAAS_NOMAD_NOMAD_ADDR sets nomad_addr
+const EnvPrefix = "FAAS_NOMAD_"
+
+// Values are configuration values keyed by flag name
+type Values map[string]string
+
+// Loader sets flag values from a configuration file and environment
+// variables. Flags set on the command line take precedence over environment
+// variables, which take precedence over the configuration file.
+type Loader struct {
+	flags    *flag.FlagSet
+	path     string
+	explicit map[string]bool
+	applied  Values
+}
+
+// NewLoader creates a Loader for the flag set, it must be called after the
+// flags have been parsed so that flags set on the command line are known
+func NewLoader(flags *flag.FlagSet, path string) *Loader {
+	explicit := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	return &Loader{flags: flags, path: path, explicit: explicit, applied: Values{}}
+}
+
+// Load reads the configuration file and the environment, an error is
+// returned when the file contains a key which is not a known flag
+func (l *Loader) Load() (Values, error) {
+	values := Values{}
+
+	if l.path != "" {
+		data, err := ioutil.ReadFile(l.path)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read configuration file: %s", err)
+		}
+
+		file, err := Parse(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse configuration file %s: %s", l.path, err)
+		}
+
+		for k, v := range file {
+			if l.flags.Lookup(k) == nil {
+				return nil, fmt.Errorf("Unknown configuration key %q in %s", k, l.path)
+			}
+
+			values[k] = v
+		}
+	}
+
+	l.flags.VisitAll(func(f *flag.Flag) {
+		if v, ok := os.LookupEnv(EnvPrefix + strings.ToUpper(f.Name)); ok {
+			values[f.Name] = v
+		}
+	})
+
+	return values, nil
+}
+
+// Apply sets the flags which have not been set on the command line and whose
+// value has changed since the last call to Apply, when filter is not nil only
+// the flags it returns true for are set. A flag which has been removed from
+// the configuration is reset to its default value. When a value is invalid or
+// validate, which may be nil, returns an error every flag is restored to its
+// previous value. The names of the changed flags are returned.
+func (l *Loader) Apply(values Values, filter func(name string) bool, validate func() error) ([]string, error) {
+	changed := []string{}
+	previous := map[string]string{}
+
+	restore := func() {
+		for name, value := range previous {
+			l.flags.Set(name, value)
+		}
+	}
+
+	for _, name := range l.Changed(values) {
+		if filter != nil && !filter(name) {
+			continue
+		}
+
+		value, ok := values[name]
+		if !ok {
+			value = l.flags.Lookup(name).DefValue
+		}
+
+		previous[name] = l.flags.Lookup(name).Value.String()
+		if err := l.flags.Set(name, value); err != nil {
+			restore()
+			return nil, fmt.Errorf("Invalid value %q for %s: %s", value, name, err)
+		}
+
+		changed = append(changed, name)
+	}
+
+	if validate != nil {
+		if err := validate(); err != nil {
+			restore()
+			return nil, err
+		}
+	}
+
+	for _, name := range changed {
+		if value, ok := values[name]; ok {
+			l.applied[name] = value
+		} else {
+			delete(l.applied, name)
+		}
+	}
+
+	return changed, nil
+}
+
+// Changed returns the names of the flags whose configured values differ from
+// the values last applied, flags set on the command line are ignored
+func (l *Loader) Changed(values Values) []string {
+	names := map[string]bool{}
+	for name, value := range values {
+		if applied, ok := l.applied[name]; !ok || applied != value {
+			names[name] = true
+		}
+	}
+
+	for name := range l.applied {
+		if _, ok := values[name]; !ok {
+			names[name] = true
+		}
+	}
+
+	changed := []string{}
+	for name := range names {
+		if !l.explicit[name] && l.flags.Lookup(name) != nil {
+			changed = append(changed, name)
+		}
+	}
+
+	sort.Strings(changed)
+
+	return changed
+}
+
+// Parse parses an HCL or JSON configuration, nested blocks are flattened so
+// that the block names become a prefix of the key e.g. nomad { addr = "" }
+// is the key nomad_addr. Lists are joined with commas.
+func Parse(data string) (Values, error) {
+	raw := map[string]interface{}{}
+	if err := hcl.Decode(&raw, data); err != nil {
+		return nil, err
+	}
+
+	values := Values{}
+	if err := flatten("", raw, values); err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
+
+func flatten(prefix string, raw map[string]interface{}, values Values) error {
+	for k, v := range raw {
+		key := k
+		if prefix != "" {
+			key = prefix + "_" + k
+		}
+
+		switch val := v.(type) {
+		case []map[string]interface{}:
+			for _, block := range val {
+				if err := flatten(key, block, values); err != nil {
+					return err
+				}
+			}
+		case map[string]interface{}:
+			if err := flatten(key, val, values); err != nil {
+				return err
+			}
+		case []interface{}:
+			items := []string{}
+			for _, i := range val {
+				if _, ok := i.(map[string]interface{}); ok {
+					return fmt.Errorf("Unsupported list of objects for %s", key)
+				}
+
+				items = append(items, fmt.Sprintf("%v", i))
+			}
+
+			values[key] = strings.Join(items, ",")
+		default:
+			values[key] = fmt.Sprintf("%v", val)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,189 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testConfig = `
+port = 9090
+
+logger {
+  level = "DEBUG"
+}
+
+nomad {
+  addr = "nomad.service.consul:4646"
+
+  tls {
+    ca = "/etc/nomad/ca.pem"
+  }
+}
+`
+
+func setupLoader(t *testing.T, config string, args ...string) (*Loader, *flag.FlagSet, func()) {
+	f, err := ioutil.TempFile("", "faas-nomad-config")
+	assert.Nil(t, err)
+	f.WriteString(config)
+	f.Close()
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Int("port", 8080, "")
+	fs.String("logger_level", "INFO", "")
+	fs.String("nomad_addr", "localhost:4646", "")
+	fs.String("nomad_tls_ca", "", "")
+	fs.Duration("function_timeout", 30*time.Second, "")
+	fs.Parse(args)
+
+	return NewLoader(fs, f.Name()), fs, func() { os.Remove(f.Name()) }
+}
+
+func TestParseFlattensBlocks(t *testing.T) {
+	v, err := Parse(testConfig)
+
+	assert.Nil(t, err)
+	assert.Equal(t, Values{
+		"port":         "9090",
+		"logger_level": "DEBUG",
+		"nomad_addr":   "nomad.service.consul:4646",
+		"nomad_tls_ca": "/etc/nomad/ca.pem",
+	}, v)
+}
+
+func TestParseAcceptsJSON(t *testing.T) {
+	v, err := Parse(`{"nomad": {"addr": "nomad:4646"}, "port": 9090, "allowed": ["a", "b"]}`)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "nomad:4646", v["nomad_addr"])
+	assert.Equal(t, "9090", v["port"])
+	assert.Equal(t, "a,b", v["allowed"])
+}
+
+func TestParseReturnsErrorForInvalidHCL(t *testing.T) {
+	_, err := Parse(`nomad {`)
+
+	assert.NotNil(t, err)
+}
+
+func TestLoadAppliesFileToFlags(t *testing.T) {
+	l, fs, cleanup := setupLoader(t, testConfig)
+	defer cleanup()
+
+	v, err := l.Load()
+	assert.Nil(t, err)
+
+	_, err = l.Apply(v, nil, nil)
+	assert.Nil(t, err)
+
+	assert.Equal(t, "9090", fs.Lookup("port").Value.String())
+	assert.Equal(t, "nomad.service.consul:4646", fs.Lookup("nomad_addr").Value.String())
+}
+
+func TestLoadReturnsErrorForUnknownKey(t *testing.T) {
+	l, _, cleanup := setupLoader(t, `unknown = "value"`)
+	defer cleanup()
+
+	_, err := l.Load()
+
+	assert.Contains(t, err.Error(), "unknown")
+}
+
+func TestLoadReturnsErrorForMissingFile(t *testing.T) {
+	l := NewLoader(flag.NewFlagSet("test", flag.ContinueOnError), "/does/not/exist.hcl")
+
+	_, err := l.Load()
+
+	assert.NotNil(t, err)
+}
+
+func TestEnvironmentOverridesFile(t *testing.T) {
+	l, fs, cleanup := setupLoader(t, testConfig)
+	defer cleanup()
+
+	os.Setenv("FAAS_NOMAD_NOMAD_ADDR", "env:4646")
+	defer os.Unsetenv("FAAS_NOMAD_NOMAD_ADDR")
+
+	v, _ := l.Load()
+	l.Apply(v, nil, nil)
+
+	assert.Equal(t, "env:4646", fs.Lookup("nomad_addr").Value.String())
+}
+
+func TestCommandLineOverridesFile(t *testing.T) {
+	l, fs, cleanup := setupLoader(t, testConfig, "-port", "7070")
+	defer cleanup()
+
+	v, _ := l.Load()
+	changed, _ := l.Apply(v, nil, nil)
+
+	assert.Equal(t, "7070", fs.Lookup("port").Value.String())
+	assert.NotContains(t, changed, "port")
+}
+
+func TestApplyReturnsErrorForInvalidValue(t *testing.T) {
+	l, _, cleanup := setupLoader(t, `function_timeout = "forever"`)
+	defer cleanup()
+
+	v, _ := l.Load()
+	_, err := l.Apply(v, nil, nil)
+
+	assert.Contains(t, err.Error(), "function_timeout")
+}
+
+func TestApplyOnlySetsFilteredChanges(t *testing.T) {
+	l, fs, cleanup := setupLoader(t, testConfig)
+	defer cleanup()
+
+	v, _ := l.Load()
+	l.Apply(v, nil, nil)
+
+	v["port"] = "6060"
+	v["logger_level"] = "ERROR"
+
+	assert.Equal(t, []string{"logger_level", "port"}, l.Changed(v))
+
+	changed, err := l.Apply(v, func(name string) bool { return name == "logger_level" }, nil)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"logger_level"}, changed)
+	assert.Equal(t, "ERROR", fs.Lookup("logger_level").Value.String())
+	assert.Equal(t, "9090", fs.Lookup("port").Value.String())
+}
+
+func TestApplyResetsRemovedValuesToDefault(t *testing.T) {
+	l, fs, cleanup := setupLoader(t, testConfig)
+	defer cleanup()
+
+	v, _ := l.Load()
+	l.Apply(v, nil, nil)
+
+	delete(v, "logger_level")
+	changed, _ := l.Apply(v, nil, nil)
+
+	assert.Equal(t, []string{"logger_level"}, changed)
+	assert.Equal(t, "INFO", fs.Lookup("logger_level").Value.String())
+}
+
+func TestApplyRestoresFlagsWhenValidationFails(t *testing.T) {
+	l, fs, cleanup := setupLoader(t, testConfig)
+	defer cleanup()
+
+	v, _ := l.Load()
+	l.Apply(v, nil, nil)
+
+	v["port"] = "6060"
+	v["logger_level"] = "ERROR"
+
+	_, err := l.Apply(v, nil, func() error { return fmt.Errorf("invalid") })
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "9090", fs.Lookup("port").Value.String())
+	assert.Equal(t, "DEBUG", fs.Lookup("logger_level").Value.String())
+	assert.Equal(t, []string{"logger_level", "port"}, l.Changed(v))
+}
@@ -0,0 +1,71 @@
+package config
+
+import (
+	"os"
+	"time"
+)
+
+// Watcher polls a file and signals when its modification time changes
+type Watcher struct {
+	// C receives a value when the file has changed
+	C <-chan struct{}
+
+	path    string
+	changed chan struct{}
+	stop    chan struct{}
+}
+
+// NewWatcher creates a Watcher which checks the file at the given interval
+func NewWatcher(path string, interval time.Duration) *Watcher {
+	changed := make(chan struct{}, 1)
+	w := &Watcher{
+		C:       changed,
+		path:    path,
+		changed: changed,
+		stop:    make(chan struct{}),
+	}
+
+	go w.run(interval)
+
+	return w
+}
+
+// Stop ends the polling of the file
+func (w *Watcher) Stop() {
+	close(w.stop)
+}
+
+func (w *Watcher) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := w.modTime()
+	for {
+		select {
+		case <-ticker.C:
+			current := w.modTime()
+			if current.Equal(last) {
+				continue
+			}
+
+			last = current
+
+			// do not block when the previous change has not been handled
+			select {
+			case w.changed <- struct{}{}:
+			default:
+			}
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+func (w *Watcher) modTime() time.Time {
+	info, err := os.Stat(w.path)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return info.ModTime()
+}
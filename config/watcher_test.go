@@ -0,0 +1,30 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatcherSignalsWhenFileChanges(t *testing.T) {
+	f, err := ioutil.TempFile("", "faas-nomad-config")
+	assert.Nil(t, err)
+	f.Close()
+	defer os.Remove(f.Name())
+
+	w := NewWatcher(f.Name(), 5*time.Millisecond)
+	defer w.Stop()
+
+	time.Sleep(10 * time.Millisecond)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(f.Name(), future, future)
+
+	select {
+	case <-w.C:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the watcher to signal a change")
+	}
+}
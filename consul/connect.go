@@ -0,0 +1,118 @@
+package consul
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/connect"
+	cache "github.com/patrickmn/go-cache"
+)
+
+// connectCacheTTL is how long the resolver remembers that a function has a
+// Connect proxy
+var connectCacheTTL = 10 * time.Second
+
+// ConnectHealth defines the method of Consul's health API used to find Connect proxies
+type ConnectHealth interface {
+	Connect(service, tag string, passingOnly bool, q *api.QueryOptions) ([]*api.ServiceEntry, *api.QueryMeta, error)
+}
+
+// ConnectResolver resolves functions which are deployed with a Connect
+// sidecar to an https URL which is dialed by the Connect HTTP client, other
+// functions are resolved by the wrapped resolver
+type ConnectResolver struct {
+	ServiceResolver
+
+	health ConnectHealth
+	cache  *cache.Cache
+}
+
+// NewConnectResolver creates a ConnectResolver which falls back to next for
+// functions without a Connect proxy
+func NewConnectResolver(next ServiceResolver, health ConnectHealth) *ConnectResolver {
+	return &ConnectResolver{
+		ServiceResolver: next,
+		health:          health,
+		cache:           cache.New(connectCacheTTL, 2*connectCacheTTL),
+	}
+}
+
+// ConnectURL returns the URL of a function behind a Connect proxy, the host
+// uses the Consul DNS syntax which the Connect HTTP client resolves
+func ConnectURL(function string) string {
+	return "https://" + function + ".service.consul"
+}
+
+// IsConnectURL returns true when the URL was created by ConnectURL
+func IsConnectURL(address string) bool {
+	return strings.HasPrefix(address, "https://") && strings.Contains(address, ".service.consul")
+}
+
+// Resolve returns the Connect URL of the function when it has a healthy
+// Connect proxy, lookup errors fall back to the wrapped resolver.  Only
+// functions with a proxy are cached, a function which was just deployed is
+// checked again on every call until its sidecar is healthy.
+func (r *ConnectResolver) Resolve(function string) ([]string, error) {
+	if _, ok := r.cache.Get(function); ok {
+		return []string{ConnectURL(function)}, nil
+	}
+
+	entries, _, err := r.health.Connect(function, "", true, nil)
+	if err != nil || len(entries) == 0 {
+		return r.ServiceResolver.Resolve(function)
+	}
+
+	r.cache.Set(function, true, cache.DefaultExpiration)
+
+	return []string{ConnectURL(function)}, nil
+}
+
+// RemoveCacheItem removes the function from both caches
+func (r *ConnectResolver) RemoveCacheItem(function string) {
+	r.cache.Delete(function)
+	r.ServiceResolver.RemoveCacheItem(function)
+}
+
+// ConnectService is the Connect identity of the provider, it supplies the
+// client certificate presented to function proxies
+type ConnectService struct {
+	service *connect.Service
+	health  ConnectHealth
+}
+
+// NewConnectService creates the Connect identity for the named service, the
+// ACL token must have service:write on the service name
+func NewConnectService(name, address, ACLToken string) (*ConnectService, error) {
+	client, err := api.NewClient(&api.Config{Address: address, Token: ACLToken})
+	if err != nil {
+		return nil, err
+	}
+
+	svc, err := connect.NewService(name, client)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ConnectService{service: svc, health: client.Health()}, nil
+}
+
+// HTTPClient returns a client which dials Connect URLs with mTLS, requests
+// which take longer than the timeout are cancelled
+func (s *ConnectService) HTTPClient(timeout time.Duration) *http.Client {
+	c := s.service.HTTPClient()
+	c.Timeout = timeout
+
+	return c
+}
+
+// Health returns the health API used to find Connect proxies
+func (s *ConnectService) Health() ConnectHealth {
+	return s.health
+}
+
+// Close stops renewing the Connect certificates
+func (s *ConnectService) Close() {
+	s.service.Close()
+}
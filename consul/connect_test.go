@@ -0,0 +1,101 @@
+package consul
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func setupConnectResolver() (*ConnectResolver, *MockResolver, *MockConnectHealth) {
+	next := &MockResolver{}
+	next.On("Resolve", mock.Anything).Return([]string{"http://10.0.0.1:21000"}, nil)
+	next.On("RemoveCacheItem", mock.Anything)
+
+	health := &MockConnectHealth{}
+	health.On("Connect", "secure").Return([]*api.ServiceEntry{&api.ServiceEntry{}}, nil)
+	health.On("Connect", "plain").Return(nil, nil)
+	health.On("Connect", "broken").Return(nil, fmt.Errorf("boom"))
+
+	return NewConnectResolver(next, health), next, health
+}
+
+func TestConnectResolverReturnsConnectURLForProxiedFunction(t *testing.T) {
+	r, next, _ := setupConnectResolver()
+
+	urls, err := r.Resolve("secure")
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"https://secure.service.consul"}, urls)
+	assert.True(t, IsConnectURL(urls[0]))
+	next.AssertNotCalled(t, "Resolve", mock.Anything)
+}
+
+func TestConnectResolverFallsBackForPlainFunction(t *testing.T) {
+	r, next, _ := setupConnectResolver()
+
+	urls, _ := r.Resolve("plain")
+
+	assert.Equal(t, []string{"http://10.0.0.1:21000"}, urls)
+	assert.False(t, IsConnectURL(urls[0]))
+	next.AssertCalled(t, "Resolve", "plain")
+}
+
+func TestConnectResolverCachesResult(t *testing.T) {
+	r, _, health := setupConnectResolver()
+
+	r.Resolve("secure")
+	r.Resolve("secure")
+
+	health.AssertNumberOfCalls(t, "Connect", 1)
+}
+
+func TestConnectResolverDoesNotCacheFunctionWithoutProxy(t *testing.T) {
+	r, next, health := setupConnectResolver()
+
+	r.Resolve("plain")
+	r.Resolve("plain")
+
+	health.AssertNumberOfCalls(t, "Connect", 2)
+	next.AssertNumberOfCalls(t, "Resolve", 2)
+}
+
+func TestConnectResolverReturnsConnectURLOnceProxyIsHealthy(t *testing.T) {
+	next := &MockResolver{}
+	next.On("Resolve", mock.Anything).Return([]string{"http://10.0.0.1:21000"}, nil)
+
+	health := &MockConnectHealth{}
+	health.On("Connect", "deploying").Return(nil, nil).Once()
+	health.On("Connect", "deploying").Return([]*api.ServiceEntry{&api.ServiceEntry{}}, nil)
+
+	r := NewConnectResolver(next, health)
+
+	urls, _ := r.Resolve("deploying")
+	assert.False(t, IsConnectURL(urls[0]))
+
+	urls, _ = r.Resolve("deploying")
+	assert.Equal(t, []string{"https://deploying.service.consul"}, urls)
+}
+
+func TestConnectResolverDoesNotCacheErrors(t *testing.T) {
+	r, next, health := setupConnectResolver()
+
+	r.Resolve("broken")
+	r.Resolve("broken")
+
+	health.AssertNumberOfCalls(t, "Connect", 2)
+	next.AssertNumberOfCalls(t, "Resolve", 2)
+}
+
+func TestConnectResolverRemoveCacheItemClearsBothCaches(t *testing.T) {
+	r, next, health := setupConnectResolver()
+
+	r.Resolve("secure")
+	r.RemoveCacheItem("secure")
+	r.Resolve("secure")
+
+	health.AssertNumberOfCalls(t, "Connect", 2)
+	next.AssertCalled(t, "RemoveCacheItem", "secure")
+}
@@ -0,0 +1,90 @@
+package consul
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/faas-nomad/metrics"
+)
+
+// InvocationKV defines the methods of Consul's key/value store used to save
+// invocation counts
+type InvocationKV interface {
+	List(prefix string, q *api.QueryOptions) (api.KVPairs, *api.QueryMeta, error)
+	Put(p *api.KVPair, q *api.WriteOptions) (*api.WriteMeta, error)
+	DeleteCAS(p *api.KVPair, q *api.WriteOptions) (bool, *api.WriteMeta, error)
+}
+
+// KVInvocationStore implements metrics.InvocationStore using the Consul k/v
+// store, each instance saves its counts to its own key under the prefix
+type KVInvocationStore struct {
+	liveToken
+
+	kv     InvocationKV
+	prefix string
+}
+
+// NewKVInvocationStore creates a KVInvocationStore which saves counts under the prefix
+func NewKVInvocationStore(address, ACLToken, prefix string) (*KVInvocationStore, error) {
+	client, err := api.NewClient(&api.Config{Address: address})
+	if err != nil {
+		return nil, err
+	}
+
+	s := NewKVInvocationStoreWithClient(client.KV(), prefix)
+	s.SetToken(ACLToken)
+
+	return s, nil
+}
+
+// NewKVInvocationStoreWithClient creates a KVInvocationStore using the given KV client
+func NewKVInvocationStoreWithClient(kv InvocationKV, prefix string) *KVInvocationStore {
+	return &KVInvocationStore{kv: kv, prefix: strings.Trim(prefix, "/")}
+}
+
+// Load implements the metrics.InvocationStore interface, keys which can not
+// be decoded are skipped
+func (s *KVInvocationStore) Load() (map[string]metrics.SavedInvocations, error) {
+	pairs, _, err := s.kv.List(s.prefix+"/", s.queryOptions())
+	if err != nil {
+		return nil, err
+	}
+
+	saved := map[string]metrics.SavedInvocations{}
+	for _, p := range pairs {
+		instance := strings.TrimPrefix(p.Key, s.prefix+"/")
+		if instance == "" || strings.Contains(instance, "/") {
+			continue
+		}
+
+		counts := metrics.SavedInvocations{}
+		if err := json.Unmarshal(p.Value, &counts); err != nil {
+			continue
+		}
+
+		counts.Index = p.ModifyIndex
+		saved[instance] = counts
+	}
+
+	return saved, nil
+}
+
+// Save implements the metrics.InvocationStore interface
+func (s *KVInvocationStore) Save(instance string, counts metrics.InvocationCounts) error {
+	data, err := json.Marshal(metrics.SavedInvocations{Counts: counts, Updated: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.kv.Put(&api.KVPair{Key: s.prefix + "/" + instance, Value: data}, s.writeOptions())
+	return err
+}
+
+// Remove implements the metrics.InvocationStore interface, the key is
+// deleted with check and set so that only one instance removes it
+func (s *KVInvocationStore) Remove(instance string, saved metrics.SavedInvocations) (bool, error) {
+	removed, _, err := s.kv.DeleteCAS(&api.KVPair{Key: s.prefix + "/" + instance, ModifyIndex: saved.Index}, s.writeOptions())
+	return removed, err
+}
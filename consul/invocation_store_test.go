@@ -0,0 +1,60 @@
+package consul
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/faas-nomad/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestKVInvocationStoreLoadsCountsOfEachInstance(t *testing.T) {
+	kv := &MockInvocationKV{}
+	kv.On("List", "openfaas/invocations/", mock.Anything).Return(api.KVPairs{
+		&api.KVPair{Key: "openfaas/invocations/a", Value: []byte(`{"counts":{"echo":{"2xx":3}},"updated":"2018-06-01T00:00:00Z"}`), ModifyIndex: 12},
+		&api.KVPair{Key: "openfaas/invocations/b", Value: []byte(`not json`)},
+	}, nil, nil)
+
+	s := NewKVInvocationStoreWithClient(kv, "/openfaas/invocations/")
+	saved, err := s.Load()
+
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]metrics.SavedInvocations{"a": {
+		Counts:  metrics.InvocationCounts{"echo": {"2xx": 3}},
+		Updated: time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC),
+		Index:   12,
+	}}, saved)
+}
+
+func TestKVInvocationStoreSavesCountsToInstanceKey(t *testing.T) {
+	kv := &MockInvocationKV{}
+	var value string
+	kv.On("Put", "openfaas/invocations/a", mock.Anything).Run(func(args mock.Arguments) {
+		value = args.String(1)
+	}).Return(nil)
+
+	s := NewKVInvocationStoreWithClient(kv, "openfaas/invocations")
+	err := s.Save("a", metrics.InvocationCounts{"echo": {"2xx": 3}})
+
+	assert.Nil(t, err)
+
+	saved := metrics.SavedInvocations{}
+	json.Unmarshal([]byte(value), &saved)
+	assert.Equal(t, metrics.InvocationCounts{"echo": {"2xx": 3}}, saved.Counts)
+	assert.WithinDuration(t, time.Now(), saved.Updated, time.Minute)
+}
+
+func TestKVInvocationStoreRemovesInstanceWithCheckAndSet(t *testing.T) {
+	kv := &MockInvocationKV{}
+	kv.On("DeleteCAS", "openfaas/invocations/a", uint64(12)).Return(false, nil)
+
+	s := NewKVInvocationStoreWithClient(kv, "openfaas/invocations")
+	removed, err := s.Remove("a", metrics.SavedInvocations{Index: 12})
+
+	assert.Nil(t, err)
+	assert.False(t, removed)
+	kv.AssertCalled(t, "DeleteCAS", "openfaas/invocations/a", uint64(12))
+}
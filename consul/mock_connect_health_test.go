@@ -0,0 +1,23 @@
+package consul
+
+import (
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/mock"
+)
+
+// MockConnectHealth is a mock implementation of the ConnectHealth interface
+type MockConnectHealth struct {
+	mock.Mock
+}
+
+// Connect returns the mocked Connect proxies for a service
+func (m *MockConnectHealth) Connect(service, tag string, passingOnly bool, q *api.QueryOptions) ([]*api.ServiceEntry, *api.QueryMeta, error) {
+	args := m.Called(service)
+
+	var entries []*api.ServiceEntry
+	if e := args.Get(0); e != nil {
+		entries = e.([]*api.ServiceEntry)
+	}
+
+	return entries, nil, args.Error(1)
+}
@@ -0,0 +1,37 @@
+package consul
+
+import (
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/mock"
+)
+
+// MockInvocationKV is a mock implementation of the InvocationKV interface
+type MockInvocationKV struct {
+	mock.Mock
+}
+
+// List returns the mocked key/value pairs for a prefix
+func (m *MockInvocationKV) List(prefix string, q *api.QueryOptions) (api.KVPairs, *api.QueryMeta, error) {
+	args := m.Called(prefix, q)
+
+	var pairs api.KVPairs
+	if p := args.Get(0); p != nil {
+		pairs = p.(api.KVPairs)
+	}
+
+	return pairs, nil, args.Error(2)
+}
+
+// Put records the written key/value pair
+func (m *MockInvocationKV) Put(p *api.KVPair, q *api.WriteOptions) (*api.WriteMeta, error) {
+	args := m.Called(p.Key, string(p.Value))
+
+	return nil, args.Error(0)
+}
+
+// DeleteCAS records the deleted key and index
+func (m *MockInvocationKV) DeleteCAS(p *api.KVPair, q *api.WriteOptions) (bool, *api.WriteMeta, error) {
+	args := m.Called(p.Key, p.ModifyIndex)
+
+	return args.Bool(0), nil, args.Error(1)
+}
@@ -0,0 +1,37 @@
+package consul
+
+import (
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/mock"
+)
+
+// MockKV is a mock implementation of the KV interface
+type MockKV struct {
+	mock.Mock
+}
+
+// Keys returns the mocked keys for a prefix
+func (m *MockKV) Keys(prefix, separator string, q *api.QueryOptions) ([]string, *api.QueryMeta, error) {
+	args := m.Called(prefix, separator)
+
+	var keys []string
+	if k := args.Get(0); k != nil {
+		keys = k.([]string)
+	}
+
+	return keys, nil, args.Error(1)
+}
+
+// Put records the written key/value pair
+func (m *MockKV) Put(p *api.KVPair, q *api.WriteOptions) (*api.WriteMeta, error) {
+	args := m.Called(p.Key, string(p.Value))
+
+	return nil, args.Error(0)
+}
+
+// Delete records the deleted key
+func (m *MockKV) Delete(key string, w *api.WriteOptions) (*api.WriteMeta, error) {
+	args := m.Called(key)
+
+	return nil, args.Error(0)
+}
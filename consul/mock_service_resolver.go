@@ -22,3 +22,14 @@ func (mr *MockResolver) Resolve(function string) ([]string, error) {
 func (mr *MockResolver) RemoveCacheItem(function string) {
 	mr.Called(function)
 }
+
+// CachedFunctions returns the arguments from the Mocks setup method
+func (mr *MockResolver) CachedFunctions() []string {
+	args := mr.Called()
+
+	if a := args.Get(0); a != nil {
+		return a.([]string)
+	}
+
+	return nil
+}
@@ -34,3 +34,10 @@ func (m *MockWatcher) IterateDataCh(f iterateFunc) {
 		f(dep, cs)
 	}
 }
+
+// Stop stops the watcher and closes the data channel
+func (m *MockWatcher) Stop() {
+	m.Mock.Called()
+
+	close(m.data)
+}
@@ -0,0 +1,110 @@
+package consul
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/faas-nomad/types"
+)
+
+// KV defines methods for Consul's key/value store
+type KV interface {
+	Keys(prefix, separator string, q *api.QueryOptions) ([]string, *api.QueryMeta, error)
+	Put(p *api.KVPair, q *api.WriteOptions) (*api.WriteMeta, error)
+	Delete(key string, w *api.WriteOptions) (*api.WriteMeta, error)
+}
+
+// KVSecretStore implements types.SecretStore using the Consul k/v store,
+// secrets are rendered into functions with the key template function
+type KVSecretStore struct {
+	liveToken
+
+	kv     KV
+	prefix string
+}
+
+// NewKVSecretStore creates a KVSecretStore which stores secrets under the prefix
+func NewKVSecretStore(address, ACLToken, prefix string) (*KVSecretStore, error) {
+	client, err := api.NewClient(&api.Config{Address: address})
+	if err != nil {
+		return nil, err
+	}
+
+	s := NewKVSecretStoreWithClient(client.KV(), prefix)
+	s.SetToken(ACLToken)
+
+	return s, nil
+}
+
+// NewKVSecretStoreWithClient creates a KVSecretStore using the given KV client
+func NewKVSecretStoreWithClient(kv KV, prefix string) *KVSecretStore {
+	return &KVSecretStore{kv: kv, prefix: strings.Trim(prefix, "/")}
+}
+
+// ListSecrets returns the names of the secrets stored under the prefix
+func (s *KVSecretStore) ListSecrets() ([]string, error) {
+	keys, _, err := s.kv.Keys(s.prefix+"/", "/", s.queryOptions())
+	if err != nil {
+		return nil, fmt.Errorf("Error in request to Consul: %s", err)
+	}
+
+	secrets := []string{}
+	for _, k := range keys {
+		name := strings.TrimPrefix(k, s.prefix+"/")
+
+		// keys ending with the separator are folders rather than secrets
+		if name == "" || strings.HasSuffix(name, "/") {
+			continue
+		}
+
+		secrets = append(secrets, name)
+	}
+
+	return secrets, nil
+}
+
+// GetSecretMetadata returns empty metadata, Consul does not version keys
+func (s *KVSecretStore) GetSecretMetadata(name string) (types.SecretMetadata, error) {
+	return types.SecretMetadata{}, nil
+}
+
+// SetSecret creates or updates the value of a secret
+func (s *KVSecretStore) SetSecret(name, value string) error {
+	_, err := s.kv.Put(&api.KVPair{Key: s.key(name), Value: []byte(value)}, s.writeOptions())
+	if err != nil {
+		return fmt.Errorf("Error in request to Consul: %s", err)
+	}
+
+	return nil
+}
+
+// DeleteSecret removes a secret
+func (s *KVSecretStore) DeleteSecret(name string) error {
+	_, err := s.kv.Delete(s.key(name), s.writeOptions())
+	if err != nil {
+		return fmt.Errorf("Error in request to Consul: %s", err)
+	}
+
+	return nil
+}
+
+// SecretTemplate returns the Nomad template which renders the value of a secret
+func (s *KVSecretStore) SecretTemplate(name string) string {
+	return fmt.Sprintf(`{{key "%s"}}`, s.key(name))
+}
+
+// SecretTemplateFormat returns the Nomad template which renders the value of
+// a secret through the format action
+func (s *KVSecretStore) SecretTemplateFormat(name, format string) string {
+	return fmt.Sprintf(format, fmt.Sprintf(`(key "%s")`, s.key(name)))
+}
+
+// Policies returns nil, Consul secrets do not need Vault policies
+func (s *KVSecretStore) Policies() []string {
+	return nil
+}
+
+func (s *KVSecretStore) key(name string) string {
+	return s.prefix + "/" + name
+}
@@ -0,0 +1,52 @@
+package consul
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setupKVSecretStore() (*KVSecretStore, *MockKV) {
+	kv := &MockKV{}
+
+	return NewKVSecretStoreWithClient(kv, "/openfaas/secrets/"), kv
+}
+
+func TestKVSecretStoreListsSecretsIgnoringFolders(t *testing.T) {
+	store, kv := setupKVSecretStore()
+	kv.On("Keys", "openfaas/secrets/", "/").Return(
+		[]string{"openfaas/secrets/", "openfaas/secrets/figlet", "openfaas/secrets/nested/"}, nil)
+
+	secrets, err := store.ListSecrets()
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"figlet"}, secrets)
+}
+
+func TestKVSecretStoreListReturnsError(t *testing.T) {
+	store, kv := setupKVSecretStore()
+	kv.On("Keys", "openfaas/secrets/", "/").Return(nil, fmt.Errorf("boom"))
+
+	_, err := store.ListSecrets()
+
+	assert.NotNil(t, err)
+}
+
+func TestKVSecretStoreSetsAndDeletesSecrets(t *testing.T) {
+	store, kv := setupKVSecretStore()
+	kv.On("Put", "openfaas/secrets/figlet", "abc").Return(nil)
+	kv.On("Delete", "openfaas/secrets/figlet").Return(nil)
+
+	assert.Nil(t, store.SetSecret("figlet", "abc"))
+	assert.Nil(t, store.DeleteSecret("figlet"))
+	kv.AssertExpectations(t)
+}
+
+func TestKVSecretStoreRendersKeyTemplate(t *testing.T) {
+	store, _ := setupKVSecretStore()
+
+	assert.Equal(t, `{{key "openfaas/secrets/figlet"}}`, store.SecretTemplate("figlet"))
+	assert.Equal(t, `{{(key "openfaas/secrets/figlet") | toUpper}}`, store.SecretTemplateFormat("figlet", "{{%s | toUpper}}"))
+	assert.Empty(t, store.Policies())
+}
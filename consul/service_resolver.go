@@ -2,6 +2,8 @@ package consul
 
 import (
 	"fmt"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/consul-template/dependency"
@@ -29,35 +31,55 @@ type CatalogServiceQuery interface {
 // WrappedWatcher wraps watch.View to allow testing
 type WrappedWatcher struct {
 	*watch.Watcher
+	done     chan struct{}
+	stopOnce sync.Once
 }
 
 type iterateFunc func(dep dependency.Dependency, deps []*dependency.CatalogService)
 
-// IterateDataCh returns the list of CatalogService from the View
+// IterateDataCh returns the list of CatalogService from the View until the
+// watcher is stopped
 func (ww *WrappedWatcher) IterateDataCh(f iterateFunc) {
-	for cs := range ww.DataCh() {
-		f(
-			cs.Dependency(),
-			cs.Data().([]*dependency.CatalogService),
-		)
+	for {
+		select {
+		case <-ww.done:
+			return
+		case cs := <-ww.DataCh():
+			f(
+				cs.Dependency(),
+				cs.Data().([]*dependency.CatalogService),
+			)
+		}
 	}
 }
 
+// Stop stops all of the views and ends IterateDataCh, the watcher does not
+// close its data channel so done is used to signal the end of iteration
+func (ww *WrappedWatcher) Stop() {
+	ww.stopOnce.Do(func() {
+		ww.Watcher.Stop()
+		close(ww.done)
+	})
+}
+
 // Watcher is an interface to the Consul Template watcher struct
 type Watcher interface {
 	Add(dependency dependency.Dependency) (bool, error)
 	Remove(dependency dependency.Dependency) bool
 	IterateDataCh(iterateFunc)
+	Stop()
 }
 
 // ServiceResolver uses consul to resolve a function name into addresses
 type ServiceResolver interface {
 	Resolve(function string) ([]string, error)
 	RemoveCacheItem(service string)
+	CachedFunctions() []string
 }
 
 // Resolver implements ServiceResolver
 type Resolver struct {
+	address         string
 	clientSet       *dependency.ClientSet
 	watcher         Watcher
 	cache           *cache.Cache
@@ -86,8 +108,9 @@ func NewResolver(address, ACLToken string, logger hclog.Logger) *Resolver {
 	pc := cache.New(5*time.Minute, 10*time.Minute)
 
 	cr := &Resolver{
+		address:         address,
 		clientSet:       clientSet,
-		watcher:         &WrappedWatcher{watch},
+		watcher:         &WrappedWatcher{Watcher: watch, done: make(chan struct{})},
 		cache:           pc,
 		getServiceQuery: createServiceQueryImpl,
 		logger:          logger,
@@ -98,6 +121,19 @@ func NewResolver(address, ACLToken string, logger hclog.Logger) *Resolver {
 	return cr
 }
 
+// Stop stops watching Consul for changes to the services in the cache
+func (sr *Resolver) Stop() {
+	sr.watcher.Stop()
+}
+
+// SetToken replaces the ACL token used to query Consul
+func (sr *Resolver) SetToken(ACLToken string) error {
+	return sr.clientSet.CreateConsulClient(&dependency.CreateConsulClientInput{
+		Address: sr.address,
+		Token:   ACLToken,
+	})
+}
+
 // createServiceQueryImpl allows the mocking of the process to create a consul service query
 func createServiceQueryImpl(function string) (CatalogServiceQuery, error) {
 	return dependency.NewCatalogServiceQuery(function)
@@ -138,6 +174,18 @@ func (sr *Resolver) RemoveCacheItem(function string) {
 	}
 }
 
+// CachedFunctions returns the names of the functions which are held in the cache
+func (sr *Resolver) CachedFunctions() []string {
+	functions := []string{}
+	for k := range sr.cache.Items() {
+		if strings.HasPrefix(k, cacheKeyPrefix) && strings.HasSuffix(k, ")") {
+			functions = append(functions, strings.TrimSuffix(strings.TrimPrefix(k, cacheKeyPrefix), ")"))
+		}
+	}
+
+	return functions
+}
+
 // watch watches consul for changes and updates the cache on change
 func (sr *Resolver) watch() {
 	sr.watcher.IterateDataCh(
@@ -182,6 +230,8 @@ func (sr *Resolver) upsertCache(dep dependency.Dependency, value []string) {
 	}, cache.NoExpiration)
 }
 
+const cacheKeyPrefix = "catalog.service("
+
 func getCacheKey(function string) string {
-	return fmt.Sprintf("catalog.service(%s)", function)
+	return fmt.Sprintf("%s%s)", cacheKeyPrefix, function)
 }
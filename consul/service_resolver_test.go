@@ -29,6 +29,7 @@ func setup(t *testing.T, queryError error) (*Resolver, *MockWatcher, *cache.Cach
 	watcher.On("Add", mock.Anything).Return(true, nil)
 	watcher.On("Remove", mock.Anything).Return(true)
 	watcher.On("IterateDataCh", mock.Anything).Return(serviceQuery)
+	watcher.On("Stop")
 
 	pc := cache.New(5*time.Minute, 10*time.Minute)
 	return &Resolver{
@@ -143,6 +144,27 @@ func TestWatchWithNewServicesUpdatesCatalog(t *testing.T) {
 	assert.Equal(t, "http://mynewaddress:8081", addr[0])
 }
 
+func TestStopEndsWatch(t *testing.T) {
+	r, w, _, _ := setup(t, nil)
+
+	done := make(chan struct{})
+	go func() {
+		r.watch()
+		close(done)
+	}()
+
+	time.Sleep(1 * time.Millisecond)
+	r.Stop()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("watch did not return after Stop")
+	}
+
+	w.AssertCalled(t, "Stop")
+}
+
 func TestRemoveCacheItemRemovesFromCache(t *testing.T) {
 	r, _, c, _ := setup(t, nil)
 
@@ -152,3 +174,11 @@ func TestRemoveCacheItemRemovesFromCache(t *testing.T) {
 
 	assert.False(t, ok)
 }
+
+func TestCachedFunctionsReturnsFunctionNames(t *testing.T) {
+	r, _, _, _ := setup(t, nil)
+
+	r.Resolve("test")
+
+	assert.Equal(t, []string{"test"}, r.CachedFunctions())
+}
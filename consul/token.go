@@ -0,0 +1,36 @@
+package consul
+
+import (
+	"sync"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// liveToken is an ACL token which is sent with each request so that it can
+// be replaced when the configuration is reloaded
+type liveToken struct {
+	mutex sync.RWMutex
+	token string
+}
+
+// SetToken replaces the ACL token used by later requests
+func (t *liveToken) SetToken(ACLToken string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.token = ACLToken
+}
+
+func (t *liveToken) queryOptions() *api.QueryOptions {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	return &api.QueryOptions{Token: t.token}
+}
+
+func (t *liveToken) writeOptions() *api.WriteOptions {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	return &api.WriteOptions{Token: t.token}
+}
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"sync"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// BackendVersions contains the versions of the services the provider is connected to
+type BackendVersions struct {
+	Nomad  string `json:"nomad"`
+	Consul string `json:"consul"`
+	Vault  string `json:"vault"`
+}
+
+// BackendVersionSource returns the last known versions of the backend services
+type BackendVersionSource interface {
+	Versions() BackendVersions
+}
+
+// VersionFetcher queries a backend service for its version
+type VersionFetcher func() (string, error)
+
+// BackendVersionCache periodically refreshes the backend versions so that
+// they are not queried on every call to the info endpoint
+type BackendVersionCache struct {
+	nomad    VersionFetcher
+	consul   VersionFetcher
+	vault    VersionFetcher
+	logger   hclog.Logger
+	mutex    sync.RWMutex
+	versions BackendVersions
+	stop     chan struct{}
+}
+
+// NewBackendVersionCache creates a new BackendVersionCache, fetchers can be nil
+// when a backend is not configured
+func NewBackendVersionCache(nomad, consul, vault VersionFetcher, logger hclog.Logger) *BackendVersionCache {
+	return &BackendVersionCache{
+		nomad:  nomad,
+		consul: consul,
+		vault:  vault,
+		logger: logger.Named("backend_versions"),
+		stop:   make(chan struct{}),
+	}
+}
+
+// Start refreshes the versions immediately and then at the given interval
+// until Stop is called
+func (c *BackendVersionCache) Start(interval time.Duration) {
+	c.Refresh()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				c.Refresh()
+			case <-c.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the periodic refresh
+func (c *BackendVersionCache) Stop() {
+	close(c.stop)
+}
+
+// Refresh queries every backend for its version, the previous value is kept
+// when a backend can not be reached
+func (c *BackendVersionCache) Refresh() {
+	c.mutex.RLock()
+	versions := c.versions
+	c.mutex.RUnlock()
+
+	versions.Nomad = c.fetch("nomad", c.nomad, versions.Nomad)
+	versions.Consul = c.fetch("consul", c.consul, versions.Consul)
+	versions.Vault = c.fetch("vault", c.vault, versions.Vault)
+
+	c.mutex.Lock()
+	c.versions = versions
+	c.mutex.Unlock()
+}
+
+// Versions returns the last known backend versions
+func (c *BackendVersionCache) Versions() BackendVersions {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.versions
+}
+
+func (c *BackendVersionCache) fetch(name string, f VersionFetcher, previous string) string {
+	if f == nil {
+		return previous
+	}
+
+	v, err := f()
+	if err != nil {
+		c.logger.Warn("Unable to fetch version", "backend", name, "error", err)
+		return previous
+	}
+
+	return v
+}
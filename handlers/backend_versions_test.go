@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"fmt"
+	"testing"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackendVersionCacheRefreshesVersions(t *testing.T) {
+	c := NewBackendVersionCache(
+		func() (string, error) { return "0.8.4", nil },
+		func() (string, error) { return "1.2.0", nil },
+		nil,
+		hclog.Default(),
+	)
+
+	c.Refresh()
+
+	assert.Equal(t, BackendVersions{Nomad: "0.8.4", Consul: "1.2.0"}, c.Versions())
+}
+
+func TestBackendVersionCacheKeepsPreviousVersionOnError(t *testing.T) {
+	calls := 0
+	c := NewBackendVersionCache(
+		func() (string, error) {
+			calls++
+			if calls > 1 {
+				return "", fmt.Errorf("boom")
+			}
+			return "0.8.4", nil
+		},
+		nil,
+		nil,
+		hclog.Default(),
+	)
+
+	c.Refresh()
+	c.Refresh()
+
+	assert.Equal(t, "0.8.4", c.Versions().Nomad)
+}
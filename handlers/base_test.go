@@ -4,12 +4,14 @@ import (
 	"encoding/json"
 
 	"github.com/hashicorp/faas-nomad/consul"
+	"github.com/hashicorp/faas-nomad/metrics"
 	"github.com/hashicorp/faas-nomad/nomad"
 	"github.com/openfaas/faas/gateway/requests"
 )
 
 var mockJob *nomad.MockJob
 var mockServiceResolver *consul.MockResolver
+var invocations *metrics.Invocations
 
 type testFunctionRequest struct {
 	requests.CreateFunctionRequest
@@ -4,18 +4,47 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
+	"time"
 
+	"github.com/hashicorp/faas-nomad/audit"
+	"github.com/hashicorp/faas-nomad/auth"
 	"github.com/hashicorp/faas-nomad/consul"
 	"github.com/hashicorp/faas-nomad/metrics"
 	"github.com/hashicorp/faas-nomad/nomad"
 	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
 	"github.com/openfaas/faas/gateway/requests"
 )
 
 const functionNamespace string = "default"
 
-// MakeDelete creates a handler for deploying functions
-func MakeDelete(sr consul.ServiceResolver, client nomad.Job, logger hclog.Logger, stats metrics.StatsD) http.HandlerFunc {
+var allocationPollInterval = 500 * time.Millisecond
+
+// DeleteConfig controls how functions are drained and stopped on deletion
+type DeleteConfig struct {
+	// DrainTimeout is the maximum time to wait for in-flight requests to complete
+	DrainTimeout time.Duration
+	// WaitForStop waits for all of the function allocations to stop before returning
+	WaitForStop bool
+	// StopTimeout is the maximum time to wait for the allocations to stop
+	StopTimeout time.Duration
+}
+
+// DeleteResponse reports the outcome of draining and stopping a function
+type DeleteResponse struct {
+	Function string `json:"function"`
+	// Drained is true when all in-flight requests completed before the deadline
+	Drained bool `json:"drained"`
+	// InFlight is the number of requests still running when the function was deregistered
+	InFlight int `json:"inFlight"`
+	// Stopped is true when all allocations stopped before the deadline, it is
+	// only set when waiting for allocations is enabled
+	Stopped *bool `json:"stopped,omitempty"`
+}
+
+// MakeDelete creates a handler for deleting functions, new requests to the
+// function are rejected while in-flight requests are drained
+func MakeDelete(sr consul.ServiceResolver, client nomad.Job, tracker *RequestTracker, config DeleteConfig, logger hclog.Logger, stats metrics.StatsD) http.HandlerFunc {
 	log := logger.Named("delete_handler")
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -27,6 +56,12 @@ func MakeDelete(sr consul.ServiceResolver, client nomad.Job, logger hclog.Logger
 
 		req := requests.DeleteFunctionRequest{}
 		err := json.Unmarshal(body, &req)
+
+		// use the name which the request was authorized for
+		if name, ok := auth.FunctionFromContext(r.Context()); ok {
+			req.FunctionName = name
+		}
+
 		if err != nil || req.FunctionName == "" {
 			w.WriteHeader(http.StatusBadRequest)
 
@@ -35,11 +70,28 @@ func MakeDelete(sr consul.ServiceResolver, client nomad.Job, logger hclog.Logger
 			return
 		}
 
+		log.Info("Draining function", "function", req.FunctionName)
+
+		resp := DeleteResponse{Function: req.FunctionName}
+
+		// stop new requests reaching the function and allow the in-flight
+		// requests to complete, new requests are rejected until the
+		// allocations have stopped
+		resp.Drained = waitForDrain(tracker.Drain(req.FunctionName), config.DrainTimeout)
+		if !resp.Drained {
+			resp.InFlight = tracker.InFlight(req.FunctionName)
+
+			log.Warn("Timeout draining function", "function", req.FunctionName, "in_flight", resp.InFlight)
+			stats.Incr("delete.error.draintimeout", []string{"job:" + req.FunctionName}, 1)
+		}
+
 		log.Info("Deleting function", "function", req.FunctionName)
 
 		// Delete job /v1/jobs
-		_, _, err = client.Deregister(nomad.JobPrefix+req.FunctionName, false, nil)
+		evalID, _, err := client.Deregister(nomad.JobPrefix+req.FunctionName, false, nil)
 		if err != nil {
+			tracker.Resume(req.FunctionName)
+
 			w.WriteHeader(http.StatusBadRequest)
 			w.Write([]byte(err.Error()))
 
@@ -48,9 +100,103 @@ func MakeDelete(sr consul.ServiceResolver, client nomad.Job, logger hclog.Logger
 			return
 		}
 
+		audit.SetNomadResult(r.Context(), evalID, 0)
+
+		// runs of a scheduled or dispatched function are separate jobs which
+		// continue after the function job is deregistered
+		if err := deregisterChildJobs(client, nomad.JobPrefix+req.FunctionName); err != nil {
+			log.Warn("Error stopping scheduled or dispatched runs", "function", req.FunctionName, "error", err)
+			stats.Incr("delete.error.children", []string{"job:" + req.FunctionName}, 1)
+		}
+
+		if config.WaitForStop {
+			stopped := waitForAllocationsToStop(client, nomad.JobPrefix+req.FunctionName, config.StopTimeout)
+			resp.Stopped = &stopped
+			tracker.Resume(req.FunctionName)
+
+			if !stopped {
+				log.Warn("Timeout waiting for allocations to stop", "function", req.FunctionName)
+				stats.Incr("delete.error.stoptimeout", []string{"job:" + req.FunctionName}, 1)
+			}
+		} else {
+			go func(function string) {
+				waitForAllocationsToStop(client, nomad.JobPrefix+function, config.StopTimeout)
+				tracker.Resume(function)
+			}(req.FunctionName)
+		}
+
 		sr.RemoveCacheItem(req.FunctionName)
 
 		stats.Gauge("deploy.count", 0, []string{"job:" + req.FunctionName}, 1)
 		stats.Incr("delete.success", []string{"job:" + req.FunctionName}, 1)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(resp)
 	}
 }
+
+// deregisterChildJobs stops the jobs launched by a periodic or parameterized job
+func deregisterChildJobs(client nomad.Job, jobID string) error {
+	options := &api.QueryOptions{}
+	options.Prefix = jobID + "/"
+
+	jobs, _, err := client.List(options)
+	if err != nil {
+		return err
+	}
+
+	for _, j := range jobs {
+		if j.ParentID != jobID || j.Status == "dead" {
+			continue
+		}
+
+		if _, _, err := client.Deregister(j.ID, false, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// waitForDrain waits for the drained channel to close, it returns false if the
+// timeout is reached first
+func waitForDrain(drained <-chan struct{}, timeout time.Duration) bool {
+	select {
+	case <-drained:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// waitForAllocationsToStop polls the job allocations until they are all in a
+// terminal state, it returns false if the timeout is reached first
+func waitForAllocationsToStop(client nomad.Job, jobID string, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		allocs, _, err := client.Allocations(jobID, true, nil)
+		if err == nil && allocationsStopped(allocs) {
+			return true
+		}
+
+		if time.Now().Add(allocationPollInterval).After(deadline) {
+			return false
+		}
+
+		time.Sleep(allocationPollInterval)
+	}
+}
+
+func allocationsStopped(allocs []*api.AllocationListStub) bool {
+	for _, a := range allocs {
+		switch a.ClientStatus {
+		case "complete", "failed", "lost":
+		default:
+			return false
+		}
+	}
+
+	return true
+}
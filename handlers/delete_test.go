@@ -2,21 +2,32 @@ package handlers
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/faas-nomad/consul"
 	"github.com/hashicorp/faas-nomad/metrics"
 	"github.com/hashicorp/faas-nomad/nomad"
 	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
 
+var deleteTracker *RequestTracker
+var deleteConfig = DeleteConfig{DrainTimeout: 50 * time.Millisecond}
+
 func setupDelete(body string) (http.HandlerFunc, *httptest.ResponseRecorder, *http.Request) {
+	return setupDeleteWithChildren(body, []*api.JobListStub{})
+}
+
+func setupDeleteWithChildren(body string, children []*api.JobListStub) (http.HandlerFunc, *httptest.ResponseRecorder, *http.Request) {
 	mockJob = &nomad.MockJob{}
+	mockJob.On("List", mock.Anything).Return(children, nil, nil)
 	mockStats := &metrics.MockStatsD{}
 	mockStats.On("Incr", mock.Anything, mock.Anything, mock.Anything)
 	mockStats.On("Gauge", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
@@ -26,7 +37,11 @@ func setupDelete(body string) (http.HandlerFunc, *httptest.ResponseRecorder, *ht
 
 	logger := hclog.Default()
 
-	return MakeDelete(mockServiceResolver, mockJob, logger, mockStats),
+	// override the poll interval to improve test speed
+	allocationPollInterval = 1 * time.Millisecond
+	deleteTracker = NewRequestTracker()
+
+	return MakeDelete(mockServiceResolver, mockJob, deleteTracker, deleteConfig, logger, mockStats),
 		httptest.NewRecorder(),
 		httptest.NewRequest("DELETE", "/system/functions", bytes.NewReader([]byte(body)))
 }
@@ -42,6 +57,7 @@ func TestDeleteHandlerReturnsErrorOnInvalidRequest(t *testing.T) {
 func TestDeleteHandlerDeletesJob(t *testing.T) {
 	h, rw, r := setupDelete(deleteRequest())
 	mockJob.On("Deregister", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+	mockJob.On("Allocations", mock.Anything, true, mock.Anything).Return(nil, nil, nil)
 
 	h(rw, r)
 
@@ -62,9 +78,144 @@ func TestDeleteHandlerReturnsErrorOnAPIError(t *testing.T) {
 func TestDeleteHandlerClearsConsulCache(t *testing.T) {
 	h, rw, r := setupDelete(deleteRequest())
 	mockJob.On("Deregister", nomad.JobPrefix+"TestFunction", mock.Anything, mock.Anything).Return(nil, nil, nil)
+	mockJob.On("Allocations", mock.Anything, true, mock.Anything).Return(nil, nil, nil)
 
 	h(rw, r)
 
 	mockServiceResolver.AssertCalled(t, "RemoveCacheItem", "TestFunction")
 	assert.Equal(t, http.StatusOK, rw.Code)
 }
+
+func TestDeleteHandlerWaitsForInFlightRequests(t *testing.T) {
+	h, rw, r := setupDelete(deleteRequest())
+	mockJob.On("Deregister", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+	mockJob.On("Allocations", mock.Anything, true, mock.Anything).Return(nil, nil, nil)
+
+	deleteTracker.Start("TestFunction")
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		deleteTracker.Done("TestFunction")
+	}()
+
+	h(rw, r)
+
+	resp := DeleteResponse{}
+	json.NewDecoder(rw.Body).Decode(&resp)
+
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.True(t, resp.Drained)
+	assert.Equal(t, 0, resp.InFlight)
+}
+
+func TestDeleteHandlerDeregistersAfterDrainTimeout(t *testing.T) {
+	h, rw, r := setupDelete(deleteRequest())
+	mockJob.On("Deregister", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+	mockJob.On("Allocations", mock.Anything, true, mock.Anything).Return(nil, nil, nil)
+
+	deleteTracker.Start("TestFunction")
+
+	h(rw, r)
+
+	resp := DeleteResponse{}
+	json.NewDecoder(rw.Body).Decode(&resp)
+
+	mockJob.AssertCalled(t, "Deregister", nomad.JobPrefix+"TestFunction", false, mock.Anything)
+	assert.False(t, resp.Drained)
+	assert.Equal(t, 1, resp.InFlight)
+}
+
+func TestDeleteHandlerResumesFunctionAfterAllocationsStop(t *testing.T) {
+	h, rw, r := setupDelete(deleteRequest())
+	mockJob.On("Deregister", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+	mockJob.On("Allocations", nomad.JobPrefix+"TestFunction", true, mock.Anything).Return(
+		[]*api.AllocationListStub{{ClientStatus: "complete"}}, nil, nil)
+
+	h(rw, r)
+
+	assert.Eventually(t, func() bool {
+		return deleteTracker.Start("TestFunction")
+	}, time.Second, time.Millisecond)
+}
+
+func TestDeleteHandlerKeepsDrainingWhileAllocationsStop(t *testing.T) {
+	deleteConfig.StopTimeout = 50 * time.Millisecond
+	defer func() { deleteConfig.StopTimeout = 0 }()
+
+	h, rw, r := setupDelete(deleteRequest())
+	mockJob.On("Deregister", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+	mockJob.On("Allocations", nomad.JobPrefix+"TestFunction", true, mock.Anything).Return(
+		[]*api.AllocationListStub{{ClientStatus: "running"}}, nil, nil)
+
+	h(rw, r)
+
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.False(t, deleteTracker.Start("TestFunction"))
+
+	// the function resumes once the stop timeout has passed
+	assert.Eventually(t, func() bool {
+		return deleteTracker.Start("TestFunction")
+	}, time.Second, time.Millisecond)
+}
+
+func TestDeleteHandlerResumesFunctionWhenDeregisterFails(t *testing.T) {
+	h, rw, r := setupDelete(deleteRequest())
+	mockJob.On("Deregister", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, fmt.Errorf("Error"))
+
+	h(rw, r)
+
+	assert.True(t, deleteTracker.Start("TestFunction"))
+}
+
+func TestDeleteHandlerWaitsForAllocationsToStop(t *testing.T) {
+	deleteConfig.WaitForStop = true
+	deleteConfig.StopTimeout = 50 * time.Millisecond
+	defer func() { deleteConfig.WaitForStop = false }()
+
+	h, rw, r := setupDelete(deleteRequest())
+	mockJob.On("Deregister", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+	mockJob.On("Allocations", nomad.JobPrefix+"TestFunction", true, mock.Anything).Return(
+		[]*api.AllocationListStub{{ClientStatus: "running"}}, nil, nil).Once()
+	mockJob.On("Allocations", nomad.JobPrefix+"TestFunction", true, mock.Anything).Return(
+		[]*api.AllocationListStub{{ClientStatus: "complete"}}, nil, nil)
+
+	h(rw, r)
+
+	resp := DeleteResponse{}
+	json.NewDecoder(rw.Body).Decode(&resp)
+
+	assert.True(t, *resp.Stopped)
+}
+
+func TestDeleteHandlerReportsAllocationsNotStopped(t *testing.T) {
+	deleteConfig.WaitForStop = true
+	deleteConfig.StopTimeout = 5 * time.Millisecond
+	defer func() { deleteConfig.WaitForStop = false }()
+
+	h, rw, r := setupDelete(deleteRequest())
+	mockJob.On("Deregister", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+	mockJob.On("Allocations", nomad.JobPrefix+"TestFunction", true, mock.Anything).Return(
+		[]*api.AllocationListStub{{ClientStatus: "running"}}, nil, nil)
+
+	h(rw, r)
+
+	resp := DeleteResponse{}
+	json.NewDecoder(rw.Body).Decode(&resp)
+
+	assert.False(t, *resp.Stopped)
+}
+
+func TestDeleteHandlerStopsRunningScheduledRuns(t *testing.T) {
+	parent := nomad.JobPrefix + "TestFunction"
+	h, rw, r := setupDeleteWithChildren(deleteRequest(), []*api.JobListStub{
+		&api.JobListStub{ID: parent + "/periodic-1", ParentID: parent, Status: "running"},
+		&api.JobListStub{ID: parent + "/periodic-0", ParentID: parent, Status: "dead"},
+	})
+	mockJob.On("Deregister", mock.Anything, mock.Anything, mock.Anything).Return("", nil, nil)
+	mockJob.On("Allocations", mock.Anything, true, mock.Anything).Return(nil, nil, nil)
+
+	h(rw, r)
+
+	assert.Equal(t, http.StatusOK, rw.Code)
+	mockJob.AssertCalled(t, "Deregister", parent+"/periodic-1", false, mock.Anything)
+	mockJob.AssertNotCalled(t, "Deregister", parent+"/periodic-0", false, mock.Anything)
+}
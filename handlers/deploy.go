@@ -1,17 +1,19 @@
 package handlers
 
 import (
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"path"
 	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/hashicorp/faas-nomad/audit"
+	"github.com/hashicorp/faas-nomad/auth"
 	"github.com/hashicorp/faas-nomad/metrics"
 	"github.com/hashicorp/faas-nomad/nomad"
 	"github.com/hashicorp/faas-nomad/types"
@@ -29,14 +31,23 @@ var (
 	logSize           = 2
 	ephemeralDiskSize = 20
 
+	// a failed scheduled or dispatched run is retried and then left as failed
+	batchRestartMode     = "fail"
+	batchRestartAttempts = 2
+
 	// Constraints
 	taskMemory = 128
+	taskCPU    = 100
 
 	// Update Strategy
 	updateAutoRevert      = true
 	updateMinHealthyTime  = 5 * time.Second
 	updateHealthyDeadline = 20 * time.Second
 	updateStagger         = 5 * time.Second
+
+	// secretEnvDestPath is outside of the secrets directory so that it is not
+	// mounted into the function container
+	secretEnvDestPath = "local/secrets.env"
 )
 
 // MakeDeploy creates a handler for deploying functions
@@ -59,8 +70,34 @@ func MakeDeploy(client nomad.Job, providerConfig types.ProviderConfig, logger hc
 			return
 		}
 
+		// use the name which the request was authorized for
+		if name, ok := auth.FunctionFromContext(r.Context()); ok {
+			req.Service = name
+		}
+
+		job, err := createJob(req, providerConfig)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+
+			log.Error("Invalid function request", "error", err.Error())
+			stats.Incr("deploy.error.badrequest", []string{"job:" + req.Service}, 1)
+			return
+		}
+
+		// templates can create jobs which Nomad rejects, validate the merged
+		// job so that the error is returned before anything is registered
+		if providerConfig.JobTemplates != nil {
+			if err := validateJob(client, job); err != nil {
+				writeJSONError(w, http.StatusBadRequest, err)
+
+				log.Error("Invalid job after applying templates", "function", req.Service, "error", err.Error())
+				stats.Incr("deploy.error.validate", []string{"job:" + req.Service}, 1)
+				return
+			}
+		}
+
 		// Create job /v1/jobs
-		_, _, err = client.Register(createJob(req, providerConfig), nil)
+		resp, _, err := client.Register(job, nil)
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			w.Write([]byte(err.Error()))
@@ -70,18 +107,71 @@ func MakeDeploy(client nomad.Job, providerConfig types.ProviderConfig, logger hc
 			return
 		}
 
+		if resp != nil {
+			audit.SetNomadResult(r.Context(), resp.EvalID, resp.JobModifyIndex)
+		}
+
 		stats.Incr("deploy.success", []string{"job:" + req.Service}, 1)
 		stats.Gauge("deploy.count", 1, []string{"job:" + req.Service}, 1)
 	}
 }
 
-func createJob(r requests.CreateFunctionRequest, providerConfig types.ProviderConfig) *api.Job {
+func createJob(r requests.CreateFunctionRequest, providerConfig types.ProviderConfig) (*api.Job, error) {
 	jobname := nomad.JobPrefix + r.Service
-	job := api.NewServiceJob(jobname, jobname, "global", 1)
+
+	// the spec is encoded before the task env adds fprocess to the env vars
+	spec, err := encodeFunctionSpec(r)
+	if err != nil {
+		return nil, err
+	}
+
+	periodic, err := nomad.ParsePeriodic(createAnnotations(r))
+	if err != nil {
+		return nil, err
+	}
+
+	dispatch, err := nomad.ParseDispatch(createAnnotations(r))
+	if err != nil {
+		return nil, err
+	}
+
+	if periodic != nil && dispatch {
+		return nil, fmt.Errorf("Functions can not set both %s and %s", nomad.ScheduleAnnotation, nomad.DispatchAnnotation)
+	}
+
+	connect, err := nomad.ParseConnect(createAnnotations(r))
+	if err != nil {
+		return nil, err
+	}
+
+	if connect && (periodic != nil || dispatch) {
+		return nil, fmt.Errorf("Scheduled and dispatched functions can not use %s", nomad.ConnectAnnotation)
+	}
+
+	if connect && providerConfig.Connect == nil {
+		return nil, fmt.Errorf("Connect is not supported, the provider is not configured for Consul Connect")
+	}
+
+	var job *api.Job
+	switch {
+	case periodic != nil:
+		// scheduled functions run to completion and are not resident
+		job = api.NewBatchJob(jobname, jobname, "global", 1)
+		job.Periodic = periodic
+	case dispatch:
+		// dispatched functions run once for each invocation with the request
+		// body as the payload
+		job = api.NewBatchJob(jobname, jobname, "global", 1)
+		job.ParameterizedJob = &api.ParameterizedJobConfig{
+			Payload: "optional",
+		}
+	default:
+		job = api.NewServiceJob(jobname, jobname, "global", 1)
+		job.Update = createUpdateStrategy()
+	}
 
 	job.Meta = createAnnotations(r)
 	job.Datacenters = createDataCenters(r, providerConfig.Datacenter)
-	job.Update = createUpdateStrategy()
 
 	// add constraints
 	job.Constraints = append(job.Constraints, createConstraints(r)...)
@@ -91,38 +181,110 @@ func createJob(r requests.CreateFunctionRequest, providerConfig types.ProviderCo
 		job.Constraints = append(job.Constraints, cpuArchConstraint)
 	}
 
-	job.TaskGroups = createTaskGroup(r, providerConfig)
+	taskGroups, err := createTaskGroup(r, providerConfig)
+	if err != nil {
+		return nil, err
+	}
 
-	return job
+	if periodic != nil || dispatch {
+		if err := configureBatchTaskGroup(taskGroups[0], dispatch); err != nil {
+			return nil, err
+		}
+	}
+
+	if connect {
+		configureConnect(taskGroups[0], providerConfig.Connect)
+	}
+
+	job.TaskGroups = taskGroups
+
+	if providerConfig.JobTemplates != nil {
+		if err := providerConfig.JobTemplates.Apply(job, createAnnotations(r)); err != nil {
+			return nil, err
+		}
+	}
+
+	// the spec is added last so that a job template can not replace it
+	if job.Meta == nil {
+		job.Meta = map[string]string{}
+	}
+	job.Meta[nomad.FunctionSpecMeta] = spec
+
+	return job, nil
+}
+
+// validateJob asks Nomad to validate the job without registering it
+func validateJob(client nomad.Job, job *api.Job) error {
+	resp, _, err := client.Validate(job, nil)
+	if err != nil {
+		return err
+	}
+
+	if len(resp.ValidationErrors) > 0 {
+		return fmt.Errorf("Job validation failed: %s", strings.Join(resp.ValidationErrors, ", "))
+	}
+
+	if resp.Error != "" {
+		return fmt.Errorf("Job validation failed: %s", resp.Error)
+	}
+
+	return nil
 }
 
-func createTaskGroup(r requests.CreateFunctionRequest, providerConfig types.ProviderConfig) []*api.TaskGroup {
+func createTaskGroup(r requests.CreateFunctionRequest, providerConfig types.ProviderConfig) ([]*api.TaskGroup, error) {
 	count := 1
 	restartDelay := 1 * time.Second
 	restartMode := "delay"
 	restartAttempts := 25
-	task := createTask(r, providerConfig)
-
-	return []*api.TaskGroup{
-		&api.TaskGroup{
-			Name:  &r.Service,
-			Count: &count,
-			RestartPolicy: &api.RestartPolicy{
-				Delay:    &restartDelay,
-				Mode:     &restartMode,
-				Attempts: &restartAttempts,
-			},
-			EphemeralDisk: &api.EphemeralDisk{
-				SizeMB: &ephemeralDiskSize,
-			},
-			Tasks: []*api.Task{task},
+	task, err := createTask(r, providerConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	group := &api.TaskGroup{
+		Name:  &r.Service,
+		Count: &count,
+		RestartPolicy: &api.RestartPolicy{
+			Delay:    &restartDelay,
+			Mode:     &restartMode,
+			Attempts: &restartAttempts,
+		},
+		EphemeralDisk: &api.EphemeralDisk{
+			SizeMB: &ephemeralDiskSize,
 		},
+		Tasks: []*api.Task{task},
 	}
+
+	if err := mountVolumes(group, createAnnotations(r), providerConfig.Volumes); err != nil {
+		return nil, err
+	}
+
+	return []*api.TaskGroup{group}, nil
 }
 
-func createTask(r requests.CreateFunctionRequest, providerConfig types.ProviderConfig) *api.Task {
+func createTask(r requests.CreateFunctionRequest, providerConfig types.ProviderConfig) (*api.Task, error) {
 	envVars := createEnvVars(r)
 
+	if err := validateSecretNames(r.Secrets); err != nil {
+		return nil, err
+	}
+
+	secretEnv, err := nomad.ParseSecretEnv(createAnnotations(r))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range secretEnv {
+		if err := validateSecretName(s); err != nil {
+			return nil, err
+		}
+	}
+
+	changeMode, changeSignal, err := createSecretChangeMode(r, providerConfig)
+	if err != nil {
+		return nil, err
+	}
+
 	var task api.Task
 	task = api.Task{
 		Name:   r.Service,
@@ -134,7 +296,7 @@ func createTask(r requests.CreateFunctionRequest, providerConfig types.ProviderC
 			},
 			"labels": createLabels(r),
 		},
-		Resources: createResources(r),
+		Resources: createResources(r, providerConfig.Defaults),
 		Services: []*api.Service{
 			&api.Service{
 				Name:      r.Service,
@@ -151,25 +313,144 @@ func createTask(r requests.CreateFunctionRequest, providerConfig types.ProviderC
 
 	task.Config["dns_servers"] = parseDNSServers(envVars, providerConfig)
 
+	if (len(r.Secrets) > 0 || len(secretEnv) > 0) && providerConfig.Secrets == nil {
+		return nil, fmt.Errorf("Secrets are not supported, no secret store is configured")
+	}
+
 	if len(r.Secrets) > 0 {
 		task.Config["volumes"] = createSecretVolumes(r.Secrets)
-		task.Templates = createSecrets(providerConfig.Vault.SecretPathPrefix, r.Secrets)
-		// TODO: check function annotations for vault policies
-		task.Vault = &api.Vault{
-			Policies: []string{providerConfig.Vault.DefaultPolicy},
+		task.Templates = createSecrets(providerConfig.Secrets, r.Secrets, changeMode, changeSignal)
+	}
+
+	if len(secretEnv) > 0 {
+		task.Templates = append(task.Templates, createSecretEnv(providerConfig.Secrets, secretEnv, changeMode, changeSignal))
+	}
+
+	if err := configureRegistryAuth(&task, r); err != nil {
+		return nil, err
+	}
+
+	if len(task.Templates) > 0 {
+		if policies := providerConfig.Secrets.Policies(); len(policies) > 0 {
+			// TODO: check function annotations for vault policies
+			task.Vault = &api.Vault{
+				Policies: policies,
+			}
 		}
 	}
 
-	if len(r.RegistryAuth) > 0 {
-		decoded, _ := base64.StdEncoding.DecodeString(r.RegistryAuth)
-		auth := strings.Split(string(decoded), ":")
-		task.Config["auth"] = []map[string]interface{}{
-			map[string]interface{}{
-				"username": auth[0],
-				"password": auth[1]},
+	return &task, nil
+}
+
+// configureRegistryAuth sets the inline credentials used to pull the function
+// image.  Credentials are otherwise resolved by the Docker driver on the
+// client, from its auth config file or credential helper, so that they are
+// neither stored in the job nor visible to the function.
+func configureRegistryAuth(task *api.Task, r requests.CreateFunctionRequest) error {
+	if r.RegistryAuth == "" {
+		return nil
+	}
+
+	username, password, err := parseRegistryAuth(r.RegistryAuth)
+	if err != nil {
+		return err
+	}
+
+	task.Config["auth"] = []map[string]interface{}{
+		map[string]interface{}{
+			"username": username,
+			"password": password},
+	}
+
+	return nil
+}
+
+// configureBatchTaskGroup changes a function task group so that it runs the
+// fprocess command once rather than the watchdog, the task does not listen
+// for requests so the port and service are removed. A scheduled function runs
+// fprocess directly, split on spaces as the watchdog does, so the image does
+// not need a shell. A dispatched function reads the payload on stdin and
+// writes its output to the allocation directory where the result handler can
+// read it, the redirection is done by sh which must be in the image.
+func configureBatchTaskGroup(group *api.TaskGroup, dispatch bool) error {
+	task := group.Tasks[0]
+
+	fprocess := strings.Fields(task.Env["fprocess"])
+	if len(fprocess) == 0 {
+		return fmt.Errorf("Scheduled and dispatched functions must set fprocess")
+	}
+
+	if dispatch {
+		command := fmt.Sprintf(
+			`cat "$NOMAD_TASK_DIR/%s" 2>/dev/null | (%s) > "$NOMAD_ALLOC_DIR/%s"`,
+			nomad.DispatchPayloadFile, task.Env["fprocess"], path.Base(nomad.DispatchOutputPath),
+		)
+
+		task.Config["command"] = "sh"
+		task.Config["args"] = []string{"-c", command}
+		task.DispatchPayload = &api.DispatchPayloadConfig{File: nomad.DispatchPayloadFile}
+	} else {
+		task.Config["command"] = fprocess[0]
+		task.Config["args"] = fprocess[1:]
+	}
+
+	delete(task.Config, "port_map")
+
+	task.Services = nil
+	task.Resources.Networks = nil
+
+	group.RestartPolicy = &api.RestartPolicy{
+		Delay:    &restartDelay,
+		Mode:     &batchRestartMode,
+		Attempts: &batchRestartAttempts,
+	}
+
+	return nil
+}
+
+// mountVolumes adds the volumes requested by the function annotations
+func mountVolumes(group *api.TaskGroup, annotations map[string]string, volumes types.VolumeMounter) error {
+	if strings.TrimSpace(annotations[nomad.VolumesAnnotation]) == "" {
+		return nil
+	}
+
+	if volumes == nil {
+		return fmt.Errorf("Volumes are not supported, no volume allowlist is configured")
+	}
+
+	return volumes.Mount(group, annotations)
+}
+
+// configureConnect moves the function into a bridge network namespace and
+// registers it as a group service with a Connect sidecar.  Nomad injects the
+// Envoy sidecar task, which accepts mTLS connections on a dynamic port and
+// forwards them to the function on the loopback interface.  The function port
+// is not published on the host, so it can only be called through the proxy.
+func configureConnect(group *api.TaskGroup, config *types.ConnectConfig) {
+	function := group.Tasks[0]
+
+	delete(function.Config, "port_map")
+	function.Services = nil
+	function.Resources.Networks = nil
+
+	group.Networks = []*api.NetworkResource{
+		&api.NetworkResource{Mode: "bridge"},
+	}
+
+	connect := &api.ConsulConnect{SidecarService: &api.ConsulSidecarService{}}
+	if config.SidecarImage != "" {
+		connect.SidecarTask = &api.SidecarTask{
+			Config: map[string]interface{}{"image": config.SidecarImage},
 		}
 	}
-	return &task
+
+	group.Services = []*api.Service{
+		&api.Service{
+			Name:      function.Name,
+			PortLabel: nomad.ConnectFunctionPort,
+			Connect:   connect,
+		},
+	}
 }
 
 func createAnnotations(r requests.CreateFunctionRequest) map[string]string {
@@ -185,7 +466,7 @@ func createAnnotations(r requests.CreateFunctionRequest) map[string]string {
 func createSecretVolumes(secrets []string) []string {
 	newVolumes := []string{}
 	for _, s := range secrets {
-		destPath := "secrets/" + s + ":/var/openfaas/secrets/" + s
+		destPath := nomad.SecretDestPrefix + s + ":" + nomad.SecretMountPath + s
 		newVolumes = append(newVolumes, destPath)
 	}
 	return newVolumes
@@ -201,8 +482,8 @@ func createLabels(r requests.CreateFunctionRequest) []map[string]interface{} {
 	return labels
 }
 
-func createResources(r requests.CreateFunctionRequest) *api.Resources {
-	taskMemory, taskCPU := createLimits(r)
+func createResources(r requests.CreateFunctionRequest, defaults *types.FunctionDefaults) *api.Resources {
+	taskMemory, taskCPU := createLimits(r, defaults)
 
 	return &api.Resources{
 		Networks: []*api.NetworkResource{
@@ -215,9 +496,11 @@ func createResources(r requests.CreateFunctionRequest) *api.Resources {
 	}
 }
 
-func createLimits(r requests.CreateFunctionRequest) (taskMemory, taskCPU int) {
-	taskMemory = 128
-	taskCPU = 100
+func createLimits(r requests.CreateFunctionRequest, defaults *types.FunctionDefaults) (int, int) {
+	taskMemory, taskCPU := taskMemory, taskCPU
+	if defaults != nil {
+		taskMemory, taskCPU = defaults.Resources()
+	}
 
 	if r.Limits == nil {
 		return taskMemory, taskCPU
@@ -331,18 +614,18 @@ func createUpdateStrategy() *api.UpdateStrategy {
 	}
 }
 
-func createSecrets(vaultPrefix string, secrets []string) []*api.Template {
+func createSecrets(store types.SecretStore, secrets []string, changeMode, changeSignal string) []*api.Template {
 	templates := []*api.Template{}
 
 	for _, s := range secrets {
-		path := fmt.Sprintf("%s/%s", vaultPrefix, s)
-		destPath := "secrets/" + s
+		destPath := nomad.SecretDestPrefix + s
 
-		embeddedTemplate := fmt.Sprintf(`{{with secret "%s"}}{{.Data.value}}{{end}}`, path)
+		embeddedTemplate := store.SecretTemplate(s)
 		template := &api.Template{
 			DestPath:     &destPath,
 			EmbeddedTmpl: &embeddedTemplate,
 		}
+		setChangeMode(template, changeMode, changeSignal)
 
 		templates = append(templates, template)
 	}
@@ -350,6 +633,65 @@ func createSecrets(vaultPrefix string, secrets []string) []*api.Template {
 	return templates
 }
 
+// createSecretEnv creates a single template which exposes secrets as environment variables
+func createSecretEnv(store types.SecretStore, env map[string]string, changeMode, changeSignal string) *api.Template {
+	lines := []string{}
+	for _, k := range nomad.SortedKeys(env) {
+		// values are rendered as quoted JSON strings so that newlines, # and
+		// surrounding spaces in a secret are kept
+		lines = append(lines, fmt.Sprintf("%s=%s", k, store.SecretTemplateFormat(env[k], "{{%s | toJSON}}")))
+	}
+
+	destPath := secretEnvDestPath
+	embeddedTemplate := strings.Join(lines, "\n") + "\n"
+	envvars := true
+
+	template := &api.Template{
+		DestPath:     &destPath,
+		EmbeddedTmpl: &embeddedTemplate,
+		Envvars:      &envvars,
+	}
+	setChangeMode(template, changeMode, changeSignal)
+
+	return template
+}
+
+func setChangeMode(template *api.Template, changeMode, changeSignal string) {
+	template.ChangeMode = &changeMode
+	if changeMode == "signal" {
+		template.ChangeSignal = &changeSignal
+	}
+}
+
+func createSecretChangeMode(r requests.CreateFunctionRequest, providerConfig types.ProviderConfig) (string, string, error) {
+	annotations := createAnnotations(r)
+
+	changeMode := providerConfig.SecretChangeMode
+	if v, ok := annotations[nomad.SecretChangeModeAnnotation]; ok {
+		changeMode = v
+	}
+
+	changeSignal := providerConfig.SecretChangeSignal
+	if v, ok := annotations[nomad.SecretChangeSignalAnnotation]; ok {
+		changeSignal = v
+	}
+
+	if changeMode == "" {
+		changeMode = "restart"
+	}
+
+	if changeSignal == "" {
+		changeSignal = "SIGHUP"
+	}
+
+	switch changeMode {
+	case "restart", "signal", "noop":
+		return changeMode, changeSignal, nil
+	}
+
+	return "", "", fmt.Errorf("Invalid secret change mode %q, expected restart, signal or noop", changeMode)
+}
+
 func parseDNSServers(envVars map[string]string, providerConfig types.ProviderConfig) []string {
 
 	servers := []string{}
@@ -3,13 +3,16 @@ package handlers
 import (
 	"bytes"
 	"encoding/base64"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
+	"github.com/hashicorp/faas-nomad/consul"
 	"github.com/hashicorp/faas-nomad/metrics"
 	"github.com/hashicorp/faas-nomad/nomad"
 	fntypes "github.com/hashicorp/faas-nomad/types"
+	"github.com/hashicorp/faas-nomad/vault"
 	hclog "github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/nomad/api"
 	"github.com/openfaas/faas/gateway/requests"
@@ -27,11 +30,63 @@ func setupDeploy(body string) (http.HandlerFunc, *httptest.ResponseRecorder, *ht
 
 	logger := hclog.Default()
 
-	return MakeDeploy(mockJob, fntypes.ProviderConfig{Vault: fntypes.VaultConfig{DefaultPolicy: "openfaas", SecretPathPrefix: "secret/openfaas"}, Datacenter: "dc1", ConsulAddress: "http://localhost:8500", ConsulDNSEnabled: true, CPUArchConstraint: "amd64"}, logger, mockStats),
+	secrets := vault.NewVaultService(&fntypes.VaultConfig{DefaultPolicy: "openfaas", SecretPathPrefix: "secret/openfaas"}, logger)
+
+	return MakeDeploy(mockJob, fntypes.ProviderConfig{Secrets: secrets, Datacenter: "dc1", ConsulAddress: "http://localhost:8500", ConsulDNSEnabled: true, CPUArchConstraint: "amd64"}, logger, mockStats),
 		httptest.NewRecorder(),
 		httptest.NewRequest("GET", "/system/functions", bytes.NewReader([]byte(body)))
 }
 
+func setupDeployWithTemplates(body string, validation *api.JobValidateResponse) (http.HandlerFunc, *httptest.ResponseRecorder, *http.Request) {
+	mockJob = &nomad.MockJob{}
+	mockJob.On("Register", mock.Anything, mock.Anything).Return(nil, nil, nil)
+	mockJob.On("Validate", mock.Anything, mock.Anything).Return(validation, nil, nil)
+
+	mockStats := &metrics.MockStatsD{}
+	mockStats.On("Incr", mock.Anything, mock.Anything, mock.Anything)
+	mockStats.On("Gauge", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+
+	tmpl, _ := nomad.ParseJobTemplate("default", `group { ephemeral_disk { size_mb = 50 } }`)
+	templates := &nomad.JobTemplates{Default: tmpl}
+
+	return MakeDeploy(mockJob, fntypes.ProviderConfig{Datacenter: "dc1", CPUArchConstraint: "amd64", JobTemplates: templates}, hclog.Default(), mockStats),
+		httptest.NewRecorder(),
+		httptest.NewRequest("POST", "/system/functions", bytes.NewReader([]byte(body)))
+}
+
+func TestHandlerRegistersJobWithTemplateApplied(t *testing.T) {
+	h, rw, r := setupDeployWithTemplates(createRequest().String(), &api.JobValidateResponse{})
+
+	h(rw, r)
+
+	assert.Equal(t, http.StatusOK, rw.Code)
+	mockJob.AssertCalled(t, "Validate", mock.Anything, mock.Anything)
+
+	job := mockJob.Calls[1].Arguments.Get(0).(*api.Job)
+	assert.Equal(t, 50, *job.TaskGroups[0].EphemeralDisk.SizeMB)
+}
+
+func TestHandlerReturnsErrorWhenTemplatedJobIsInvalid(t *testing.T) {
+	h, rw, r := setupDeployWithTemplates(createRequest().String(), &api.JobValidateResponse{ValidationErrors: []string{"invalid ephemeral disk"}})
+
+	h(rw, r)
+
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+	assert.Contains(t, rw.Body.String(), "invalid ephemeral disk")
+	mockJob.AssertNotCalled(t, "Register", mock.Anything, mock.Anything)
+}
+
+func TestHandlerReturnsErrorForUnknownTemplate(t *testing.T) {
+	fr := createRequest()
+	fr.Annotations = &map[string]string{nomad.JobTemplateAnnotation: "unknown"}
+	h, rw, r := setupDeployWithTemplates(fr.String(), &api.JobValidateResponse{})
+
+	h(rw, r)
+
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+	mockJob.AssertNotCalled(t, "Register", mock.Anything, mock.Anything)
+}
+
 func TestHandlerReturnsErrorOnInvalidRequest(t *testing.T) {
 	h, rw, r := setupDeploy("")
 
@@ -158,6 +213,160 @@ func TestHandlesRequestWithMemoryLimit(t *testing.T) {
 	assert.Equal(t, 256, *mem)
 }
 
+func TestHandlesRequestWithoutLimitsUsesDefaults(t *testing.T) {
+	fr := createRequest()
+	defaults := fntypes.NewFunctionDefaults(512, 250)
+
+	task, err := createTask(fr.CreateFunctionRequest, fntypes.ProviderConfig{Defaults: defaults})
+	assert.Nil(t, err)
+	assert.Equal(t, 512, *task.Resources.MemoryMB)
+	assert.Equal(t, 250, *task.Resources.CPU)
+
+	defaults.Set(64, 50)
+
+	task, _ = createTask(fr.CreateFunctionRequest, fntypes.ProviderConfig{Defaults: defaults})
+	assert.Equal(t, 64, *task.Resources.MemoryMB)
+	assert.Equal(t, 50, *task.Resources.CPU)
+}
+
+func TestHandlesRequestWithScheduleCreatesPeriodicJob(t *testing.T) {
+	fr := createRequest()
+	fr.EnvProcess = "cat /etc/hostname"
+	fr.Annotations = &map[string]string{nomad.ScheduleAnnotation: "*/5 * * * *"}
+
+	job, err := createJob(fr.CreateFunctionRequest, fntypes.ProviderConfig{Datacenter: "dc1", CPUArchConstraint: "amd64"})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "batch", *job.Type)
+	assert.Equal(t, "*/5 * * * *", *job.Periodic.Spec)
+	assert.True(t, *job.Periodic.ProhibitOverlap)
+	assert.Nil(t, job.Update)
+
+	task := job.TaskGroups[0].Tasks[0]
+	assert.Equal(t, "cat", task.Config["command"])
+	assert.Equal(t, []string{"/etc/hostname"}, task.Config["args"])
+	assert.Nil(t, task.Config["port_map"])
+	assert.Nil(t, task.Services)
+	assert.Nil(t, task.Resources.Networks)
+	assert.Equal(t, "fail", *job.TaskGroups[0].RestartPolicy.Mode)
+}
+
+func TestHandlesRequestWithDispatchCreatesParameterizedJob(t *testing.T) {
+	fr := createRequest()
+	fr.EnvProcess = "ffmpeg -i pipe:0 -f mp4 pipe:1"
+	fr.Annotations = &map[string]string{nomad.DispatchAnnotation: "true"}
+
+	job, err := createJob(fr.CreateFunctionRequest, fntypes.ProviderConfig{Datacenter: "dc1", CPUArchConstraint: "amd64"})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "batch", *job.Type)
+	assert.True(t, job.IsParameterized())
+	assert.Equal(t, "optional", job.ParameterizedJob.Payload)
+	assert.Nil(t, job.Periodic)
+
+	task := job.TaskGroups[0].Tasks[0]
+	assert.Equal(t, "request", task.DispatchPayload.File)
+	assert.Equal(t, "sh", task.Config["command"])
+	assert.Equal(t, []string{"-c", `cat "$NOMAD_TASK_DIR/request" 2>/dev/null | (ffmpeg -i pipe:0 -f mp4 pipe:1) > "$NOMAD_ALLOC_DIR/output"`}, task.Config["args"])
+	assert.Nil(t, task.Services)
+}
+
+func TestHandlesRequestWithScheduleAndDispatchReturnsError(t *testing.T) {
+	fr := createRequest()
+	fr.EnvProcess = "env"
+	fr.Annotations = &map[string]string{nomad.ScheduleAnnotation: "*/5 * * * *", nomad.DispatchAnnotation: "true"}
+
+	_, err := createJob(fr.CreateFunctionRequest, fntypes.ProviderConfig{Datacenter: "dc1", CPUArchConstraint: "amd64"})
+
+	assert.NotNil(t, err)
+}
+
+func TestHandlesRequestWithVolumesMountsAllowedVolumes(t *testing.T) {
+	fr := createRequest()
+	fr.Annotations = &map[string]string{nomad.VolumesAnnotation: "models:/models:ro"}
+
+	volumes, _ := nomad.ParseVolumes(`volume "models" { type = "host" source = "models" }`)
+
+	groups, err := createTaskGroup(fr.CreateFunctionRequest, fntypes.ProviderConfig{Volumes: volumes})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "models", groups[0].Volumes["models"].Source)
+	assert.Equal(t, "/models", *groups[0].Tasks[0].VolumeMounts[0].Destination)
+	assert.True(t, *groups[0].Tasks[0].VolumeMounts[0].ReadOnly)
+}
+
+func TestHandlesRequestWithVolumesWithoutAllowlistReturnsError(t *testing.T) {
+	fr := createRequest()
+	fr.Annotations = &map[string]string{nomad.VolumesAnnotation: "models:/models"}
+
+	h, rw, r := setupDeploy(fr.String())
+
+	h(rw, r)
+
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+	mockJob.AssertNotCalled(t, "Register", mock.Anything, mock.Anything)
+}
+
+func TestHandlesRequestWithConnectAddsSidecarService(t *testing.T) {
+	fr := createRequest()
+	fr.Annotations = &map[string]string{nomad.ConnectAnnotation: "true"}
+
+	job, err := createJob(fr.CreateFunctionRequest, fntypes.ProviderConfig{Datacenter: "dc1", CPUArchConstraint: "amd64", Connect: &fntypes.ConnectConfig{}})
+
+	assert.Nil(t, err)
+
+	group := job.TaskGroups[0]
+	assert.Len(t, group.Tasks, 1)
+	assert.Equal(t, "bridge", group.Networks[0].Mode)
+	assert.Empty(t, group.Networks[0].DynamicPorts)
+	assert.Empty(t, group.Networks[0].ReservedPorts)
+
+	task := group.Tasks[0]
+	assert.Nil(t, task.Services)
+	assert.Nil(t, task.Resources.Networks)
+	assert.NotContains(t, task.Config, "port_map")
+
+	service := group.Services[0]
+	assert.Equal(t, "TestFunction", service.Name)
+	assert.Equal(t, "8080", service.PortLabel)
+	assert.NotNil(t, service.Connect.SidecarService)
+	assert.Nil(t, service.Connect.SidecarTask)
+}
+
+func TestHandlesRequestWithConnectSetsSidecarImage(t *testing.T) {
+	fr := createRequest()
+	fr.Annotations = &map[string]string{nomad.ConnectAnnotation: "true"}
+
+	job, err := createJob(fr.CreateFunctionRequest, fntypes.ProviderConfig{Datacenter: "dc1", CPUArchConstraint: "amd64", Connect: &fntypes.ConnectConfig{SidecarImage: "envoyproxy/envoy:v1.16.0"}})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "envoyproxy/envoy:v1.16.0", job.TaskGroups[0].Services[0].Connect.SidecarTask.Config["image"])
+}
+
+func TestHandlesRequestWithConnectWhenDisabledReturnsError(t *testing.T) {
+	fr := createRequest()
+	fr.Annotations = &map[string]string{nomad.ConnectAnnotation: "true"}
+
+	h, rw, r := setupDeploy(fr.String())
+
+	h(rw, r)
+
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+	mockJob.AssertNotCalled(t, "Register", mock.Anything, mock.Anything)
+}
+
+func TestHandlesRequestWithInvalidScheduleReturnsError(t *testing.T) {
+	fr := createRequest()
+	fr.Annotations = &map[string]string{nomad.ScheduleAnnotation: "sometimes"}
+
+	h, rw, r := setupDeploy(fr.String())
+
+	h(rw, r)
+
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+	mockJob.AssertNotCalled(t, "Register", mock.Anything, mock.Anything)
+}
+
 func TestHandlesRequestWithSecrets(t *testing.T) {
 	fr := createRequest()
 	fr.Secrets = []string{"figlet"}
@@ -175,6 +384,149 @@ func TestHandlesRequestWithSecrets(t *testing.T) {
 	assert.Equal(t, expectedTemplate, *templates[0].EmbeddedTmpl)
 }
 
+func TestHandlesRequestWithSecretsForKVVersion2(t *testing.T) {
+	fr := createRequest()
+	fr.Secrets = []string{"figlet"}
+	expectedTemplate := `{{with secret "secret/data/openfaas/figlet"}}{{.Data.data.value}}{{end}}`
+
+	secrets := vault.NewVaultService(&fntypes.VaultConfig{SecretPathPrefix: "secret/openfaas", KVVersion: 2}, hclog.Default())
+
+	templates := createSecrets(secrets, fr.Secrets, "restart", "")
+
+	assert.Equal(t, "secrets/figlet", *templates[0].DestPath)
+	assert.Equal(t, expectedTemplate, *templates[0].EmbeddedTmpl)
+}
+
+func TestHandlesRequestWithConsulSecretStore(t *testing.T) {
+	fr := createRequest()
+	fr.Secrets = []string{"figlet"}
+
+	task, err := createTask(fr.CreateFunctionRequest, fntypes.ProviderConfig{Secrets: consul.NewKVSecretStoreWithClient(nil, "openfaas/secrets")})
+
+	assert.Nil(t, err)
+	assert.Equal(t, `{{key "openfaas/secrets/figlet"}}`, *task.Templates[0].EmbeddedTmpl)
+	assert.Nil(t, task.Vault)
+}
+
+func TestReturnsErrorWithSecretsAndNoSecretStore(t *testing.T) {
+	fr := createRequest()
+	fr.Secrets = []string{"figlet"}
+
+	_, err := createTask(fr.CreateFunctionRequest, fntypes.ProviderConfig{})
+
+	assert.NotNil(t, err)
+}
+
+func TestHandlesRequestWithSecretEnvAnnotation(t *testing.T) {
+	fr := createRequest()
+	fr.Annotations = &map[string]string{nomad.SecretEnvAnnotation: "DB_PASSWORD=db_password, API_KEY=api_key"}
+	expectedTemplate := `API_KEY={{with secret "secret/openfaas/api_key"}}{{.Data.value | toJSON}}{{end}}
+DB_PASSWORD={{with secret "secret/openfaas/db_password"}}{{.Data.value | toJSON}}{{end}}
+`
+
+	h, rw, r := setupDeploy(fr.String())
+
+	h(rw, r)
+
+	args := mockJob.Calls[0].Arguments
+	job := args.Get(0).(*api.Job)
+	task := job.TaskGroups[0].Tasks[0]
+
+	assert.Equal(t, 1, len(task.Templates))
+	assert.Equal(t, expectedTemplate, *task.Templates[0].EmbeddedTmpl)
+	assert.True(t, *task.Templates[0].Envvars)
+	assert.Equal(t, "restart", *task.Templates[0].ChangeMode)
+	assert.Equal(t, []string{"openfaas"}, task.Vault.Policies)
+	assert.Nil(t, task.Config["volumes"])
+}
+
+func TestHandlesRequestWithSecretChangeModeSignal(t *testing.T) {
+	fr := createRequest()
+	fr.Secrets = []string{"figlet"}
+	fr.Annotations = &map[string]string{
+		nomad.SecretChangeModeAnnotation:   "signal",
+		nomad.SecretChangeSignalAnnotation: "SIGUSR1",
+	}
+
+	h, rw, r := setupDeploy(fr.String())
+
+	h(rw, r)
+
+	args := mockJob.Calls[0].Arguments
+	job := args.Get(0).(*api.Job)
+	templates := job.TaskGroups[0].Tasks[0].Templates
+
+	assert.Equal(t, "signal", *templates[0].ChangeMode)
+	assert.Equal(t, "SIGUSR1", *templates[0].ChangeSignal)
+}
+
+func TestReturnsBadRequestWithInvalidSecretChangeMode(t *testing.T) {
+	fr := createRequest()
+	fr.Secrets = []string{"figlet"}
+	fr.Annotations = &map[string]string{nomad.SecretChangeModeAnnotation: "explode"}
+
+	h, rw, r := setupDeploy(fr.String())
+
+	h(rw, r)
+
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+	mockJob.AssertNotCalled(t, "Register", mock.Anything, mock.Anything)
+}
+
+func TestReturnsBadRequestWithInvalidSecretEnvAnnotation(t *testing.T) {
+	fr := createRequest()
+	fr.Annotations = &map[string]string{nomad.SecretEnvAnnotation: "DB_PASSWORD"}
+
+	h, rw, r := setupDeploy(fr.String())
+
+	h(rw, r)
+
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+	mockJob.AssertNotCalled(t, "Register", mock.Anything, mock.Anything)
+}
+
+func TestReturnsBadRequestWithSecretPathTraversal(t *testing.T) {
+	fr := createRequest()
+	fr.Secrets = []string{"../../sys/policy/x"}
+
+	h, rw, r := setupDeploy(fr.String())
+
+	h(rw, r)
+
+	resp := ErrorResponse{}
+	json.NewDecoder(rw.Body).Decode(&resp)
+
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+	assert.Equal(t, "../../sys/policy/x", resp.Detail.Value)
+	mockJob.AssertNotCalled(t, "Register", mock.Anything, mock.Anything)
+}
+
+func TestReturnsBadRequestWithInvalidSecretEnvName(t *testing.T) {
+	for _, name := range []string{"1DB", "DB PASSWORD", "DB\nPASSWORD", "DB-PASSWORD"} {
+		fr := createRequest()
+		fr.Annotations = &map[string]string{nomad.SecretEnvAnnotation: name + "=db_password"}
+
+		h, rw, r := setupDeploy(fr.String())
+
+		h(rw, r)
+
+		assert.Equal(t, http.StatusBadRequest, rw.Code, name)
+		mockJob.AssertNotCalled(t, "Register", mock.Anything, mock.Anything)
+	}
+}
+
+func TestReturnsBadRequestWithSecretEnvPathTraversal(t *testing.T) {
+	fr := createRequest()
+	fr.Annotations = &map[string]string{nomad.SecretEnvAnnotation: "TOKEN=../../auth/token/create"}
+
+	h, rw, r := setupDeploy(fr.String())
+
+	h(rw, r)
+
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+	mockJob.AssertNotCalled(t, "Register", mock.Anything, mock.Anything)
+}
+
 func TestHandlesRequestWithDNSServers(t *testing.T) {
 	fr := createRequest()
 	expectedServers := []string{"127.0.0.1", "127.0.1.1"}
@@ -258,6 +610,21 @@ func TestHandleDeployWithRegistryAuth(t *testing.T) {
 	assert.Equal(t, "password", auth[0]["password"])
 }
 
+func TestHandleDeployWithMalformedRegistryAuthReturnsBadRequest(t *testing.T) {
+	for _, auth := range []string{"not base64!", base64.StdEncoding.EncodeToString([]byte("username"))} {
+		fr := createRequest()
+		fr.RegistryAuth = auth
+
+		h, rw, r := setupDeploy(fr.String())
+
+		h(rw, r)
+
+		assert.Equal(t, http.StatusBadRequest, rw.Code)
+		assert.NotContains(t, rw.Body.String(), auth)
+		mockJob.AssertNotCalled(t, "Register", mock.Anything, mock.Anything)
+	}
+}
+
 func TestHandlesRequestUsingDefaultCPUArchConstraint(t *testing.T) {
 	fr := createRequest()
 	expectedCpuArchConstraint := api.Constraint{
@@ -0,0 +1,265 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/faas-nomad/metrics"
+	"github.com/hashicorp/faas-nomad/nomad"
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
+	cache "github.com/patrickmn/go-cache"
+)
+
+// dispatchModeTTL is how long the proxy remembers whether a function is
+// dispatched, a function redeployed in a different mode is picked up after it
+var dispatchModeTTL = 30 * time.Second
+
+// DispatchPath is the path of the status endpoint for dispatched invocations
+const DispatchPath = "/system/dispatch"
+
+// DispatchResponse is returned when an invocation of a function is dispatched
+type DispatchResponse struct {
+	Function string `json:"function"`
+	// DispatchID identifies the invocation in the status and result endpoints
+	DispatchID string `json:"dispatchID"`
+	// JobID is the ID of the Nomad job which runs the invocation
+	JobID  string `json:"jobID"`
+	EvalID string `json:"evalID"`
+}
+
+// DispatchStatus reports the progress of a dispatched invocation
+type DispatchStatus struct {
+	Function   string `json:"function"`
+	DispatchID string `json:"dispatchID"`
+	// Status is the status of the Nomad job, pending, running or dead
+	Status string `json:"status"`
+	// AllocationID is the latest allocation of the job, it is empty until the
+	// invocation has been placed
+	AllocationID string `json:"allocationID,omitempty"`
+	// ClientStatus is the status of the latest allocation, the result can be
+	// read once it is complete or failed
+	ClientStatus string `json:"clientStatus,omitempty"`
+}
+
+// MakeDispatchProxy creates a handler which dispatches invocations of
+// parameterized functions with the request body as the payload, invocations
+// of other functions are passed to the next handler. Like the proxy, only
+// POST requests are dispatched and draining functions are rejected.
+func MakeDispatchProxy(client nomad.Job, tracker *RequestTracker, next http.HandlerFunc, logger hclog.Logger, stats metrics.StatsD) http.HandlerFunc {
+	log := logger.Named("dispatch_proxy")
+	modes := cache.New(dispatchModeTTL, 2*dispatchModeTTL)
+
+	return func(rw http.ResponseWriter, r *http.Request) {
+		functionName := r.Context().Value(FunctionNameCTXKey).(string)
+
+		if !isDispatchFunction(client, modes, functionName) {
+			next(rw, r)
+			return
+		}
+
+		stats.Incr("dispatch.called", []string{"job:" + functionName}, 1)
+
+		if r.Method != http.MethodPost {
+			writeJSONError(rw, http.StatusBadRequest, fmt.Errorf("Dispatched functions must be invoked with POST"))
+
+			log.Error("Bad request", "function", functionName, "method", r.Method)
+			stats.Incr("dispatch.error.badrequest", []string{"job:" + functionName}, 1)
+			return
+		}
+
+		if !tracker.Start(functionName) {
+			writeJSONError(rw, http.StatusGone, fmt.Errorf("Function is being deleted"))
+
+			log.Info("Rejected dispatch for draining function", "function", functionName)
+			return
+		}
+		defer tracker.Done(functionName)
+
+		var payload []byte
+		if r.Body != nil {
+			defer r.Body.Close()
+			payload, _ = ioutil.ReadAll(io.LimitReader(r.Body, nomad.DispatchPayloadLimit+1))
+		}
+
+		if len(payload) > nomad.DispatchPayloadLimit {
+			writeJSONError(rw, http.StatusRequestEntityTooLarge, fmt.Errorf("Request body is larger than the %d byte dispatch limit", nomad.DispatchPayloadLimit))
+
+			log.Error("Request body too large", "function", functionName, "limit", nomad.DispatchPayloadLimit)
+			stats.Incr("dispatch.error.toolarge", []string{"job:" + functionName}, 1)
+			return
+		}
+
+		jobID := nomad.JobPrefix + functionName
+		resp, _, err := client.Dispatch(jobID, nil, payload, nil)
+		if err != nil {
+			writeJSONError(rw, http.StatusInternalServerError, err)
+
+			log.Error("Error dispatching function", "function", functionName, "error", err)
+			stats.Incr("dispatch.error.dispatch", []string{"job:" + functionName}, 1)
+			return
+		}
+
+		dr := DispatchResponse{
+			Function:   functionName,
+			DispatchID: nomad.DispatchID(jobID, resp.DispatchedJobID),
+			JobID:      resp.DispatchedJobID,
+			EvalID:     resp.EvalID,
+		}
+
+		log.Info("Dispatched function", "function", functionName, "job", dr.JobID)
+		stats.Incr("dispatch.success", []string{"job:" + functionName}, 1)
+
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Header().Set("Location", fmt.Sprintf("%s/%s/%s", DispatchPath, functionName, dr.DispatchID))
+		rw.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(rw).Encode(dr)
+	}
+}
+
+// isDispatchFunction returns true when the function job is parameterized,
+// lookup errors are not cached so that the next handler reports them
+func isDispatchFunction(client nomad.Job, modes *cache.Cache, functionName string) bool {
+	if v, ok := modes.Get(functionName); ok {
+		return v.(bool)
+	}
+
+	job, _, err := client.Info(nomad.JobPrefix+functionName, nil)
+	if err != nil || job == nil {
+		return false
+	}
+
+	dispatch := job.IsParameterized()
+	modes.Set(functionName, dispatch, cache.DefaultExpiration)
+
+	return dispatch
+}
+
+// MakeDispatchStatus creates a handler which reports the status of a
+// dispatched invocation, the function name and dispatch ID are read from the
+// name and id vars
+func MakeDispatchStatus(client nomad.Job, getVars func(*http.Request) map[string]string, logger hclog.Logger, stats metrics.StatsD) http.HandlerFunc {
+	log := logger.Named("dispatch_status_handler")
+
+	return func(rw http.ResponseWriter, r *http.Request) {
+		stats.Incr("dispatch.status.called", nil, 1)
+
+		status, _, err := getDispatchStatus(client, getVars(r))
+		if err != nil {
+			writeJSONError(rw, http.StatusNotFound, err)
+
+			log.Error("Error getting dispatch", "error", err)
+			stats.Incr("dispatch.status.error.notfound", nil, 1)
+			return
+		}
+
+		rw.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(rw).Encode(status)
+
+		stats.Incr("dispatch.status.success", nil, 1)
+	}
+}
+
+// MakeDispatchResult creates a handler which returns the output of a
+// completed dispatched invocation from the allocation filesystem, the
+// response is 500 when the invocation failed
+func MakeDispatchResult(client nomad.Job, allocs nomad.Allocations, fs nomad.AllocFS, getVars func(*http.Request) map[string]string, logger hclog.Logger, stats metrics.StatsD) http.HandlerFunc {
+	log := logger.Named("dispatch_result_handler")
+
+	return func(rw http.ResponseWriter, r *http.Request) {
+		stats.Incr("dispatch.result.called", nil, 1)
+
+		status, allocID, err := getDispatchStatus(client, getVars(r))
+		if err != nil {
+			writeJSONError(rw, http.StatusNotFound, err)
+
+			log.Error("Error getting dispatch", "error", err)
+			stats.Incr("dispatch.result.error.notfound", nil, 1)
+			return
+		}
+
+		code := http.StatusOK
+		switch status.ClientStatus {
+		case "complete":
+		case "failed":
+			code = http.StatusInternalServerError
+		default:
+			writeJSONError(rw, http.StatusConflict, fmt.Errorf("Dispatch %s has not completed", status.DispatchID))
+			stats.Incr("dispatch.result.error.notcomplete", nil, 1)
+			return
+		}
+
+		alloc, _, err := allocs.Info(allocID, nil)
+		if err == nil {
+			var output io.ReadCloser
+			output, err = fs.Cat(alloc, nomad.DispatchOutputPath, nil)
+			if err == nil {
+				defer output.Close()
+
+				rw.Header().Set("Content-Type", "application/octet-stream")
+				rw.Header().Set("X-Dispatch-Status", status.ClientStatus)
+				rw.WriteHeader(code)
+				io.Copy(rw, output)
+
+				stats.Incr("dispatch.result.success", nil, 1)
+				return
+			}
+		}
+
+		writeJSONError(rw, http.StatusBadGateway, err)
+
+		log.Error("Error reading dispatch output", "dispatch", status.DispatchID, "error", err)
+		stats.Incr("dispatch.result.error.read", nil, 1)
+	}
+}
+
+// getDispatchStatus returns the status of a dispatched job and the ID of its
+// latest allocation
+func getDispatchStatus(client nomad.Job, vars map[string]string) (*DispatchStatus, string, error) {
+	parentID := nomad.JobPrefix + vars["name"]
+	jobID := parentID + "/" + vars["id"]
+
+	job, _, err := client.Info(jobID, nil)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// only children of the function can be read through its endpoint
+	if job.ParentID == nil || *job.ParentID != parentID {
+		return nil, "", fmt.Errorf("Dispatch %s not found for function %s", vars["id"], vars["name"])
+	}
+
+	status := &DispatchStatus{
+		Function:   vars["name"],
+		DispatchID: vars["id"],
+	}
+
+	if job.Status != nil {
+		status.Status = *job.Status
+	}
+
+	allocs, _, err := client.Allocations(jobID, true, nil)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var latest *api.AllocationListStub
+	for _, a := range allocs {
+		if latest == nil || a.CreateIndex > latest.CreateIndex {
+			latest = a
+		}
+	}
+
+	if latest == nil {
+		return status, "", nil
+	}
+
+	status.AllocationID = latest.ID
+	status.ClientStatus = latest.ClientStatus
+
+	return status, latest.ID, nil
+}
@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/faas-nomad/metrics"
+	"github.com/hashicorp/faas-nomad/nomad"
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+const dispatchedJobID = "OpenFaaS-report/dispatch-1528453553-0aa3b2f8"
+
+var dispatchVars = func(r *http.Request) map[string]string {
+	return map[string]string{"name": "report", "id": "dispatch-1528453553-0aa3b2f8"}
+}
+
+var dispatchTracker *RequestTracker
+
+func setupDispatchProxy(job *api.Job, body []byte) (http.HandlerFunc, *httptest.ResponseRecorder, *http.Request, *bool) {
+	mockJob = &nomad.MockJob{}
+	mockJob.On("Info", "OpenFaaS-report", mock.Anything).Return(job, nil, nil)
+	mockJob.On("Dispatch", "OpenFaaS-report", mock.Anything, mock.Anything, mock.Anything).
+		Return(&api.JobDispatchResponse{DispatchedJobID: dispatchedJobID, EvalID: "eval-1"}, nil, nil)
+
+	mockStats := &metrics.MockStatsD{}
+	mockStats.On("Incr", mock.Anything, mock.Anything, mock.Anything)
+
+	proxied := false
+	next := func(rw http.ResponseWriter, r *http.Request) {
+		proxied = true
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/function/report", bytes.NewReader(body))
+	r = r.WithContext(context.WithValue(r.Context(), FunctionNameCTXKey, "report"))
+
+	dispatchTracker = NewRequestTracker()
+
+	return MakeDispatchProxy(mockJob, dispatchTracker, next, hclog.Default(), mockStats), httptest.NewRecorder(), r, &proxied
+}
+
+func parameterizedJob() *api.Job {
+	job := api.NewBatchJob("OpenFaaS-report", "OpenFaaS-report", "global", 1)
+	job.ParameterizedJob = &api.ParameterizedJobConfig{Payload: "optional"}
+
+	return job
+}
+
+func TestDispatchProxyRejectsGetRequests(t *testing.T) {
+	h, rw, r, _ := setupDispatchProxy(parameterizedJob(), nil)
+	r.Method = http.MethodGet
+
+	h(rw, r)
+
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+	mockJob.AssertNotCalled(t, "Dispatch", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestDispatchProxyRejectsDrainingFunction(t *testing.T) {
+	h, rw, r, _ := setupDispatchProxy(parameterizedJob(), []byte("2018-06"))
+	dispatchTracker.Drain("report")
+
+	h(rw, r)
+
+	assert.Equal(t, http.StatusGone, rw.Code)
+	mockJob.AssertNotCalled(t, "Dispatch", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestDispatchProxyTracksInFlightDispatches(t *testing.T) {
+	h, rw, r, _ := setupDispatchProxy(parameterizedJob(), []byte("2018-06"))
+
+	h(rw, r)
+
+	assert.Equal(t, http.StatusAccepted, rw.Code)
+	assert.Equal(t, 0, dispatchTracker.InFlight("report"))
+}
+
+func TestDispatchProxyDispatchesParameterizedFunction(t *testing.T) {
+	h, rw, r, proxied := setupDispatchProxy(parameterizedJob(), []byte("2018-06"))
+
+	h(rw, r)
+
+	assert.Equal(t, http.StatusAccepted, rw.Code)
+	assert.False(t, *proxied)
+	assert.Equal(t, "/system/dispatch/report/dispatch-1528453553-0aa3b2f8", rw.Header().Get("Location"))
+	mockJob.AssertCalled(t, "Dispatch", "OpenFaaS-report", mock.Anything, []byte("2018-06"), mock.Anything)
+
+	resp := DispatchResponse{}
+	json.Unmarshal(rw.Body.Bytes(), &resp)
+	assert.Equal(t, "dispatch-1528453553-0aa3b2f8", resp.DispatchID)
+	assert.Equal(t, dispatchedJobID, resp.JobID)
+	assert.Equal(t, "eval-1", resp.EvalID)
+}
+
+func TestDispatchProxyPassesServiceFunctionsToNextHandler(t *testing.T) {
+	job := api.NewServiceJob("OpenFaaS-report", "OpenFaaS-report", "global", 1)
+	h, rw, r, proxied := setupDispatchProxy(job, nil)
+
+	h(rw, r)
+
+	assert.True(t, *proxied)
+	mockJob.AssertNotCalled(t, "Dispatch", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestDispatchProxyRejectsPayloadOverLimit(t *testing.T) {
+	h, rw, r, _ := setupDispatchProxy(parameterizedJob(), bytes.Repeat([]byte("a"), nomad.DispatchPayloadLimit+1))
+
+	h(rw, r)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rw.Code)
+	mockJob.AssertNotCalled(t, "Dispatch", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func setupDispatchResult(clientStatus string) (*nomad.MockAllocations, *nomad.MockAllocFS, *metrics.MockStatsD) {
+	parentID := "OpenFaaS-report"
+	status := "running"
+	if clientStatus == "complete" || clientStatus == "failed" {
+		status = "dead"
+	}
+
+	mockJob = &nomad.MockJob{}
+	mockJob.On("Info", dispatchedJobID, mock.Anything).Return(&api.Job{ParentID: &parentID, Status: &status}, nil, nil)
+	mockJob.On("Allocations", dispatchedJobID, true, mock.Anything).Return([]*api.AllocationListStub{
+		&api.AllocationListStub{ID: "alloc-1", CreateIndex: 10, ClientStatus: "failed"},
+		&api.AllocationListStub{ID: "alloc-2", CreateIndex: 20, ClientStatus: clientStatus},
+	}, nil, nil)
+
+	alloc := &api.Allocation{ID: "alloc-2"}
+	allocs := &nomad.MockAllocations{}
+	allocs.On("Info", "alloc-2", mock.Anything).Return(alloc, nil, nil)
+
+	fs := &nomad.MockAllocFS{}
+	fs.On("Cat", alloc, "alloc/output", mock.Anything).Return(ioutil.NopCloser(strings.NewReader("report.pdf")), nil)
+
+	mockStats := &metrics.MockStatsD{}
+	mockStats.On("Incr", mock.Anything, mock.Anything, mock.Anything)
+
+	return allocs, fs, mockStats
+}
+
+func TestDispatchStatusReturnsLatestAllocation(t *testing.T) {
+	_, _, stats := setupDispatchResult("running")
+	rw := httptest.NewRecorder()
+
+	MakeDispatchStatus(mockJob, dispatchVars, hclog.Default(), stats)(rw, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	status := DispatchStatus{}
+	json.Unmarshal(rw.Body.Bytes(), &status)
+
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, "running", status.Status)
+	assert.Equal(t, "alloc-2", status.AllocationID)
+	assert.Equal(t, "running", status.ClientStatus)
+}
+
+func TestDispatchStatusReturnsNotFoundForOtherFunctions(t *testing.T) {
+	_, _, stats := setupDispatchResult("running")
+	rw := httptest.NewRecorder()
+
+	vars := func(r *http.Request) map[string]string {
+		return map[string]string{"name": "other", "id": "dispatch-1528453553-0aa3b2f8"}
+	}
+	mockJob.On("Info", "OpenFaaS-other/dispatch-1528453553-0aa3b2f8", mock.Anything).Return(nil, nil, fmt.Errorf("job not found"))
+
+	MakeDispatchStatus(mockJob, vars, hclog.Default(), stats)(rw, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, http.StatusNotFound, rw.Code)
+}
+
+func TestDispatchResultReturnsOutputWhenComplete(t *testing.T) {
+	allocs, fs, stats := setupDispatchResult("complete")
+	rw := httptest.NewRecorder()
+
+	MakeDispatchResult(mockJob, allocs, fs, dispatchVars, hclog.Default(), stats)(rw, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, "report.pdf", rw.Body.String())
+	assert.Equal(t, "complete", rw.Header().Get("X-Dispatch-Status"))
+}
+
+func TestDispatchResultReturnsErrorWithOutputWhenFailed(t *testing.T) {
+	allocs, fs, stats := setupDispatchResult("failed")
+	rw := httptest.NewRecorder()
+
+	MakeDispatchResult(mockJob, allocs, fs, dispatchVars, hclog.Default(), stats)(rw, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, http.StatusInternalServerError, rw.Code)
+	assert.Equal(t, "report.pdf", rw.Body.String())
+}
+
+func TestDispatchResultReturnsConflictWhileRunning(t *testing.T) {
+	allocs, fs, stats := setupDispatchResult("running")
+	rw := httptest.NewRecorder()
+
+	MakeDispatchResult(mockJob, allocs, fs, dispatchVars, hclog.Default(), stats)(rw, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, http.StatusConflict, rw.Code)
+	fs.AssertNotCalled(t, "Cat", mock.Anything, mock.Anything, mock.Anything)
+}
@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/faas-nomad/nomad"
+	"github.com/hashicorp/nomad/api"
+)
+
+// ContinueHeader returns the token which reads the next page of functions
+const ContinueHeader = "X-Continue-Token"
+
+// selector operators
+const (
+	selectorEquals    = "="
+	selectorNotEquals = "!="
+	selectorIn        = "in"
+	selectorNotIn     = "notin"
+	selectorExists    = "exists"
+	selectorNotExists = "!exists"
+)
+
+var setSelector = regexp.MustCompile(`^([^\s=!(),]+)\s+(in|notin)\s+\(([^()]*)\)$`)
+
+// selector matches a key of the function labels or annotations
+type selector struct {
+	key    string
+	op     string
+	values []string
+}
+
+// functionFilter selects the functions returned by the reader, the status
+// and prefix are matched against the job list so that jobs which do not
+// match are not read
+type functionFilter struct {
+	prefix      string
+	statuses    map[string]bool
+	labels      []selector
+	annotations []selector
+	image       string
+	limit       int
+	after       string
+}
+
+// parseFunctionFilter reads the filter from the query parameters of a list
+// request, e.g. ?labels=team=billing,tier in (web,api)&limit=50
+func parseFunctionFilter(q url.Values) (*functionFilter, error) {
+	f := &functionFilter{
+		prefix:   q.Get("prefix"),
+		statuses: map[string]bool{"running": true, "pending": true},
+		image:    q.Get("image"),
+	}
+
+	if s := q.Get("status"); s != "" {
+		f.statuses = map[string]bool{}
+		for _, status := range strings.Split(s, ",") {
+			f.statuses[strings.TrimSpace(status)] = true
+		}
+	}
+
+	var err error
+	if f.labels, err = parseSelectors(q.Get("labels")); err != nil {
+		return nil, fmt.Errorf("Invalid labels selector: %s", err)
+	}
+
+	if f.annotations, err = parseSelectors(q.Get("annotations")); err != nil {
+		return nil, fmt.Errorf("Invalid annotations selector: %s", err)
+	}
+
+	if l := q.Get("limit"); l != "" {
+		f.limit, err = strconv.Atoi(l)
+		if err != nil || f.limit <= 0 {
+			return nil, fmt.Errorf("Invalid limit %q, expected a number greater than 0", l)
+		}
+	}
+
+	if c := q.Get("continue"); c != "" {
+		after, err := base64.RawURLEncoding.DecodeString(c)
+		if err != nil || !strings.HasPrefix(string(after), nomad.JobPrefix) {
+			return nil, fmt.Errorf("Invalid continue token")
+		}
+
+		f.after = string(after)
+	}
+
+	return f, nil
+}
+
+// queryOptions returns the Nomad list query for the filter
+func (f *functionFilter) queryOptions() *api.QueryOptions {
+	return &api.QueryOptions{Prefix: nomad.JobPrefix + f.prefix}
+}
+
+// matchJob returns true when the job may be a function matching the filter
+func (f *functionFilter) matchJob(j *api.JobListStub) bool {
+	if nomad.IsChildJob(j) || !f.statuses[j.Status] {
+		return false
+	}
+
+	// jobs are listed in ID order so the previous pages end at after
+	return f.after == "" || j.ID > f.after
+}
+
+// matchFunction returns true when the function matches the label, annotation
+// and image filters
+func (f *functionFilter) matchFunction(fn FunctionStatus) bool {
+	if !strings.HasPrefix(fn.Image, f.image) {
+		return false
+	}
+
+	var labels, annotations map[string]string
+	if fn.Labels != nil {
+		labels = *fn.Labels
+	}
+
+	if fn.Annotations != nil {
+		annotations = *fn.Annotations
+	}
+
+	return matchSelectors(f.labels, labels) && matchSelectors(f.annotations, annotations)
+}
+
+// continueToken returns the token which reads the page after the job
+func continueToken(jobID string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(jobID))
+}
+
+// parseSelectors parses a comma separated list of key=value, key!=value,
+// key in (a,b), key notin (a,b), key and !key selectors
+func parseSelectors(s string) ([]selector, error) {
+	selectors := []selector{}
+	if strings.TrimSpace(s) == "" {
+		return selectors, nil
+	}
+
+	for _, term := range splitSelectors(s) {
+		term = strings.TrimSpace(term)
+
+		var sel selector
+		switch {
+		case setSelector.MatchString(term):
+			m := setSelector.FindStringSubmatch(term)
+			sel = selector{key: m[1], op: m[2]}
+			for _, v := range strings.Split(m[3], ",") {
+				sel.values = append(sel.values, strings.TrimSpace(v))
+			}
+		case strings.Contains(term, "!="):
+			kv := strings.SplitN(term, "!=", 2)
+			sel = selector{key: strings.TrimSpace(kv[0]), op: selectorNotEquals, values: []string{strings.TrimSpace(kv[1])}}
+		case strings.Contains(term, "="):
+			kv := strings.SplitN(strings.Replace(term, "==", "=", 1), "=", 2)
+			sel = selector{key: strings.TrimSpace(kv[0]), op: selectorEquals, values: []string{strings.TrimSpace(kv[1])}}
+		case strings.HasPrefix(term, "!"):
+			sel = selector{key: strings.TrimSpace(term[1:]), op: selectorNotExists}
+		default:
+			sel = selector{key: term, op: selectorExists}
+		}
+
+		if sel.key == "" || strings.ContainsAny(sel.key, " ()!=") {
+			return nil, fmt.Errorf("unable to parse %q", term)
+		}
+
+		selectors = append(selectors, sel)
+	}
+
+	return selectors, nil
+}
+
+// splitSelectors splits on the commas which are not inside a set of values
+func splitSelectors(s string) []string {
+	terms := []string{}
+	depth, start := 0, 0
+
+	for i, c := range s {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				terms = append(terms, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+
+	return append(terms, s[start:])
+}
+
+func matchSelectors(selectors []selector, m map[string]string) bool {
+	for _, s := range selectors {
+		v, ok := m[s.key]
+
+		var match bool
+		switch s.op {
+		case selectorEquals:
+			match = ok && v == s.values[0]
+		case selectorNotEquals:
+			match = !ok || v != s.values[0]
+		case selectorIn:
+			match = ok && contains(s.values, v)
+		case selectorNotIn:
+			match = !ok || !contains(s.values, v)
+		case selectorExists:
+			match = ok
+		case selectorNotExists:
+			match = !ok
+		}
+
+		if !match {
+			return false
+		}
+	}
+
+	return true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/hashicorp/faas-nomad/nomad"
+	"github.com/hashicorp/nomad/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestParseSelectorsParsesEveryOperator(t *testing.T) {
+	selectors, err := parseSelectors("team=billing, tier != web,env in (dev, test),zone notin (a),canary,!deprecated")
+
+	assert.Nil(t, err)
+	assert.Equal(t, []selector{
+		selector{key: "team", op: selectorEquals, values: []string{"billing"}},
+		selector{key: "tier", op: selectorNotEquals, values: []string{"web"}},
+		selector{key: "env", op: selectorIn, values: []string{"dev", "test"}},
+		selector{key: "zone", op: selectorNotIn, values: []string{"a"}},
+		selector{key: "canary", op: selectorExists},
+		selector{key: "deprecated", op: selectorNotExists},
+	}, selectors)
+}
+
+func TestParseSelectorsReturnsErrorForInvalidSelector(t *testing.T) {
+	_, err := parseSelectors("env in (dev")
+
+	assert.NotNil(t, err)
+}
+
+func TestMatchSelectors(t *testing.T) {
+	labels := map[string]string{"team": "billing", "env": "dev"}
+
+	matches := map[string]bool{
+		"team=billing":          true,
+		"team==billing":         true,
+		"team!=billing":         false,
+		"env in (dev,test)":     true,
+		"env notin (dev)":       false,
+		"tier notin (web)":      true,
+		"team,!deprecated":      true,
+		"team=billing,env=prod": false,
+	}
+
+	for s, expected := range matches {
+		selectors, err := parseSelectors(s)
+		assert.Nil(t, err, s)
+		assert.Equal(t, expected, matchSelectors(selectors, labels), s)
+	}
+}
+
+func TestParseFunctionFilterRejectsInvalidLimit(t *testing.T) {
+	_, err := parseFunctionFilter(url.Values{"limit": []string{"0"}})
+
+	assert.NotNil(t, err)
+}
+
+func setupFilteredReader(query string) (http.HandlerFunc, *httptest.ResponseRecorder, *http.Request) {
+	handler, rw, _ := setupReader()
+
+	stubs := []*api.JobListStub{}
+	for _, name := range []string{"echo", "figlet", "report"} {
+		id := nomad.JobPrefix + name
+		image := "functions/" + name
+		if name == "report" {
+			image = "private/report"
+		}
+
+		stubs = append(stubs, &api.JobListStub{ID: id, Status: "running"})
+		mockJob.On("Info", id, mock.Anything).Return(&api.Job{
+			ID: &id,
+			TaskGroups: []*api.TaskGroup{&api.TaskGroup{
+				Tasks: []*api.Task{&api.Task{
+					Name: id,
+					Config: map[string]interface{}{
+						"image":  image,
+						"labels": []interface{}{map[string]interface{}{"name": name}},
+					},
+				}},
+			}},
+		}, nil, nil)
+	}
+
+	mockJob.On("List", mock.Anything).Return(stubs, nil, nil)
+
+	return handler, rw, httptest.NewRequest("GET", "/system/functions?"+query, nil)
+}
+
+func functionNames(rw *httptest.ResponseRecorder) []string {
+	funcs := make([]FunctionStatus, 0)
+	json.Unmarshal(rw.Body.Bytes(), &funcs)
+
+	names := []string{}
+	for _, f := range funcs {
+		names = append(names, f.Name)
+	}
+
+	return names
+}
+
+func TestHandlerFiltersByLabelSelectorAndImage(t *testing.T) {
+	handler, rw, r := setupFilteredReader("labels=" + url.QueryEscape("name notin (echo)") + "&image=functions/")
+
+	handler(rw, r)
+
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, []string{"figlet"}, functionNames(rw))
+}
+
+func TestHandlerPaginatesWithContinueToken(t *testing.T) {
+	handler, rw, r := setupFilteredReader("limit=2")
+
+	handler(rw, r)
+
+	assert.Equal(t, []string{"echo", "figlet"}, functionNames(rw))
+	token := rw.Header().Get(ContinueHeader)
+	assert.NotEmpty(t, token)
+
+	rw = httptest.NewRecorder()
+	handler(rw, httptest.NewRequest("GET", "/system/functions?limit=2&continue="+token, nil))
+
+	assert.Equal(t, []string{"report"}, functionNames(rw))
+	assert.Empty(t, rw.Header().Get(ContinueHeader))
+}
+
+func TestHandlerListsWithNamePrefix(t *testing.T) {
+	handler, rw, r := setupFilteredReader("prefix=fig")
+
+	handler(rw, r)
+
+	mockJob.AssertCalled(t, "List", &api.QueryOptions{Prefix: nomad.JobPrefix + "fig"})
+}
+
+func TestHandlerReturnsBadRequestForInvalidFilter(t *testing.T) {
+	handler, rw, r := setupFilteredReader("continue=not-a-token")
+
+	handler(rw, r)
+
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+	assert.Equal(t, "application/json", rw.Header().Get("Content-Type"))
+	mockJob.AssertNotCalled(t, "List", mock.Anything)
+
+	resp := ErrorResponse{}
+	json.NewDecoder(rw.Body).Decode(&resp)
+	assert.Equal(t, http.StatusBadRequest, resp.Status)
+	assert.Contains(t, resp.Error, "continue")
+}
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hashicorp/faas-nomad/nomad"
+	"github.com/hashicorp/nomad/api"
+	"github.com/openfaas/faas/gateway/requests"
+)
+
+// functionSpecVersion is the version of the function spec written to the job
+// meta, it is incremented when the stored format changes
+const functionSpecVersion = 1
+
+// functionSpec is the versioned envelope stored in the FunctionSpecMeta key
+type functionSpec struct {
+	Version  int                            `json:"version"`
+	Function requests.CreateFunctionRequest `json:"function"`
+}
+
+// FunctionStatus is the function definition returned by the reader
+// endpoints, it extends the OpenFaaS function with the fields of the deploy
+// request so that the definition can be read back as it was deployed
+type FunctionStatus struct {
+	requests.Function
+
+	EnvVars                map[string]string           `json:"envVars,omitempty"`
+	Constraints            []string                    `json:"constraints,omitempty"`
+	Secrets                []string                    `json:"secrets,omitempty"`
+	Limits                 *requests.FunctionResources `json:"limits,omitempty"`
+	Requests               *requests.FunctionResources `json:"requests,omitempty"`
+	ReadOnlyRootFilesystem bool                        `json:"readOnlyRootFilesystem,omitempty"`
+
+	// Invocations are the invocations of the function by status class, their
+	// sum is the InvocationCount
+	Invocations map[string]uint64 `json:"invocations,omitempty"`
+}
+
+// encodeFunctionSpec returns the deploy request as a versioned spec for the
+// job meta, inline registry credentials are removed
+func encodeFunctionSpec(r requests.CreateFunctionRequest) (string, error) {
+	r.RegistryAuth = ""
+
+	data, err := json.Marshal(functionSpec{Version: functionSpecVersion, Function: r})
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+// decodeFunctionSpec returns the deploy request stored in the job meta, ok is
+// false when the job was created before the spec was stored
+func decodeFunctionSpec(job *api.Job) (*requests.CreateFunctionRequest, bool, error) {
+	data, ok := job.Meta[nomad.FunctionSpecMeta]
+	if !ok {
+		return nil, false, nil
+	}
+
+	spec := functionSpec{}
+	if err := json.Unmarshal([]byte(data), &spec); err != nil {
+		return nil, true, fmt.Errorf("Unable to decode function spec: %s", err)
+	}
+
+	if spec.Version < 1 || spec.Version > functionSpecVersion {
+		return nil, true, fmt.Errorf("Unsupported function spec version %d", spec.Version)
+	}
+
+	return &spec.Function, true, nil
+}
+
+// functionFromJob returns the definition of the function deployed as the job,
+// jobs without a readable spec are read from the task config
+func functionFromJob(job *api.Job) (FunctionStatus, error) {
+	r, ok, err := decodeFunctionSpec(job)
+	if !ok || err != nil {
+		return functionFromTask(job), err
+	}
+
+	f := FunctionStatus{
+		Function: requests.Function{
+			Name:        sanitiseJobName(job),
+			Image:       r.Image,
+			Replicas:    functionReplicas(job),
+			EnvProcess:  r.EnvProcess,
+			Labels:      r.Labels,
+			Annotations: functionAnnotations(job),
+		},
+		EnvVars:                r.EnvVars,
+		Constraints:            r.Constraints,
+		Secrets:                r.Secrets,
+		Limits:                 r.Limits,
+		Requests:               r.Requests,
+		ReadOnlyRootFilesystem: r.ReadOnlyRootFilesystem,
+	}
+
+	if f.Labels == nil {
+		f.Labels = &map[string]string{}
+	}
+
+	return f, nil
+}
+
+// functionFromTask rebuilds the function definition from the task of a job
+// created without a spec, values with an unexpected shape are ignored
+func functionFromTask(job *api.Job) FunctionStatus {
+	f := FunctionStatus{
+		Function: requests.Function{
+			Name:        sanitiseJobName(job),
+			Replicas:    functionReplicas(job),
+			Labels:      &map[string]string{},
+			Annotations: functionAnnotations(job),
+		},
+	}
+
+	if len(job.TaskGroups) == 0 || len(job.TaskGroups[0].Tasks) == 0 {
+		return f
+	}
+
+	task := job.TaskGroups[0].Tasks[0]
+
+	f.Image, _ = task.Config["image"].(string)
+	f.Labels = parseLabels(task.Config["labels"])
+
+	for k, v := range task.Env {
+		if k == "fprocess" {
+			f.EnvProcess = v
+			continue
+		}
+
+		if f.EnvVars == nil {
+			f.EnvVars = map[string]string{}
+		}
+		f.EnvVars[k] = v
+	}
+
+	if task.Resources != nil && task.Resources.MemoryMB != nil && task.Resources.CPU != nil {
+		f.Limits = &requests.FunctionResources{
+			Memory: strconv.Itoa(*task.Resources.MemoryMB),
+			CPU:    strconv.Itoa(*task.Resources.CPU),
+		}
+	}
+
+	return f
+}
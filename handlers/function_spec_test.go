@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/hashicorp/faas-nomad/nomad"
+	fntypes "github.com/hashicorp/faas-nomad/types"
+	"github.com/hashicorp/faas-nomad/vault"
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
+	"github.com/openfaas/faas/gateway/requests"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFunctionSpecRoundTripsDeployRequest(t *testing.T) {
+	fr := createRequest()
+	fr.Image = "functions/report:1.0"
+	fr.EnvProcess = "./report"
+	fr.EnvVars = map[string]string{"LOG_LEVEL": "debug"}
+	fr.Constraints = []string{"node.datacenter == dc2"}
+	fr.Secrets = []string{"db_password"}
+	fr.Limits = &requests.FunctionResources{Memory: "256", CPU: "200"}
+	fr.Labels = &map[string]string{"team": "billing"}
+	fr.Annotations = &map[string]string{"topic": "reports"}
+	fr.RegistryAuth = "dXNlcjpwYXNz"
+
+	secrets := vault.NewVaultService(&fntypes.VaultConfig{DefaultPolicy: "openfaas", SecretPathPrefix: "secret/openfaas"}, hclog.Default())
+
+	job, err := createJob(fr.CreateFunctionRequest, fntypes.ProviderConfig{Datacenter: "dc1", Secrets: secrets})
+	assert.Nil(t, err)
+
+	f, err := functionFromJob(job)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "TestFunction", f.Name)
+	assert.Equal(t, "functions/report:1.0", f.Image)
+	assert.Equal(t, "./report", f.EnvProcess)
+	assert.Equal(t, map[string]string{"LOG_LEVEL": "debug"}, f.EnvVars)
+	assert.Equal(t, []string{"node.datacenter == dc2"}, f.Constraints)
+	assert.Equal(t, []string{"db_password"}, f.Secrets)
+	assert.Equal(t, fr.Limits, f.Limits)
+	assert.Equal(t, map[string]string{"team": "billing"}, *f.Labels)
+	assert.Equal(t, map[string]string{"topic": "reports"}, *f.Annotations)
+	assert.NotContains(t, job.Meta[nomad.FunctionSpecMeta], "dXNlcjpwYXNz")
+}
+
+func TestFunctionFromJobWithoutSpecReadsTaskConfig(t *testing.T) {
+	job := createMockJob("1234", 2)
+	job.TaskGroups[0].Tasks[0].Env = map[string]string{"fprocess": "cat", "LOG_LEVEL": "debug"}
+
+	f, err := functionFromJob(job)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "docker", f.Image)
+	assert.Equal(t, "cat", f.EnvProcess)
+	assert.Equal(t, map[string]string{"LOG_LEVEL": "debug"}, f.EnvVars)
+	assert.Equal(t, map[string]string{"label": "test"}, *f.Labels)
+	assert.Equal(t, uint64(2), f.Replicas)
+}
+
+func TestFunctionFromJobIgnoresUnexpectedTaskConfig(t *testing.T) {
+	job := createMockJob("1234", 1)
+	job.TaskGroups[0].Tasks[0].Config = map[string]interface{}{"image": 42, "labels": "team=billing"}
+
+	f, err := functionFromJob(job)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "", f.Image)
+	assert.Empty(t, *f.Labels)
+}
+
+func TestFunctionFromJobWithUnsupportedSpecVersionReadsTaskConfig(t *testing.T) {
+	job := createMockJob("1234", 1)
+	job.Meta = map[string]string{nomad.FunctionSpecMeta: `{"version":99,"function":{"image":"other"}}`}
+
+	f, err := functionFromJob(job)
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "docker", f.Image)
+	assert.NotContains(t, *f.Annotations, nomad.FunctionSpecMeta)
+}
+
+func TestFunctionFromJobWithoutTasksDoesNotPanic(t *testing.T) {
+	id := nomad.JobPrefix + "report"
+	f, err := functionFromJob(&api.Job{ID: &id})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "report", f.Name)
+}
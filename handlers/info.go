@@ -11,8 +11,41 @@ import (
 
 const nomadIdentifier = "nomad"
 
+// InfoConfig contains the static details reported by the info handler
+type InfoConfig struct {
+	Version      string
+	SHA          string
+	Datacenter   string
+	Region       string
+	Capabilities Capabilities
+	Backends     BackendVersionSource
+	// SecretsAvailable sets the secrets capability on each request, the
+	// secret store may become available after startup
+	SecretsAvailable func() bool
+}
+
+// Capabilities lists the optional features supported by this provider so that
+// tooling can adapt to what is available
+type Capabilities struct {
+	Namespaces  bool `json:"namespaces"`
+	Secrets     bool `json:"secrets"`
+	Async       bool `json:"async"`
+	Logs        bool `json:"logs"`
+	ScaleToZero bool `json:"scaleToZero"`
+}
+
+// InfoResponse extends the OpenFaaS info response with details of the cluster
+// the provider is connected to
+type InfoResponse struct {
+	types.InfoRequest
+	Datacenter   string          `json:"datacenter"`
+	Region       string          `json:"region"`
+	Backends     BackendVersions `json:"backends"`
+	Capabilities Capabilities    `json:"capabilities"`
+}
+
 // MakeInfo creates handler for /system/info endpoint
-func MakeInfo(logger hclog.Logger, stats metrics.StatsD, version string) http.HandlerFunc {
+func MakeInfo(config InfoConfig, logger hclog.Logger, stats metrics.StatsD) http.HandlerFunc {
 	log := logger.Named("info_handler")
 
 	return func(rw http.ResponseWriter, r *http.Request) {
@@ -23,15 +56,28 @@ func MakeInfo(logger hclog.Logger, stats metrics.StatsD, version string) http.Ha
 			defer r.Body.Close()
 		}
 
-		infoRequest := types.InfoRequest{
-			Orchestration: nomadIdentifier,
-			Version: types.ProviderVersion{
-				Release: version,
-				SHA:     "", // Note: this is an optional field.
+		infoResponse := InfoResponse{
+			InfoRequest: types.InfoRequest{
+				Orchestration: nomadIdentifier,
+				Version: types.ProviderVersion{
+					Release: config.Version,
+					SHA:     config.SHA,
+				},
 			},
+			Datacenter:   config.Datacenter,
+			Region:       config.Region,
+			Capabilities: config.Capabilities,
+		}
+
+		if config.SecretsAvailable != nil {
+			infoResponse.Capabilities.Secrets = config.SecretsAvailable()
+		}
+
+		if config.Backends != nil {
+			infoResponse.Backends = config.Backends.Versions()
 		}
 
-		jsonOut, marshalErr := json.Marshal(infoRequest)
+		jsonOut, marshalErr := json.Marshal(infoResponse)
 		if marshalErr != nil {
 			rw.WriteHeader(http.StatusInternalServerError)
 			log.Warn("Unable to marshal system info", marshalErr.Error())
@@ -17,6 +17,13 @@ import (
 )
 
 const infoTestVersion = "test"
+const infoTestSHA = "abc123"
+
+type mockBackendVersions struct{}
+
+func (m *mockBackendVersions) Versions() BackendVersions {
+	return BackendVersions{Nomad: "0.8.4", Consul: "1.2.0", Vault: "0.9.6"}
+}
 
 func setupInfo(body string) (http.HandlerFunc, *httptest.ResponseRecorder, *http.Request) {
 
@@ -29,7 +36,16 @@ func setupInfo(body string) (http.HandlerFunc, *httptest.ResponseRecorder, *http
 
 	logger := hclog.Default()
 
-	return MakeInfo(logger, mockStats, infoTestVersion),
+	config := InfoConfig{
+		Version:      infoTestVersion,
+		SHA:          infoTestSHA,
+		Datacenter:   "dc1",
+		Region:       "global",
+		Capabilities: Capabilities{Secrets: true, ScaleToZero: true},
+		Backends:     &mockBackendVersions{},
+	}
+
+	return MakeInfo(config, logger, mockStats),
 		httptest.NewRecorder(),
 		httptest.NewRequest("GET", "/system/info", bytes.NewReader([]byte(body)))
 }
@@ -56,3 +72,55 @@ func TestInfoReportsNomadProvider(t *testing.T) {
 
 	assert.Equal(t, infoRequest.Version.Release, infoTestVersion)
 }
+
+func TestInfoReportsSHAAndBackendVersions(t *testing.T) {
+	h, rw, r := setupInfo("")
+
+	h(rw, r)
+
+	info := InfoResponse{}
+	err := json.NewDecoder(rw.Body).Decode(&info)
+
+	assert.Nil(t, err)
+	assert.Equal(t, infoTestSHA, info.Version.SHA)
+	assert.Equal(t, "0.8.4", info.Backends.Nomad)
+	assert.Equal(t, "1.2.0", info.Backends.Consul)
+	assert.Equal(t, "0.9.6", info.Backends.Vault)
+	assert.Equal(t, "dc1", info.Datacenter)
+	assert.Equal(t, "global", info.Region)
+}
+
+func TestInfoReportsCapabilities(t *testing.T) {
+	h, rw, r := setupInfo("")
+
+	h(rw, r)
+
+	info := InfoResponse{}
+	err := json.NewDecoder(rw.Body).Decode(&info)
+
+	assert.Nil(t, err)
+	assert.True(t, info.Capabilities.Secrets)
+	assert.True(t, info.Capabilities.ScaleToZero)
+	assert.False(t, info.Capabilities.Namespaces)
+}
+
+func TestInfoReportsSecretsOnceAvailable(t *testing.T) {
+	mockStats := &metrics.MockStatsD{}
+	mockStats.On("Incr", mock.Anything, mock.Anything, mock.Anything)
+
+	available := false
+	config := InfoConfig{SecretsAvailable: func() bool { return available }}
+	h := MakeInfo(config, hclog.Default(), mockStats)
+
+	info := InfoResponse{}
+	rw := httptest.NewRecorder()
+	h(rw, httptest.NewRequest("GET", "/system/info", nil))
+	json.NewDecoder(rw.Body).Decode(&info)
+	assert.False(t, info.Capabilities.Secrets)
+
+	available = true
+	rw = httptest.NewRecorder()
+	h(rw, httptest.NewRequest("GET", "/system/info", nil))
+	json.NewDecoder(rw.Body).Decode(&info)
+	assert.True(t, info.Capabilities.Secrets)
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/hashicorp/faas-nomad/metrics"
+)
+
+// MakeInvocationCounter creates a middleware which counts the invocations of
+// the function set by the ExtractFunction middleware by response status
+func MakeInvocationCounter(counter metrics.InvocationCounter, next http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		recorder := &invocationRecorder{ResponseWriter: rw, status: http.StatusOK}
+		next(recorder, r)
+
+		counter.Incr(r.Context().Value(FunctionNameCTXKey).(string), recorder.status)
+	}
+}
+
+// setInvocationCount adds the invocations of the function to its status
+func setInvocationCount(f *FunctionStatus, counter metrics.InvocationCounter) {
+	counts := counter.Counts(f.Name)
+
+	var total uint64
+	for _, n := range counts {
+		total += n
+	}
+
+	f.InvocationCount = float64(total)
+	if len(counts) > 0 {
+		f.Invocations = counts
+	}
+}
+
+// invocationRecorder captures the status code written by the proxy
+type invocationRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (i *invocationRecorder) WriteHeader(status int) {
+	i.status = status
+	i.ResponseWriter.WriteHeader(status)
+}
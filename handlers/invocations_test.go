@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/faas-nomad/metrics"
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInvocationCounterCountsResponsesByStatusClass(t *testing.T) {
+	counter := metrics.NewInvocations("test", nil, 0, hclog.Default())
+
+	statuses := []int{http.StatusOK, http.StatusAccepted, http.StatusNotFound}
+	for _, status := range statuses {
+		next := func(rw http.ResponseWriter, r *http.Request) {
+			rw.WriteHeader(status)
+		}
+
+		r := httptest.NewRequest(http.MethodPost, "/function/echo", nil)
+		r = r.WithContext(context.WithValue(r.Context(), FunctionNameCTXKey, "echo"))
+
+		MakeInvocationCounter(counter, next)(httptest.NewRecorder(), r)
+	}
+
+	assert.Equal(t, map[string]uint64{"2xx": 2, "4xx": 1}, counter.Counts("echo"))
+}
@@ -23,11 +23,16 @@ func MakeProxy(config ProxyConfig) http.HandlerFunc {
 		lbCache:  c,
 		client:   config.Client,
 		resolver: config.Resolver,
+		tracker:  config.Tracker,
 		stats:    config.StatsD,
 		logger:   config.Logger.Named("proxy_client"),
 		timeout:  config.Timeout,
 	}
 
+	if p.tracker == nil {
+		p.tracker = NewRequestTracker()
+	}
+
 	return func(rw http.ResponseWriter, r *http.Request) {
 		p.ServeHTTP(rw, r)
 	}
@@ -36,9 +41,11 @@ func MakeProxy(config ProxyConfig) http.HandlerFunc {
 type ProxyConfig struct {
 	Client   ProxyClient
 	Resolver consul.ServiceResolver
-	Logger   hclog.Logger
-	StatsD   *statsd.Client
-	Timeout  time.Duration
+	// Tracker records in-flight requests so that functions can be drained before deletion
+	Tracker *RequestTracker
+	Logger  hclog.Logger
+	StatsD  *statsd.Client
+	Timeout time.Duration
 }
 
 // Proxy is a http.Handler which implements the ability to call a downstream function
@@ -46,6 +53,7 @@ type Proxy struct {
 	lbCache  *cache.Cache
 	client   ProxyClient
 	resolver consul.ServiceResolver
+	tracker  *RequestTracker
 	stats    *statsd.Client
 	logger   hclog.Logger
 	timeout  time.Duration
@@ -64,6 +72,14 @@ func (p *Proxy) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 
 	service := r.Context().Value(FunctionNameCTXKey).(string)
 
+	if !p.tracker.Start(service) {
+		http.Error(rw, "Function is being deleted", http.StatusGone)
+		p.logger.Info("Rejected request for draining function", "function", service)
+
+		return
+	}
+	defer p.tracker.Done(service)
+
 	urls, _ := p.resolver.Resolve(service)
 	if len(urls) == 0 {
 		http.Error(rw, "Function Not Found", http.StatusNotFound)
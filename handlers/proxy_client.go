@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/hashicorp/faas-nomad/consul"
 	hclog "github.com/hashicorp/go-hclog"
 )
 
@@ -22,7 +23,10 @@ type ProxyClient interface {
 // HTTPProxyClient allows the calling of functions
 type HTTPProxyClient struct {
 	proxyClient *http.Client
-	logger      hclog.Logger
+	// connectClient calls functions through their Connect proxy, it is nil
+	// when Connect is not enabled
+	connectClient *http.Client
+	logger        hclog.Logger
 }
 
 // MakeProxyClient creates a new HTTPProxyClient
@@ -47,6 +51,15 @@ func MakeProxyClient(timeout time.Duration, l hclog.Logger) *HTTPProxyClient {
 	}
 }
 
+// MakeConnectProxyClient creates a HTTPProxyClient which calls functions
+// resolved to a Connect URL with the Connect client
+func MakeConnectProxyClient(timeout time.Duration, connectClient *http.Client, l hclog.Logger) *HTTPProxyClient {
+	pc := MakeProxyClient(timeout, l)
+	pc.connectClient = connectClient
+
+	return pc
+}
+
 // GetFunctionName returns the name of the function from the request vars
 func (pc *HTTPProxyClient) GetFunctionName(r *http.Request) string {
 	vars := mux.Vars(r)
@@ -69,7 +82,12 @@ func (pc *HTTPProxyClient) CallAndReturnResponse(address string, body []byte, he
 
 	defer request.Body.Close()
 
-	response, err := pc.proxyClient.Do(request)
+	client := pc.proxyClient
+	if pc.connectClient != nil && consul.IsConnectURL(address) {
+		client = pc.connectClient
+	}
+
+	response, err := client.Do(request)
 	if err != nil {
 		log.Println(err.Error())
 		return nil, nil, response.StatusCode, err
@@ -72,3 +72,28 @@ func TestClientReturnsBodysFromRequest(t *testing.T) {
 
 	assert.Equal(t, "my body", string(b))
 }
+
+type recordingTransport struct {
+	hosts []string
+}
+
+func (rt *recordingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	rt.hosts = append(rt.hosts, r.URL.Host)
+
+	return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(bytes.NewReader(nil)), Header: http.Header{}}, nil
+}
+
+func TestConnectClientCallsConnectURLsWithConnectClient(t *testing.T) {
+	body := []byte("request body")
+	rt := &recordingTransport{}
+	_, r, s := setupProxyClient(body)
+	defer s.Close()
+
+	c := MakeConnectProxyClient(5*time.Second, &http.Client{Transport: rt}, hclog.Default())
+
+	c.CallAndReturnResponse("https://secure.service.consul", body, r.Header)
+	c.CallAndReturnResponse(s.URL, body, r.Header)
+
+	assert.Equal(t, []string{"secure.service.consul"}, rt.hosts)
+	assert.Equal(t, "request body", string(postBody))
+}
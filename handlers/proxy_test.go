@@ -18,10 +18,12 @@ import (
 )
 
 var mockProxyClient *MockProxyClient
+var proxyTracker *RequestTracker
 
 func setupProxy(body string) (http.HandlerFunc, *httptest.ResponseRecorder, *http.Request) {
 	mockProxyClient = &MockProxyClient{}
 	mockServiceResolver = &consul.MockResolver{}
+	proxyTracker = NewRequestTracker()
 
 	// override the retryDelay to improve test speed
 	retryDelay = 2 * time.Millisecond
@@ -36,6 +38,7 @@ func setupProxy(body string) (http.HandlerFunc, *httptest.ResponseRecorder, *htt
 		ProxyConfig{
 			Client:   mockProxyClient,
 			Resolver: mockServiceResolver,
+			Tracker:  proxyTracker,
 			Logger:   logger,
 			StatsD:   nil,
 			Timeout:  5 * time.Second,
@@ -150,3 +153,27 @@ func setEnv() func() {
 		}
 	}
 }
+
+func TestProxyHandlerReturnsGoneWhenFunctionDraining(t *testing.T) {
+	h, rr, r := setupProxy("")
+	proxyTracker.Drain("function")
+
+	h(rr, r)
+
+	assert.Equal(t, http.StatusGone, rr.Code)
+	mockServiceResolver.AssertNotCalled(t, "Resolve", mock.Anything)
+}
+
+func TestProxyHandlerTracksInFlightRequests(t *testing.T) {
+	h, rr, r := setupProxy("")
+	mockServiceResolver.On("Resolve", "function").
+		Run(func(args mock.Arguments) {
+			assert.Equal(t, 1, proxyTracker.InFlight("function"))
+		}).
+		Return([]string{})
+
+	h(rr, r)
+
+	mockServiceResolver.AssertCalled(t, "Resolve", "function")
+	assert.Equal(t, 0, proxyTracker.InFlight("function"))
+}
@@ -4,28 +4,35 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/hashicorp/faas-nomad/metrics"
 	"github.com/hashicorp/faas-nomad/nomad"
 	hclog "github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/nomad/api"
-	"github.com/openfaas/faas/gateway/requests"
 )
 
 // MakeReader implements the OpenFaaS reader handler
-func MakeReader(client nomad.Job, logger hclog.Logger, stats metrics.StatsD) http.HandlerFunc {
+func MakeReader(client nomad.Job, invocations metrics.InvocationCounter, logger hclog.Logger, stats metrics.StatsD) http.HandlerFunc {
 	log := logger.Named("reader_handler")
 
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Not sure if prefix is the right option
-		options := &api.QueryOptions{}
-		options.Prefix = nomad.JobPrefix
-
 		log.Info("List functions called")
 		stats.Incr("reader.called", nil, 1)
 
-		jobs, _, err := client.List(options)
+		filter, err := parseFunctionFilter(r.URL.Query())
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+
+			log.Error("Invalid function filter", "error", err)
+			stats.Incr("reader.error.badrequest", nil, 1)
+			return
+		}
+
+		jobs, meta, err := client.List(filter.queryOptions())
 		if err != nil {
 			writeError(w, err)
 
@@ -34,7 +41,7 @@ func MakeReader(client nomad.Job, logger hclog.Logger, stats metrics.StatsD) htt
 			return
 		}
 
-		functions, err := getFunctions(client, jobs)
+		functions, next, err := getFunctions(client, jobs, filter, invocations, log)
 		if err != nil {
 			writeError(w, err)
 
@@ -43,6 +50,11 @@ func MakeReader(client nomad.Job, logger hclog.Logger, stats metrics.StatsD) htt
 			return
 		}
 
+		if next != "" {
+			w.Header().Set(ContinueHeader, next)
+		}
+
+		writeQueryMeta(w, meta)
 		writeFunctionResponse(w, functions)
 
 		log.Info("List functions success")
@@ -50,47 +62,143 @@ func MakeReader(client nomad.Job, logger hclog.Logger, stats metrics.StatsD) htt
 	}
 }
 
-func getFunctions(client nomad.Job, jobs []*api.JobListStub) ([]requests.Function, error) {
-	functions := make([]requests.Function, 0)
+// getFunctions returns the functions which match the filter, when the limit
+// is reached the continue token for the next page is returned
+func getFunctions(client nomad.Job, jobs []*api.JobListStub, filter *functionFilter, invocations metrics.InvocationCounter, log hclog.Logger) ([]FunctionStatus, string, error) {
+	candidates := []*api.JobListStub{}
 	for _, j := range jobs {
+		if filter.matchJob(j) {
+			candidates = append(candidates, j)
+		}
+	}
 
-		if j.Status == "running" || j.Status == "pending" {
-			job, _, err := client.Info(j.ID, nil)
-			if err != nil {
-				return functions, err
-			}
+	sort.Slice(candidates, func(a, b int) bool { return candidates[a].ID < candidates[b].ID })
+
+	functions := make([]FunctionStatus, 0)
+	for n, j := range candidates {
+		job, _, err := client.Info(j.ID, nil)
+		if err != nil {
+			return functions, "", err
+		}
+
+		f, err := functionFromJob(job)
+		if err != nil {
+			log.Warn("Unable to read function spec, using task config", "job", j.ID, "error", err)
+		}
+
+		if !filter.matchFunction(f) {
+			continue
+		}
+
+		setInvocationCount(&f, invocations)
+
+		functions = append(functions, f)
+
+		if filter.limit > 0 && len(functions) == filter.limit && n < len(candidates)-1 {
+			return functions, continueToken(j.ID), nil
+		}
+	}
+
+	return functions, "", nil
+}
 
-			functions = append(functions, requests.Function{
-				Name:            sanitiseJobName(job),
-				Image:           job.TaskGroups[0].Tasks[0].Config["image"].(string),
-				Replicas:        uint64(*job.TaskGroups[0].Count),
-				InvocationCount: 0,
-				Labels:          parseLabels(job.TaskGroups[0].Tasks[0].Config["labels"].([]interface{})),
-				Annotations:     &job.Meta,
-			})
+// functionReplicas returns the replicas of a function, a scheduled function
+// has one replica while its schedule is enabled and none when it is paused, a
+// dispatched function always has one so that the gateway does not wait for
+// it to scale up
+func functionReplicas(job *api.Job) uint64 {
+	if job.IsParameterized() {
+		return 1
+	}
+
+	if job.IsPeriodic() {
+		if job.Periodic.Enabled != nil && !*job.Periodic.Enabled {
+			return 0
 		}
+
+		return 1
+	}
+
+	if len(job.TaskGroups) == 0 || job.TaskGroups[0].Count == nil {
+		return 0
+	}
+
+	return uint64(*job.TaskGroups[0].Count)
+}
+
+// functionAnnotations returns the job meta without the function spec, the
+// next launch time is added for scheduled functions
+func functionAnnotations(job *api.Job) *map[string]string {
+	annotations := map[string]string{}
+	for k, v := range job.Meta {
+		if k == nomad.FunctionSpecMeta {
+			continue
+		}
+
+		annotations[k] = v
+	}
+
+	if next := nomad.NextLaunch(job, time.Now()); !next.IsZero() {
+		annotations[nomad.NextLaunchAnnotation] = next.UTC().Format(time.RFC3339)
 	}
 
-	return functions, nil
+	return &annotations
 }
 
-func parseLabels(labels []interface{}) *map[string]string {
+// parseLabels returns the Docker labels of a task, the config is a list of
+// maps when read from Nomad and labels which are not strings are ignored
+func parseLabels(config interface{}) *map[string]string {
 	newLabels := map[string]string{}
-	if len(labels) > 0 {
-		for _, l := range labels {
-			for k, v := range l.(map[string]interface{}) {
-				newLabels[k] = v.(string)
+
+	var labels []interface{}
+	switch l := config.(type) {
+	case []interface{}:
+		labels = l
+	case []map[string]interface{}:
+		for _, m := range l {
+			labels = append(labels, m)
+		}
+	case map[string]interface{}:
+		labels = append(labels, l)
+	}
+
+	for _, l := range labels {
+		m, _ := l.(map[string]interface{})
+		for k, v := range m {
+			if s, ok := v.(string); ok {
+				newLabels[k] = s
 			}
 		}
 	}
+
 	return &newLabels
 }
 
 func sanitiseJobName(job *api.Job) string {
+	if len(job.TaskGroups) == 0 || len(job.TaskGroups[0].Tasks) == 0 {
+		if job.ID == nil {
+			return ""
+		}
+
+		return strings.TrimPrefix(*job.ID, nomad.JobPrefix)
+	}
+
 	return strings.Replace(job.TaskGroups[0].Tasks[0].Name, nomad.JobPrefix, "", -1)
 }
 
-func writeFunctionResponse(w http.ResponseWriter, fs []requests.Function) {
+// writeQueryMeta adds the Nomad index and the time since the provider was in
+// contact with Nomad to the response, a large X-Nomad-LastContact shows that
+// the function inventory is stale
+func writeQueryMeta(w http.ResponseWriter, meta *api.QueryMeta) {
+	if meta == nil {
+		return
+	}
+
+	w.Header().Set("X-Nomad-Index", strconv.FormatUint(meta.LastIndex, 10))
+	w.Header().Set("X-Nomad-LastContact", strconv.FormatInt(int64(meta.LastContact/time.Millisecond), 10))
+}
+
+func writeFunctionResponse(w http.ResponseWriter, fs []FunctionStatus) {
 	functionBytes, _ := json.Marshal(fs)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(200)
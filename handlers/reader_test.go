@@ -9,6 +9,7 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/faas-nomad/metrics"
 	"github.com/hashicorp/faas-nomad/nomad"
@@ -25,8 +26,9 @@ func setupReader() (http.HandlerFunc, *httptest.ResponseRecorder, *http.Request)
 	mockStatsD.On("Incr", mock.Anything, mock.Anything, mock.Anything)
 
 	logger := hclog.Default()
+	invocations = metrics.NewInvocations("test", nil, 0, logger)
 
-	return MakeReader(mockJob, logger, mockStatsD),
+	return MakeReader(mockJob, invocations, logger, mockStatsD),
 		httptest.NewRecorder(),
 		httptest.NewRequest("GET", "/system/functions", bytes.NewReader([]byte("")))
 }
@@ -137,3 +139,59 @@ func TestHandlerReturnsRunningDeployments(t *testing.T) {
 
 	assert.Equal(t, 2, len(funcs))
 }
+
+func TestHandlerReturnsScheduledFunctionsWithNextLaunch(t *testing.T) {
+	handler, rw, r := setupReader()
+
+	a1 := createMockJob("1234", 1)
+	a1.Periodic, _ = nomad.ParsePeriodic(map[string]string{nomad.ScheduleAnnotation: "*/5 * * * *"})
+
+	d := []*api.JobListStub{
+		&api.JobListStub{ID: *a1.ID, Status: *a1.Status, Periodic: true},
+		&api.JobListStub{ID: *a1.ID + "/periodic-1", ParentID: *a1.ID, Status: "running"},
+	}
+
+	mockJob.On("List", mock.Anything).Return(d, nil, nil)
+	mockJob.On("Info", *a1.ID, mock.Anything).Return(a1, nil, nil)
+
+	handler(rw, r)
+
+	funcs := make([]requests.Function, 0)
+	json.NewDecoder(rw.Body).Decode(&funcs)
+
+	assert.Equal(t, 1, len(funcs))
+	assert.Equal(t, uint64(1), funcs[0].Replicas)
+	assert.NotEmpty(t, (*funcs[0].Annotations)[nomad.NextLaunchAnnotation])
+	assert.Empty(t, a1.Meta[nomad.NextLaunchAnnotation])
+}
+
+func TestHandlerReturnsInvocationCounts(t *testing.T) {
+	handler, rw, r := setupReader()
+
+	a1 := createMockJob("1234", 1)
+	mockJob.On("List", mock.Anything).Return([]*api.JobListStub{&api.JobListStub{ID: *a1.ID, Status: *a1.Status}}, nil, nil)
+	mockJob.On("Info", *a1.ID, mock.Anything).Return(a1, nil, nil)
+
+	invocations.Incr("Task1234", http.StatusOK)
+	invocations.Incr("Task1234", http.StatusOK)
+	invocations.Incr("Task1234", http.StatusBadGateway)
+
+	handler(rw, r)
+
+	funcs := make([]FunctionStatus, 0)
+	json.Unmarshal(rw.Body.Bytes(), &funcs)
+
+	assert.Equal(t, float64(3), funcs[0].InvocationCount)
+	assert.Equal(t, map[string]uint64{"2xx": 2, "5xx": 1}, funcs[0].Invocations)
+}
+
+func TestHandlerReturnsQueryMetaHeaders(t *testing.T) {
+	handler, rw, r := setupReader()
+
+	mockJob.On("List", mock.Anything).Return([]*api.JobListStub{}, &api.QueryMeta{LastIndex: 42, LastContact: 1500 * time.Millisecond}, nil)
+
+	handler(rw, r)
+
+	assert.Equal(t, "42", rw.Header().Get("X-Nomad-Index"))
+	assert.Equal(t, "1500", rw.Header().Get("X-Nomad-LastContact"))
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/hashicorp/faas-nomad/metrics"
+	"github.com/hashicorp/faas-nomad/reconciler"
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// Reconciler finds and removes orphaned function resources
+type Reconciler interface {
+	Reconcile(dryRun bool) reconciler.Report
+}
+
+// MakeReconcileReport creates a handler which returns a dry run report of the
+// resources the reconciler would remove
+func MakeReconcileReport(rec Reconciler, logger hclog.Logger, stats metrics.StatsD) http.HandlerFunc {
+	log := logger.Named("reconcile_handler")
+
+	return func(rw http.ResponseWriter, r *http.Request) {
+		stats.Incr("reconcile.report.called", nil, 1)
+
+		if r.Body != nil {
+			defer r.Body.Close()
+		}
+
+		report := rec.Reconcile(true)
+
+		jsonOut, err := json.Marshal(report)
+		if err != nil {
+			rw.WriteHeader(http.StatusInternalServerError)
+			log.Error("Unable to marshal reconcile report", "error", err)
+			return
+		}
+
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusOK)
+		rw.Write(jsonOut)
+	}
+}
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/faas-nomad/metrics"
+	"github.com/hashicorp/faas-nomad/reconciler"
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type mockReconciler struct {
+	dryRun bool
+}
+
+func (m *mockReconciler) Reconcile(dryRun bool) reconciler.Report {
+	m.dryRun = dryRun
+	return reconciler.Report{DryRun: dryRun, PurgedJobs: []string{"old"}}
+}
+
+func TestReconcileReportIsADryRun(t *testing.T) {
+	mockStats := &metrics.MockStatsD{}
+	mockStats.On("Incr", mock.Anything, mock.Anything, mock.Anything)
+	rec := &mockReconciler{}
+
+	h := MakeReconcileReport(rec, hclog.Default(), mockStats)
+	rw := httptest.NewRecorder()
+
+	h(rw, httptest.NewRequest("GET", "/system/reconcile", nil))
+
+	report := reconciler.Report{}
+	json.NewDecoder(rw.Body).Decode(&report)
+
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.True(t, rec.dryRun)
+	assert.Equal(t, []string{"old"}, report.PurgedJobs)
+}
@@ -5,22 +5,22 @@ import (
 	"fmt"
 	"net/http"
 
+	"github.com/hashicorp/faas-nomad/audit"
 	"github.com/hashicorp/faas-nomad/metrics"
 	"github.com/hashicorp/faas-nomad/nomad"
 	hclog "github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/nomad/api"
 	"github.com/openfaas/faas-provider/types"
-	"github.com/openfaas/faas/gateway/requests"
 )
 
 // MakeReplicationReader creates a replication reader handler
-func MakeReplicationReader(client nomad.Job, logger hclog.Logger, stats metrics.StatsD) http.HandlerFunc {
+func MakeReplicationReader(client nomad.Job, invocations metrics.InvocationCounter, logger hclog.Logger, stats metrics.StatsD) http.HandlerFunc {
 	log := logger.Named("replicationreader_handler")
 
 	return func(rw http.ResponseWriter, r *http.Request) {
 		stats.Incr("replicationreader.called", nil, 1)
 
-		job, err := getJob(client, r)
+		job, meta, err := getJob(client, r)
 		if job == nil || err != nil {
 			rw.WriteHeader(http.StatusNotFound)
 			fmt.Fprint(rw, err)
@@ -30,8 +30,13 @@ func MakeReplicationReader(client nomad.Job, logger hclog.Logger, stats metrics.
 			return
 		}
 
-		// get the number of available allocations from the job
+		// get the number of available allocations from the job, scheduled
+		// functions are available while their schedule is enabled and
+		// dispatched functions do not have resident allocations
 		allocs, err := getAllocationReadyCount(client, job, r)
+		if job.IsPeriodic() || job.IsParameterized() {
+			allocs = functionReplicas(job)
+		}
 		if err != nil {
 			rw.WriteHeader(http.StatusInternalServerError)
 			fmt.Fprint(rw, err)
@@ -41,15 +46,14 @@ func MakeReplicationReader(client nomad.Job, logger hclog.Logger, stats metrics.
 			return
 		}
 
-		resp := requests.Function{
-			Name:              sanitiseJobName(job),
-			Image:             job.TaskGroups[0].Tasks[0].Config["image"].(string),
-			Replicas:          uint64(*job.TaskGroups[0].Count),
-			AvailableReplicas: allocs,
-			Labels:            parseLabels(job.TaskGroups[0].Tasks[0].Config["labels"].([]interface{})),
-			Annotations:       &job.Meta,
+		resp, err := functionFromJob(job)
+		if err != nil {
+			log.Warn("Unable to read function spec, using task config", "job", *job.ID, "error", err)
 		}
+		resp.AvailableReplicas = allocs
+		setInvocationCount(&resp, invocations)
 
+		writeQueryMeta(rw, meta)
 		rw.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(rw).Encode(resp)
 
@@ -64,7 +68,7 @@ func MakeReplicationWriter(client nomad.Job, logger hclog.Logger, stats metrics.
 	return func(rw http.ResponseWriter, r *http.Request) {
 		stats.Incr("replicationwriter.called", nil, 1)
 
-		job, err := getJob(client, r)
+		job, _, err := getJob(client, r)
 		if job == nil || err != nil {
 			rw.WriteHeader(http.StatusNotFound)
 
@@ -83,13 +87,28 @@ func MakeReplicationWriter(client nomad.Job, logger hclog.Logger, stats metrics.
 			return
 		}
 
+		// dispatched functions start an allocation for each invocation so
+		// there is nothing to scale
+		if job.IsParameterized() {
+			log.Info("Ignoring scale request for dispatched function", "function", req.ServiceName, "scale", req.Replicas)
+			stats.Incr("replicationwriter.success", nil, 1)
+			return
+		}
+
 		// update nomad job
 		log.Info("Updating function", "function", req.ServiceName, "scale", req.Replicas)
 
-		replicas := int(req.Replicas)
-		job.TaskGroups[0].Count = &replicas
+		if job.IsPeriodic() {
+			// scheduled functions are paused when scaled to zero and run a
+			// single allocation per launch otherwise
+			enabled := req.Replicas > 0
+			job.Periodic.Enabled = &enabled
+		} else {
+			replicas := int(req.Replicas)
+			job.TaskGroups[0].Count = &replicas
+		}
 
-		_, _, err = client.Register(job, nil)
+		resp, _, err := client.Register(job, nil)
 		if err != nil {
 			rw.WriteHeader(http.StatusInternalServerError)
 
@@ -97,28 +116,39 @@ func MakeReplicationWriter(client nomad.Job, logger hclog.Logger, stats metrics.
 			stats.Incr("replicationwriter.error.internalerror", nil, 1)
 		}
 
+		if resp != nil {
+			audit.SetNomadResult(r.Context(), resp.EvalID, resp.JobModifyIndex)
+		}
+
 		stats.Gauge("deploy.count", float64(req.Replicas), []string{"job:" + req.ServiceName}, 1)
 		stats.Incr("replicationwriter.success", nil, 1)
 	}
 }
 
-func getJob(client nomad.Job, r *http.Request) (*api.Job, error) {
+func getJob(client nomad.Job, r *http.Request) (*api.Job, *api.QueryMeta, error) {
 	functionName := r.Context().Value(FunctionNameCTXKey).(string)
 
-	job, _, err := client.Info(nomad.JobPrefix+functionName, nil)
+	job, meta, err := client.Info(nomad.JobPrefix+functionName, nil)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return job, nil
+	return job, meta, nil
 }
 
 func getAllocationReadyCount(client nomad.Job, job *api.Job, r *http.Request) (uint64, error) {
 	allocs, _, err := client.Allocations(*job.ID, true, nil)
 	var readyCount uint64
 
+	// the Connect sidecar is not a replica of the function
+	sidecar := nomad.ConnectProxyTaskName(job.TaskGroups[0].Tasks[0].Name)
+
 	for _, a := range allocs {
-		for _, ts := range a.TaskStates {
+		for name, ts := range a.TaskStates {
+			if name == sidecar {
+				continue
+			}
+
 			if ts.State == "running" {
 				readyCount += 1
 			}
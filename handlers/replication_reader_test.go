@@ -27,7 +27,7 @@ func setupReplicationReader(functionName string) (http.HandlerFunc, *httptest.Re
 
 	logger := hclog.Default()
 
-	h := MakeReplicationReader(mockJob, logger, mockStats)
+	h := MakeReplicationReader(mockJob, metrics.NewInvocations("test", nil, 0, logger), logger, mockStats)
 
 	return h, rr, r
 }
@@ -103,3 +103,23 @@ func TestReplicationWReturnsInternalServerErrorOnRegisterError(t *testing.T) {
 
 	assert.Equal(t, http.StatusInternalServerError, rr.Code)
 }
+
+func TestReplicationWDoesNotScaleDispatchedFunction(t *testing.T) {
+	count := 1
+	job := api.Job{
+		ParameterizedJob: &api.ParameterizedJobConfig{Payload: "optional"},
+		TaskGroups: []*api.TaskGroup{
+			&api.TaskGroup{Count: &count},
+		},
+	}
+
+	req := types.ScaleServiceRequest{Replicas: 0, ServiceName: "testFunc"}
+	h, rr, r := setupReplicationWriter(t, "testFunc", &req)
+
+	mockJob.On("Info", mock.Anything, mock.Anything).Return(&job, nil, nil)
+
+	h(rr, r)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	mockJob.AssertNotCalled(t, "Register", mock.Anything, mock.Anything)
+}
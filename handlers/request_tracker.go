@@ -0,0 +1,99 @@
+package handlers
+
+import "sync"
+
+// RequestTracker counts the in-flight requests for each function and allows
+// a function to be drained so that it accepts no new requests
+type RequestTracker struct {
+	mutex    sync.Mutex
+	inFlight map[string]int
+	draining map[string]chan struct{}
+}
+
+// NewRequestTracker creates a new RequestTracker
+func NewRequestTracker() *RequestTracker {
+	return &RequestTracker{
+		inFlight: map[string]int{},
+		draining: map[string]chan struct{}{},
+	}
+}
+
+// Start records a new request for the function, it returns false when the
+// function is being drained and the request must be rejected
+func (t *RequestTracker) Start(function string) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if _, ok := t.draining[function]; ok {
+		return false
+	}
+
+	t.inFlight[function]++
+	return true
+}
+
+// Done records the completion of a request started with Start
+func (t *RequestTracker) Done(function string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.inFlight[function]--
+	if t.inFlight[function] > 0 {
+		return
+	}
+
+	delete(t.inFlight, function)
+
+	if done, ok := t.draining[function]; ok {
+		close(done)
+	}
+}
+
+// Drain stops the function accepting new requests, the returned channel is
+// closed once all in-flight requests have completed
+func (t *RequestTracker) Drain(function string) <-chan struct{} {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if done, ok := t.draining[function]; ok {
+		return done
+	}
+
+	done := make(chan struct{})
+	t.draining[function] = done
+
+	if t.inFlight[function] == 0 {
+		close(done)
+	}
+
+	return done
+}
+
+// Resume allows a drained function to accept requests again
+func (t *RequestTracker) Resume(function string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	delete(t.draining, function)
+}
+
+// InFlight returns the number of requests currently being handled for a function
+func (t *RequestTracker) InFlight(function string) int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.inFlight[function]
+}
+
+// Total returns the number of requests currently being handled for all functions
+func (t *RequestTracker) Total() int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	total := 0
+	for _, n := range t.inFlight {
+		total += n
+	}
+
+	return total
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrackerRejectsRequestsWhenDraining(t *testing.T) {
+	tr := NewRequestTracker()
+
+	tr.Drain("test")
+
+	assert.False(t, tr.Start("test"))
+	assert.True(t, tr.Start("other"))
+}
+
+func TestTrackerDrainClosesWhenNoRequests(t *testing.T) {
+	tr := NewRequestTracker()
+
+	select {
+	case <-tr.Drain("test"):
+	default:
+		t.Fatal("Expected drain to complete")
+	}
+}
+
+func TestTrackerDrainClosesWhenRequestsComplete(t *testing.T) {
+	tr := NewRequestTracker()
+	tr.Start("test")
+	tr.Start("test")
+
+	done := tr.Drain("test")
+	tr.Done("test")
+
+	select {
+	case <-done:
+		t.Fatal("Expected drain to wait for in-flight requests")
+	default:
+	}
+
+	tr.Done("test")
+
+	select {
+	case <-done:
+	default:
+		t.Fatal("Expected drain to complete")
+	}
+}
+
+func TestTrackerResumeAcceptsRequests(t *testing.T) {
+	tr := NewRequestTracker()
+	tr.Drain("test")
+
+	tr.Resume("test")
+
+	assert.True(t, tr.Start("test"))
+	assert.Equal(t, 1, tr.InFlight("test"))
+}
+
+func TestTrackerTotalCountsAllFunctions(t *testing.T) {
+	tr := NewRequestTracker()
+	tr.Start("test")
+	tr.Start("test")
+	tr.Start("other")
+
+	tr.Done("test")
+
+	assert.Equal(t, 2, tr.Total())
+}
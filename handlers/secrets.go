@@ -5,19 +5,53 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/hashicorp/faas-nomad/vault"
+	"github.com/hashicorp/faas-nomad/nomad"
+	"github.com/hashicorp/faas-nomad/types"
 	hclog "github.com/hashicorp/go-hclog"
-	vapi "github.com/hashicorp/vault/api"
 	"github.com/openfaas/faas/gateway/requests"
 )
 
+// SecretRestartMeta is the task meta key changed to restart the functions
+// which use a secret when it is updated
+const SecretRestartMeta = "openfaas_secrets_updated"
+
+// SecretInfo is returned for each secret when listing secrets
+type SecretInfo struct {
+	Name string `json:"name"`
+	types.SecretMetadata
+	// UsedBy lists the functions which reference the secret
+	UsedBy []string `json:"usedBy"`
+}
+
+// SecretsConfig controls how secrets which are used by functions are updated
+// and deleted
+type SecretsConfig struct {
+	// RestartOnUpdate performs a rolling restart of the functions which use a
+	// secret when it is updated, it can be overridden with the restart query
+	// parameter
+	RestartOnUpdate bool
+}
+
+// SecretUpdateResponse is returned when updating a secret restarts functions
+type SecretUpdateResponse struct {
+	Name      string   `json:"name"`
+	Restarted []string `json:"restarted"`
+	Errors    []string `json:"errors,omitempty"`
+}
+
 type SecretsResponse struct {
 	StatusCode int
 	Body       []byte
 }
 
-func MakeSecretHandler(vs *vault.VaultService, log hclog.Logger) http.HandlerFunc {
+// MakeSecretHandler creates a handler which manages secrets in the secret store,
+// secrets are listed with the functions which use them and secrets which are
+// in use can only be deleted with the force query parameter
+func MakeSecretHandler(store types.SecretStore, jobs nomad.Job, config SecretsConfig, log hclog.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		if r.Body != nil {
@@ -38,21 +72,30 @@ func MakeSecretHandler(vs *vault.VaultService, log hclog.Logger) http.HandlerFun
 
 		switch r.Method {
 		case http.MethodGet:
-			response, responseErr = getSecrets(vs, body)
+			response, responseErr = getSecrets(store, jobs, body)
 			break
 		case http.MethodPost:
-			response, responseErr = createNewSecret(vs, http.MethodPost, body)
+			response, responseErr = createNewSecret(store, body)
 			break
 		case http.MethodPut:
-			response, responseErr = createNewSecret(vs, http.MethodPut, body)
+			response, responseErr = createNewSecret(store, body)
+			if responseErr == nil && queryBool(r, "restart", config.RestartOnUpdate) {
+				response = restartSecretUsers(jobs, body, log)
+			}
 			break
 		case http.MethodDelete:
-			response, responseErr = deleteSecret(vs, body)
+			response, responseErr = deleteSecret(store, jobs, queryBool(r, "force", false), body)
 			break
 		}
 
 		if responseErr != nil {
 			log.Error(responseErr.Error())
+
+			if response.StatusCode == http.StatusBadRequest || response.StatusCode == http.StatusConflict {
+				writeJSONError(w, response.StatusCode, responseErr)
+				return
+			}
+
 			w.WriteHeader(response.StatusCode)
 			return
 		}
@@ -72,35 +115,32 @@ func MakeSecretHandler(vs *vault.VaultService, log hclog.Logger) http.HandlerFun
 	}
 }
 
-func getSecrets(vs *vault.VaultService, body []byte) (resp SecretsResponse, err error) {
+func getSecrets(store types.SecretStore, jobs nomad.Job, body []byte) (resp SecretsResponse, err error) {
 
-	response, _ := vs.DoRequest("LIST",
-		fmt.Sprintf("/v1/%s", vs.Config.SecretPathPrefix), nil)
-
-	// If Vault finds nothing, return StatusOK according to gateway API docs
-	if response.StatusCode == http.StatusNotFound {
-		return SecretsResponse{
-			StatusCode: http.StatusOK,
-			Body:       []byte(`[]`),
-		}, nil
+	names, listErr := store.ListSecrets()
+	if listErr != nil {
+		return SecretsResponse{StatusCode: http.StatusInternalServerError}, listErr
 	}
 
-	var secretList vapi.Secret
-	secretsBody, bodyErr := ioutil.ReadAll(response.Body)
-	if bodyErr != nil {
-		return SecretsResponse{StatusCode: http.StatusInternalServerError},
-			fmt.Errorf("Error reading response body: %s", bodyErr)
+	usage, usageErr := nomad.SecretUsage(jobs)
+	if usageErr != nil {
+		return SecretsResponse{StatusCode: http.StatusInternalServerError}, fmt.Errorf("Error finding functions using secrets: %s", usageErr)
 	}
 
-	unmarshalErr := json.Unmarshal(secretsBody, &secretList)
-	if unmarshalErr != nil {
-		return SecretsResponse{StatusCode: http.StatusInternalServerError},
-			fmt.Errorf("Error in json deserialisation: %s", unmarshalErr)
-	}
+	// If the store finds nothing, return StatusOK with an empty list according to gateway API docs
+	secrets := []SecretInfo{}
+	for _, k := range names {
+		metadata, metadataErr := store.GetSecretMetadata(k)
+		if metadataErr != nil {
+			return SecretsResponse{StatusCode: http.StatusInternalServerError}, metadataErr
+		}
+
+		usedBy := usage[k]
+		if usedBy == nil {
+			usedBy = []string{}
+		}
 
-	secrets := []requests.Secret{}
-	for _, k := range secretList.Data["keys"].([]interface{}) {
-		secrets = append(secrets, requests.Secret{Name: k.(string)})
+		secrets = append(secrets, SecretInfo{Name: k, SecretMetadata: metadata, UsedBy: usedBy})
 	}
 
 	resultsJson, _ := json.Marshal(secrets)
@@ -108,7 +148,7 @@ func getSecrets(vs *vault.VaultService, body []byte) (resp SecretsResponse, err
 	return SecretsResponse{StatusCode: http.StatusOK, Body: resultsJson}, nil
 }
 
-func createNewSecret(vs *vault.VaultService, method string, body []byte) (resp SecretsResponse, err error) {
+func createNewSecret(store types.SecretStore, body []byte) (resp SecretsResponse, err error) {
 
 	var secret requests.Secret
 	unmarshalErr := json.Unmarshal(body, &secret)
@@ -116,23 +156,18 @@ func createNewSecret(vs *vault.VaultService, method string, body []byte) (resp S
 		return SecretsResponse{StatusCode: http.StatusBadRequest}, fmt.Errorf("Error in request json deserialisation: %s", unmarshalErr)
 	}
 
-	response, respErr := vs.DoRequest(method,
-		fmt.Sprintf("/v1/%s/%s", vs.Config.SecretPathPrefix, secret.Name),
-		map[string]interface{}{"value": secret.Value})
-
-	if respErr != nil {
-		return SecretsResponse{StatusCode: http.StatusInternalServerError}, fmt.Errorf("Error in request to Vault: %s", respErr)
+	if err := validateSecretName(secret.Name); err != nil {
+		return SecretsResponse{StatusCode: http.StatusBadRequest}, err
 	}
 
-	// Vault only returns 204 type success
-	if response.StatusCode != http.StatusNoContent {
-		return SecretsResponse{StatusCode: http.StatusInternalServerError}, fmt.Errorf("Vault returned unexpected response: %v", response.StatusCode)
+	if err := store.SetSecret(secret.Name, secret.Value); err != nil {
+		return SecretsResponse{StatusCode: http.StatusInternalServerError}, err
 	}
 
 	return SecretsResponse{StatusCode: http.StatusCreated}, nil
 }
 
-func deleteSecret(vs *vault.VaultService, body []byte) (resp SecretsResponse, err error) {
+func deleteSecret(store types.SecretStore, jobs nomad.Job, force bool, body []byte) (resp SecretsResponse, err error) {
 
 	var secret requests.Secret
 	unmarshalErr := json.Unmarshal(body, &secret)
@@ -140,15 +175,87 @@ func deleteSecret(vs *vault.VaultService, body []byte) (resp SecretsResponse, er
 		return SecretsResponse{StatusCode: http.StatusBadRequest}, fmt.Errorf("Error in request json deserialisation: %s", unmarshalErr)
 	}
 
-	response, respErr := vs.DoRequest(http.MethodDelete,
-		fmt.Sprintf("/v1/%s/%s", vs.Config.SecretPathPrefix, secret.Name), nil)
-	if respErr != nil {
-		return SecretsResponse{StatusCode: http.StatusInternalServerError}, fmt.Errorf("Error in request to Vault: %s", respErr)
+	if err := validateSecretName(secret.Name); err != nil {
+		return SecretsResponse{StatusCode: http.StatusBadRequest}, err
+	}
+
+	if !force {
+		usage, usageErr := nomad.SecretUsage(jobs)
+		if usageErr != nil {
+			return SecretsResponse{StatusCode: http.StatusInternalServerError}, fmt.Errorf("Error finding functions using secrets: %s", usageErr)
+		}
+
+		if functions := usage[secret.Name]; len(functions) > 0 {
+			return SecretsResponse{StatusCode: http.StatusConflict},
+				fmt.Errorf("Secret %q is used by functions %s, set force=true to delete it", secret.Name, strings.Join(functions, ", "))
+		}
 	}
 
-	if response.StatusCode != http.StatusNoContent {
-		return SecretsResponse{StatusCode: http.StatusInternalServerError}, fmt.Errorf("Vault returned unexpected response: %v", response.StatusCode)
+	if err := store.DeleteSecret(secret.Name); err != nil {
+		return SecretsResponse{StatusCode: http.StatusInternalServerError}, err
 	}
 
 	return SecretsResponse{StatusCode: http.StatusOK}, nil
 }
+
+// restartSecretUsers performs a rolling restart of the functions using the
+// secret in the request body by changing their task meta, Nomad replaces the
+// allocations according to each job's update strategy
+func restartSecretUsers(jobs nomad.Job, body []byte, log hclog.Logger) SecretsResponse {
+	var secret requests.Secret
+	json.Unmarshal(body, &secret)
+
+	resp := SecretUpdateResponse{Name: secret.Name, Restarted: []string{}}
+
+	usage, err := nomad.SecretUsage(jobs)
+	if err != nil {
+		log.Error("Error finding functions using secret", "secret", secret.Name, "error", err)
+		resp.Errors = append(resp.Errors, err.Error())
+	}
+
+	updated := time.Now().UTC().Format(time.RFC3339Nano)
+	for _, function := range usage[secret.Name] {
+		if err := restartFunction(jobs, function, updated); err != nil {
+			log.Error("Error restarting function", "function", function, "secret", secret.Name, "error", err)
+			resp.Errors = append(resp.Errors, err.Error())
+			continue
+		}
+
+		log.Info("Restarting function after secret update", "function", function, "secret", secret.Name)
+		resp.Restarted = append(resp.Restarted, function)
+	}
+
+	body, _ = json.Marshal(resp)
+	return SecretsResponse{StatusCode: http.StatusCreated, Body: body}
+}
+
+func restartFunction(jobs nomad.Job, function, updated string) error {
+	job, _, err := jobs.Info(nomad.JobPrefix+function, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, tg := range job.TaskGroups {
+		for _, t := range tg.Tasks {
+			if t.Meta == nil {
+				t.Meta = map[string]string{}
+			}
+
+			t.Meta[SecretRestartMeta] = updated
+		}
+	}
+
+	_, _, err = jobs.Register(job, nil)
+	return err
+}
+
+// queryBool returns the value of a boolean query parameter, def is returned
+// when the parameter is missing or invalid
+func queryBool(r *http.Request, name string, def bool) bool {
+	v, err := strconv.ParseBool(r.URL.Query().Get(name))
+	if err != nil {
+		return def
+	}
+
+	return v
+}
@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/faas-nomad/nomad"
+	"github.com/hashicorp/faas-nomad/types"
+	"github.com/hashicorp/faas-nomad/vault"
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var secretsJob *nomad.MockJob
+
+func setupSecrets(method, url, body string) (http.HandlerFunc, *httptest.ResponseRecorder, *http.Request, *[]string, *httptest.Server) {
+	paths := &[]string{}
+	vaultServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		*paths = append(*paths, r.Method+" "+r.URL.Path)
+
+		if r.Method == "LIST" {
+			rw.Write([]byte(`{"data":{"keys":["figlet","unused"]}}`))
+			return
+		}
+
+		rw.WriteHeader(http.StatusNoContent)
+	}))
+
+	config := &types.VaultConfig{Addr: vaultServer.URL, SecretPathPrefix: "secret/openfaas", KVVersion: 1}
+	vs := vault.NewVaultService(config, hclog.Default())
+
+	secretsJob = &nomad.MockJob{}
+	secretsJob.On("List", mock.Anything).Return([]*api.JobListStub{
+		&api.JobListStub{ID: nomad.JobPrefix + "figlet-fn", Status: "running"},
+	}, nil, nil)
+	secretsJob.On("Info", nomad.JobPrefix+"figlet-fn", mock.Anything).Return(&api.Job{
+		TaskGroups: []*api.TaskGroup{&api.TaskGroup{
+			Tasks: []*api.Task{&api.Task{
+				Config: map[string]interface{}{
+					"volumes": []interface{}{"secrets/figlet:/var/openfaas/secrets/figlet"},
+				},
+			}},
+		}},
+	}, nil, nil)
+	secretsJob.On("Register", mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	return MakeSecretHandler(vs, secretsJob, SecretsConfig{}, hclog.Default()),
+		httptest.NewRecorder(),
+		httptest.NewRequest(method, url, bytes.NewReader([]byte(body))),
+		paths,
+		vaultServer
+}
+
+func TestSecretHandlerCreatesSecret(t *testing.T) {
+	h, rw, r, paths, vaultServer := setupSecrets(http.MethodPost, "/system/secrets", `{"name":"figlet","value":"abc"}`)
+	defer vaultServer.Close()
+
+	h(rw, r)
+
+	assert.Equal(t, http.StatusCreated, rw.Code)
+	assert.Equal(t, []string{"POST /v1/secret/openfaas/figlet"}, *paths)
+}
+
+func TestSecretHandlerRejectsPathTraversalOnCreate(t *testing.T) {
+	h, rw, r, paths, vaultServer := setupSecrets(http.MethodPost, "/system/secrets", `{"name":"../../sys/policy/x","value":"abc"}`)
+	defer vaultServer.Close()
+
+	h(rw, r)
+
+	resp := ErrorResponse{}
+	json.NewDecoder(rw.Body).Decode(&resp)
+
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+	assert.Equal(t, "application/json", rw.Header().Get("Content-Type"))
+	assert.Equal(t, "secret", resp.Detail.Field)
+	assert.Equal(t, "../../sys/policy/x", resp.Detail.Value)
+	assert.Empty(t, *paths)
+}
+
+func TestSecretHandlerRejectsPathTraversalOnUpdate(t *testing.T) {
+	h, rw, r, paths, vaultServer := setupSecrets(http.MethodPut, "/system/secrets", `{"name":"foo/../../bar","value":"abc"}`)
+	defer vaultServer.Close()
+
+	h(rw, r)
+
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+	assert.Empty(t, *paths)
+}
+
+func TestSecretHandlerRejectsPathTraversalOnDelete(t *testing.T) {
+	h, rw, r, paths, vaultServer := setupSecrets(http.MethodDelete, "/system/secrets", `{"name":".."}`)
+	defer vaultServer.Close()
+
+	h(rw, r)
+
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+	assert.Empty(t, *paths)
+}
+
+func TestSecretHandlerListsFunctionsUsingSecrets(t *testing.T) {
+	h, rw, r, _, vaultServer := setupSecrets(http.MethodGet, "/system/secrets", "")
+	defer vaultServer.Close()
+
+	h(rw, r)
+
+	secrets := []SecretInfo{}
+	json.NewDecoder(rw.Body).Decode(&secrets)
+
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, "figlet", secrets[0].Name)
+	assert.Equal(t, []string{"figlet-fn"}, secrets[0].UsedBy)
+	assert.Equal(t, []string{}, secrets[1].UsedBy)
+}
+
+func TestSecretHandlerRefusesToDeleteSecretInUse(t *testing.T) {
+	h, rw, r, paths, vaultServer := setupSecrets(http.MethodDelete, "/system/secrets", `{"name":"figlet"}`)
+	defer vaultServer.Close()
+
+	h(rw, r)
+
+	resp := ErrorResponse{}
+	json.NewDecoder(rw.Body).Decode(&resp)
+
+	assert.Equal(t, http.StatusConflict, rw.Code)
+	assert.Contains(t, resp.Error, "figlet-fn")
+	assert.Empty(t, *paths)
+}
+
+func TestSecretHandlerRefusesToDeleteSecretOfFunctionScaledToZero(t *testing.T) {
+	h, rw, r, paths, vaultServer := setupSecrets(http.MethodDelete, "/system/secrets", `{"name":"figlet"}`)
+	defer vaultServer.Close()
+
+	secretsJob.ExpectedCalls = secretsJob.ExpectedCalls[1:]
+	secretsJob.On("List", mock.Anything).Return([]*api.JobListStub{
+		&api.JobListStub{ID: nomad.JobPrefix + "figlet-fn", Status: "dead"},
+	}, nil, nil)
+
+	h(rw, r)
+
+	assert.Equal(t, http.StatusConflict, rw.Code)
+	assert.Empty(t, *paths)
+}
+
+func TestSecretHandlerDeletesSecretOfStoppedFunction(t *testing.T) {
+	h, rw, r, paths, vaultServer := setupSecrets(http.MethodDelete, "/system/secrets", `{"name":"figlet"}`)
+	defer vaultServer.Close()
+
+	secretsJob.ExpectedCalls = secretsJob.ExpectedCalls[1:]
+	secretsJob.On("List", mock.Anything).Return([]*api.JobListStub{
+		&api.JobListStub{ID: nomad.JobPrefix + "figlet-fn", Status: "dead", Stop: true},
+	}, nil, nil)
+
+	h(rw, r)
+
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, []string{"DELETE /v1/secret/openfaas/figlet"}, *paths)
+}
+
+func TestSecretHandlerDeletesSecretInUseWithForce(t *testing.T) {
+	h, rw, r, paths, vaultServer := setupSecrets(http.MethodDelete, "/system/secrets?force=true", `{"name":"figlet"}`)
+	defer vaultServer.Close()
+
+	h(rw, r)
+
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, []string{"DELETE /v1/secret/openfaas/figlet"}, *paths)
+}
+
+func TestSecretHandlerDeletesUnusedSecret(t *testing.T) {
+	h, rw, r, paths, vaultServer := setupSecrets(http.MethodDelete, "/system/secrets", `{"name":"unused"}`)
+	defer vaultServer.Close()
+
+	h(rw, r)
+
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, []string{"DELETE /v1/secret/openfaas/unused"}, *paths)
+}
+
+func TestSecretHandlerRestartsFunctionsOnUpdate(t *testing.T) {
+	h, rw, r, _, vaultServer := setupSecrets(http.MethodPut, "/system/secrets?restart=true", `{"name":"figlet","value":"new"}`)
+	defer vaultServer.Close()
+
+	h(rw, r)
+
+	resp := SecretUpdateResponse{}
+	json.NewDecoder(rw.Body).Decode(&resp)
+
+	assert.Equal(t, http.StatusCreated, rw.Code)
+	assert.Equal(t, []string{"figlet-fn"}, resp.Restarted)
+
+	job := secretsJob.Calls[len(secretsJob.Calls)-1].Arguments.Get(0).(*api.Job)
+	assert.NotEmpty(t, job.TaskGroups[0].Tasks[0].Meta[SecretRestartMeta])
+}
+
+func TestSecretHandlerDoesNotRestartFunctionsByDefault(t *testing.T) {
+	h, rw, r, _, vaultServer := setupSecrets(http.MethodPut, "/system/secrets", `{"name":"figlet","value":"new"}`)
+	defer vaultServer.Close()
+
+	h(rw, r)
+
+	assert.Equal(t, http.StatusCreated, rw.Code)
+	secretsJob.AssertNotCalled(t, "Register", mock.Anything, mock.Anything)
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+const maxSecretNameLength = 128
+
+var secretNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+
+// ValidationError is returned when a request contains an invalid value
+type ValidationError struct {
+	Field   string `json:"field"`
+	Value   string `json:"value"`
+	Message string `json:"message"`
+}
+
+func (v *ValidationError) Error() string {
+	return fmt.Sprintf("Invalid %s %q: %s", v.Field, v.Value, v.Message)
+}
+
+// ErrorResponse is the JSON body returned for requests which fail validation
+type ErrorResponse struct {
+	Status int              `json:"status"`
+	Error  string           `json:"error"`
+	Detail *ValidationError `json:"detail,omitempty"`
+}
+
+// validateSecretName ensures that a secret name can not be used to reach a
+// path outside of the secret path prefix
+func validateSecretName(name string) error {
+	invalid := func(message string) error {
+		return &ValidationError{Field: "secret", Value: name, Message: message}
+	}
+
+	switch {
+	case name == "":
+		return invalid("name must not be empty")
+	case len(name) > maxSecretNameLength:
+		return invalid(fmt.Sprintf("name must be %d characters or less", maxSecretNameLength))
+	case strings.ContainsAny(name, `/\`):
+		return invalid("name must not contain path separators")
+	case strings.Contains(name, ".."):
+		return invalid("name must not contain '..'")
+	case !secretNamePattern.MatchString(name):
+		return invalid("name must start with a letter or number and contain only letters, numbers, '.', '_' and '-'")
+	}
+
+	return nil
+}
+
+func validateSecretNames(names []string) error {
+	for _, n := range names {
+		if err := validateSecretName(n); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parseRegistryAuth decodes a base64 encoded username:password registry
+// credential, the value is redacted from the error as it contains a password
+func parseRegistryAuth(auth string) (string, string, error) {
+	invalid := func(message string) error {
+		return &ValidationError{Field: "registryAuth", Value: "<redacted>", Message: message}
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(auth)
+	if err != nil {
+		return "", "", invalid("must be base64 encoded")
+	}
+
+	parts := strings.SplitN(string(decoded), ":", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return "", "", invalid("must be a base64 encoded username:password")
+	}
+
+	return parts[0], parts[1], nil
+}
+
+// marshalError creates a structured error body, validation errors include the
+// invalid field and value
+func marshalError(status int, err error) []byte {
+	resp := ErrorResponse{Status: status, Error: err.Error()}
+	if v, ok := err.(*ValidationError); ok {
+		resp.Detail = v
+	}
+
+	body, _ := json.Marshal(resp)
+	return body
+}
+
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(marshalError(status, err))
+}
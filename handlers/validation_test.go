@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateSecretNameAcceptsValidNames(t *testing.T) {
+	for _, n := range []string{"figlet", "db_password", "api-key.v2", "A1"} {
+		assert.Nil(t, validateSecretName(n), n)
+	}
+}
+
+func TestValidateSecretNameRejectsPathTraversal(t *testing.T) {
+	names := []string{
+		"../../sys/policy/x",
+		"..",
+		"foo/../bar",
+		"foo/bar",
+		"/etc/passwd",
+		`..\\sys`,
+		"foo..bar",
+		".hidden",
+	}
+
+	for _, n := range names {
+		err := validateSecretName(n)
+
+		assert.NotNil(t, err, n)
+		assert.IsType(t, &ValidationError{}, err)
+	}
+}
+
+func TestValidateSecretNameRejectsInvalidCharacters(t *testing.T) {
+	for _, n := range []string{"", "with space", "quote\"", "{{template}}", "new\nline", "percent%2F"} {
+		assert.NotNil(t, validateSecretName(n), n)
+	}
+}
+
+func TestValidateSecretNameRejectsLongNames(t *testing.T) {
+	assert.NotNil(t, validateSecretName(strings.Repeat("a", maxSecretNameLength+1)))
+	assert.Nil(t, validateSecretName(strings.Repeat("a", maxSecretNameLength)))
+}
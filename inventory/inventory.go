@@ -0,0 +1,239 @@
+package inventory
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/faas-nomad/metrics"
+	"github.com/hashicorp/faas-nomad/nomad"
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
+)
+
+// waitTime is the maximum time a blocking query waits for the function jobs
+// to change
+var waitTime = 5 * time.Minute
+
+// retryInterval is the delay before the watch is restarted after an error
+var retryInterval = 5 * time.Second
+
+type entry struct {
+	stub *api.JobListStub
+	// job is nil for the runs of scheduled and dispatched functions
+	job *api.Job
+}
+
+// Inventory is an in-memory copy of the function jobs which is kept up to
+// date with Nomad blocking queries.
+//
+// It implements nomad.Job, List and Info are served from memory while the
+// watch is in sync and every other call is sent to Nomad. When the watch
+// fails reads are sent to Nomad until it recovers. Cached jobs are shared by
+// all readers and must not be modified.
+type Inventory struct {
+	nomad.Job
+
+	logger hclog.Logger
+	stats  metrics.StatsD
+
+	mutex    sync.RWMutex
+	jobs     map[string]*entry
+	index    uint64
+	synced   bool
+	lastSync time.Time
+	stop     chan struct{}
+}
+
+// New creates an Inventory of the function jobs returned by the client
+func New(client nomad.Job, logger hclog.Logger, stats metrics.StatsD) *Inventory {
+	return &Inventory{
+		Job:    client,
+		logger: logger.Named("inventory"),
+		stats:  stats,
+		jobs:   map[string]*entry{},
+		stop:   make(chan struct{}),
+	}
+}
+
+// Start watches the function jobs until Stop is called
+func (i *Inventory) Start() {
+	go func() {
+		for {
+			select {
+			case <-i.stop:
+				return
+			default:
+			}
+
+			i.mutex.RLock()
+			index := i.index
+			i.mutex.RUnlock()
+
+			if err := i.refresh(index); err != nil {
+				i.logger.Warn("Function inventory is out of sync, reading from Nomad", "error", err)
+				i.stats.Incr("inventory.error.watch", nil, 1)
+
+				select {
+				case <-time.After(retryInterval):
+				case <-i.stop:
+					return
+				}
+			}
+		}
+	}()
+}
+
+// Stop ends the watch, a blocking query which is in progress is abandoned
+func (i *Inventory) Stop() {
+	close(i.stop)
+}
+
+// Sync reads every function job from Nomad without blocking
+func (i *Inventory) Sync() error {
+	return i.refresh(0)
+}
+
+// Synced returns true when reads are served from memory and the time of the
+// last successful query
+func (i *Inventory) Synced() (bool, time.Time) {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	return i.synced, i.lastSync
+}
+
+// List returns the function jobs with the query prefix, the LastContact of
+// the query meta is the time since the inventory was last in contact with
+// Nomad. Blocking queries and queries outside the function prefix are sent
+// to Nomad.
+func (i *Inventory) List(q *api.QueryOptions) ([]*api.JobListStub, *api.QueryMeta, error) {
+	if q == nil || q.WaitIndex > 0 || !strings.HasPrefix(q.Prefix, nomad.JobPrefix) {
+		return i.Job.List(q)
+	}
+
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	if !i.synced {
+		return i.Job.List(q)
+	}
+
+	stubs := []*api.JobListStub{}
+	for id, e := range i.jobs {
+		if strings.HasPrefix(id, q.Prefix) {
+			stubs = append(stubs, e.stub)
+		}
+	}
+
+	// Nomad returns jobs in ID order
+	sort.Slice(stubs, func(a, b int) bool { return stubs[a].ID < stubs[b].ID })
+
+	return stubs, i.queryMeta(), nil
+}
+
+// Info returns a function job from memory, jobs which are not in the
+// inventory are read from Nomad
+func (i *Inventory) Info(jobID string, q *api.QueryOptions) (*api.Job, *api.QueryMeta, error) {
+	i.mutex.RLock()
+	e, ok := i.jobs[jobID]
+	synced := i.synced
+	meta := i.queryMeta()
+	i.mutex.RUnlock()
+
+	if !synced || !ok || e.job == nil || (q != nil && q.WaitIndex > 0) {
+		return i.Job.Info(jobID, q)
+	}
+
+	return e.job, meta, nil
+}
+
+func (i *Inventory) queryMeta() *api.QueryMeta {
+	return &api.QueryMeta{
+		LastIndex:   i.index,
+		LastContact: time.Since(i.lastSync),
+		KnownLeader: true,
+	}
+}
+
+// refresh lists the function jobs, blocking until they change when index is
+// set, and reads the jobs which have been modified
+func (i *Inventory) refresh(index uint64) error {
+	q := &api.QueryOptions{Prefix: nomad.JobPrefix}
+	if index > 0 {
+		q.WaitIndex = index
+		q.WaitTime = waitTime
+	}
+
+	stubs, meta, err := i.Job.List(q)
+	if err != nil {
+		i.outOfSync()
+		return err
+	}
+
+	var lastIndex uint64
+	if meta != nil {
+		lastIndex = meta.LastIndex
+	}
+
+	if index > 0 && lastIndex == index {
+		// the wait timed out without a change
+		i.mutex.Lock()
+		i.lastSync = time.Now()
+		i.mutex.Unlock()
+
+		return nil
+	}
+
+	i.mutex.RLock()
+	current := i.jobs
+	i.mutex.RUnlock()
+
+	jobs := map[string]*entry{}
+	for _, s := range stubs {
+		if nomad.IsChildJob(s) {
+			jobs[s.ID] = &entry{stub: s}
+			continue
+		}
+
+		if e, ok := current[s.ID]; ok && e.job != nil && e.stub.ModifyIndex == s.ModifyIndex {
+			jobs[s.ID] = &entry{stub: s, job: e.job}
+			continue
+		}
+
+		job, _, err := i.Job.Info(s.ID, nil)
+		if err != nil {
+			i.outOfSync()
+			return err
+		}
+
+		jobs[s.ID] = &entry{stub: s, job: job}
+	}
+
+	i.mutex.Lock()
+	i.jobs = jobs
+	// the index can go backwards when the Nomad servers are restored, the
+	// next query starts again without blocking
+	if lastIndex < index {
+		lastIndex = 0
+	}
+	i.index = lastIndex
+	i.synced = true
+	i.lastSync = time.Now()
+	i.mutex.Unlock()
+
+	i.stats.Gauge("inventory.jobs", float64(len(jobs)), nil, 1)
+
+	return nil
+}
+
+// outOfSync sends reads to Nomad until the next successful refresh, which
+// reads every job again
+func (i *Inventory) outOfSync() {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	i.synced = false
+	i.index = 0
+}
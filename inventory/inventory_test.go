@@ -0,0 +1,114 @@
+package inventory
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/faas-nomad/metrics"
+	"github.com/hashicorp/faas-nomad/nomad"
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var functionQuery = &api.QueryOptions{Prefix: nomad.JobPrefix}
+
+func setupInventory(stubs []*api.JobListStub) (*Inventory, *nomad.MockJob) {
+	mockJob := &nomad.MockJob{}
+	mockJob.On("List", mock.Anything).Return(stubs, &api.QueryMeta{LastIndex: 10}, nil)
+
+	for _, s := range stubs {
+		id := s.ID
+		mockJob.On("Info", id, mock.Anything).Return(&api.Job{ID: &id}, nil, nil)
+	}
+
+	mockStats := &metrics.MockStatsD{}
+	mockStats.On("Incr", mock.Anything, mock.Anything, mock.Anything)
+	mockStats.On("Gauge", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+
+	return New(mockJob, hclog.Default(), mockStats), mockJob
+}
+
+func stub(id string, modifyIndex uint64) *api.JobListStub {
+	return &api.JobListStub{ID: nomad.JobPrefix + id, Status: "running", ModifyIndex: modifyIndex}
+}
+
+func TestInventoryServesReadsFromMemoryWhenSynced(t *testing.T) {
+	run := stub("report/dispatch-1-a", 6)
+	run.ParentID = nomad.JobPrefix + "report"
+
+	inv, mockJob := setupInventory([]*api.JobListStub{stub("echo", 5), run})
+
+	assert.Nil(t, inv.Sync())
+
+	stubs, meta, err := inv.List(functionQuery)
+	assert.Nil(t, err)
+	assert.Len(t, stubs, 2)
+	assert.Equal(t, uint64(10), meta.LastIndex)
+
+	job, _, err := inv.Info(nomad.JobPrefix+"echo", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, nomad.JobPrefix+"echo", *job.ID)
+
+	// one list and one info for the function, runs are not read
+	mockJob.AssertNumberOfCalls(t, "List", 1)
+	mockJob.AssertNumberOfCalls(t, "Info", 1)
+}
+
+func TestInventoryOnlyReadsModifiedJobs(t *testing.T) {
+	inv, mockJob := setupInventory([]*api.JobListStub{stub("echo", 5), stub("figlet", 5)})
+	inv.Sync()
+
+	changed := []*api.JobListStub{stub("echo", 5), stub("figlet", 8)}
+	mockJob.ExpectedCalls = nil
+	mockJob.On("List", mock.Anything).Return(changed, &api.QueryMeta{LastIndex: 11}, nil)
+	mockJob.On("Info", mock.Anything, mock.Anything).Return(&api.Job{}, nil, nil)
+
+	assert.Nil(t, inv.refresh(10))
+
+	mockJob.AssertNumberOfCalls(t, "Info", 3)
+	mockJob.AssertCalled(t, "Info", nomad.JobPrefix+"figlet", mock.Anything)
+}
+
+func TestInventoryRemovesDeletedJobs(t *testing.T) {
+	inv, mockJob := setupInventory([]*api.JobListStub{stub("echo", 5), stub("figlet", 5)})
+	inv.Sync()
+
+	mockJob.ExpectedCalls = nil
+	mockJob.On("List", mock.Anything).Return([]*api.JobListStub{stub("echo", 5)}, &api.QueryMeta{LastIndex: 11}, nil)
+
+	assert.Nil(t, inv.refresh(10))
+
+	stubs, _, _ := inv.List(functionQuery)
+	assert.Len(t, stubs, 1)
+}
+
+func TestInventoryReadsFromNomadWhenOutOfSync(t *testing.T) {
+	inv, mockJob := setupInventory([]*api.JobListStub{stub("echo", 5)})
+	inv.Sync()
+
+	mockJob.ExpectedCalls = nil
+	mockJob.On("List", mock.Anything).Return(nil, nil, fmt.Errorf("connection refused")).Once()
+
+	assert.NotNil(t, inv.refresh(10))
+
+	synced, _ := inv.Synced()
+	assert.False(t, synced)
+
+	mockJob.On("List", functionQuery).Return([]*api.JobListStub{}, nil, nil)
+	stubs, _, err := inv.List(functionQuery)
+
+	assert.Nil(t, err)
+	assert.Len(t, stubs, 0)
+}
+
+func TestInventorySendsOtherQueriesToNomad(t *testing.T) {
+	inv, mockJob := setupInventory([]*api.JobListStub{stub("echo", 5)})
+	inv.Sync()
+
+	q := &api.QueryOptions{Prefix: "other"}
+	inv.List(q)
+
+	mockJob.AssertCalled(t, "List", q)
+}
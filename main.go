@@ -1,30 +1,43 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/DataDog/datadog-go/statsd"
 	"github.com/gorilla/mux"
+	consulapi "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/faas-nomad/audit"
+	fnauth "github.com/hashicorp/faas-nomad/auth"
+	"github.com/hashicorp/faas-nomad/config"
 	"github.com/hashicorp/faas-nomad/consul"
 	"github.com/hashicorp/faas-nomad/handlers"
+	"github.com/hashicorp/faas-nomad/inventory"
 	"github.com/hashicorp/faas-nomad/metrics"
 	"github.com/hashicorp/faas-nomad/nomad"
+	"github.com/hashicorp/faas-nomad/reconciler"
+	"github.com/hashicorp/faas-nomad/server"
 	fntypes "github.com/hashicorp/faas-nomad/types"
 	"github.com/hashicorp/faas-nomad/vault"
 	hclog "github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/nomad/api"
 	"github.com/mitchellh/mapstructure"
 	bootstrap "github.com/openfaas/faas-provider"
+	"github.com/openfaas/faas-provider/auth"
 	"github.com/openfaas/faas-provider/types"
 )
 
 var version = "notset"
+var commit = ""
 
 var (
 	port                  = flag.Int("port", 8080, "Port to bind the server to")
@@ -43,13 +56,61 @@ var (
 	nomadRegion           = flag.String("nomad_region", "global", "Default region to schedule functions in")
 	enableBasicAuth       = flag.Bool("enable_basic_auth", false, "Flag for enabling basic authentication on gateway endpoints")
 	basicAuthSecretPath   = flag.String("basic_auth_secret_path", "/secrets", "The directory path to the basic auth secret file")
+	tlsCertFile           = flag.String("tls_cert_file", "", "Certificate file used to serve the provider API over TLS, the file is reloaded when it changes")
+	tlsKeyFile            = flag.String("tls_key_file", "", "Private key file for the tls_cert_file certificate")
+	tlsClientCAFile       = flag.String("tls_client_ca_file", "", "CA certificate used to verify client certificates, when set all endpoints except /healthz require a client certificate")
+	jwtJWKSFile           = flag.String("jwt_jwks_file", "", "JWKS file containing the keys used to verify bearer tokens, enables JWT authentication")
+	jwtIssuer             = flag.String("jwt_issuer", "", "Issuer of bearer tokens, keys are fetched from the issuer discovery document when jwt_jwks_file is not set, enables JWT authentication")
+	jwtAudience           = flag.String("jwt_audience", "", "Audience which must be present in bearer tokens")
+	jwtProtectInvoke      = flag.Bool("jwt_protect_invoke", false, "Require a bearer token to invoke functions as well as for the system endpoints")
+	auditLogFile          = flag.String("audit_log_file", "", "File the audit log of control-plane operations is written to as JSON lines")
+	auditLogMaxSize       = flag.Int64("audit_log_max_size", 100, "Size in megabytes at which the audit log file is rotated, 0 disables rotation")
+	auditLogMaxBackups    = flag.Int("audit_log_max_backups", 5, "Number of rotated audit log files to keep")
+	auditWebhookURL       = flag.String("audit_webhook_url", "", "URL audit events are posted to as JSON")
+	policyFile            = flag.String("policy_file", "", "HCL or JSON policy file mapping basic auth users and token claims to the actions they can perform, all authenticated callers can perform every action when omitted")
 	vaultAddrOverride     = flag.String("vault_addr", "", "Vault address override. Default Vault address is returned from the Nomad agent")
 	vaultTLSSkipVerify    = flag.Bool("vault_tls_skip_verify", false, "Skips TLS verification for calls to Vault. Not recommend for production")
 	vaultDefaultPolicy    = flag.String("vault_default_policy", "openfaas", "The default policy used when secrets are deployed with a function")
 	vaultSecretPathPrefix = flag.String("vault_secret_path_prefix", "secret/openfaas", "The Vault k/v path prefix used when secrets are deployed with a function")
 	vaultAppRoleID        = flag.String("vault_app_role_id", "", "A valid Vault AppRole role_id")
 	vaultAppRoleSecretID  = flag.String("vault_app_secret_id", "", "A valid Vault AppRole secret_id derived from the role")
+	vaultAuthMethod       = flag.String("vault_auth_method", "approle", "Method used to login to Vault, approle | token | jwt | nomad")
+	vaultAuthMount        = flag.String("vault_auth_mount", "", "Path the Vault auth method is mounted at, defaults to approle, jwt or jwt-nomad")
+	vaultAppSecretIDFile  = flag.String("vault_app_secret_id_file", "", "File containing the AppRole secret_id, used instead of -vault_app_secret_id")
+	vaultTokenFile        = flag.String("vault_token_file", "", "File containing a Vault token, used by the token auth method")
+	vaultJWTRole          = flag.String("vault_jwt_role", "", "Vault role used by the jwt and nomad auth methods")
+	vaultJWTFile          = flag.String("vault_jwt_file", "", "File containing the JWT used by the jwt auth method, the nomad auth method defaults to the workload identity in ${NOMAD_SECRETS_DIR}/nomad_token")
+	secretChangeMode      = flag.String("secret_change_mode", "restart", "Default action taken when a function secret changes, restart | signal | noop")
+	secretChangeSignal    = flag.String("secret_change_signal", "SIGHUP", "Default signal sent to a function when a secret changes and the change mode is signal")
+	secretRestartOnUpdate = flag.Bool("secret_restart_on_update", false, "Perform a rolling restart of the functions which use a secret when it is updated, can be overridden with the restart query parameter")
+	secretStore           = flag.String("secret_store", "vault", "Backend used to store function secrets, vault | nomad | consul")
+	secretStorePrefix     = flag.String("secret_store_prefix", "openfaas/secrets", "The Nomad Variables path or Consul k/v prefix used when secrets are stored in nomad or consul")
+	vaultKVVersion        = flag.Int("vault_kv_version", 0, "Version of the Vault k/v secrets engine mounted at the secret path prefix, 1 or 2. When omitted the version is detected from Vault")
 	cpuArchConstraint     = flag.String("cpu_arch_constraint", "amd64", "CPU architecture to constraint deployed functions to")
+	reconcileInterval     = flag.Duration("reconcile_interval", 10*time.Minute, "Interval at which orphaned function jobs and cache entries are removed, 0 disables the reconciler")
+	reconcileThreshold    = flag.Duration("reconcile_threshold", time.Hour, "Minimum time a stopped or failed function job has been dead before it is purged by the reconciler")
+	deleteDrainTimeout    = flag.Duration("delete_drain_timeout", 10*time.Second, "Maximum time to wait for in-flight requests to complete when a function is deleted")
+	deleteWaitForStop     = flag.Bool("delete_wait_for_stop", false, "Wait for all of a function's allocations to stop before a delete request returns")
+	deleteStopTimeout     = flag.Duration("delete_stop_timeout", 15*time.Second, "Maximum time to wait for a deleted function's allocations to stop")
+	shutdownTimeout       = flag.Duration("shutdown_timeout", 30*time.Second, "Maximum time to wait for in-flight requests to complete when the provider receives SIGINT or SIGTERM")
+	jobTemplateFile       = flag.String("job_template_file", "", "HCL or JSON job template which is merged into every generated function job")
+	jobTemplateDir        = flag.String("job_template_dir", "", "Directory of HCL or JSON job templates which functions select by name with the com.hashicorp.nomad.job_template annotation")
+	enableConnect         = flag.Bool("enable_connect", false, "Allow functions to be deployed with a Consul Connect sidecar using the com.hashicorp.nomad.connect annotation, the provider calls them over mTLS")
+	connectServiceName    = flag.String("connect_service_name", "faas-nomad", "Consul Connect service name of the provider, intentions must allow it to connect to functions")
+	connectSidecarImage   = flag.String("connect_sidecar_image", "", "Envoy image of the Consul Connect sidecar, Nomad's default image is used when empty")
+	volumeFile            = flag.String("volume_file", "", "HCL or JSON allowlist of host and CSI volumes which functions can mount with the com.hashicorp.nomad.volumes annotation")
+	defaultMemory         = flag.Int("default_memory", 128, "Memory in MB allocated to functions which do not set a memory limit")
+	defaultCPU            = flag.Int("default_cpu", 100, "CPU in MHz allocated to functions which do not set a CPU limit")
+	configFile            = flag.String("config", "", "HCL or JSON configuration file, keys are flag names and blocks prefix the keys they contain e.g. nomad { addr = \"\" }")
+	configWatchInterval   = flag.Duration("config_watch_interval", 10*time.Second, "Interval at which the configuration file is checked for changes, 0 disables watching")
+	versionRefresh        = flag.Duration("backend_version_refresh", 5*time.Minute, "Interval at which the Nomad, Consul and Vault versions reported by /system/info are refreshed")
+	enableInventory       = flag.Bool("enable_function_inventory", true, "Serve function reads from an in-memory inventory which is kept up to date with Nomad blocking queries, reads go to Nomad when it is out of sync")
+	invocationStore       = flag.String("invocation_store", "memory", "Backend the function invocation counts are saved to so that they survive restarts and are summed across provider instances, memory | consul | file")
+	invocationStorePrefix = flag.String("invocation_store_prefix", "openfaas/invocations", "The Consul k/v prefix invocation counts are saved under when the invocation store is consul")
+	invocationFile        = flag.String("invocation_file", "invocations.json", "File invocation counts are saved to when the invocation store is file")
+	invocationSync        = flag.Duration("invocation_sync_interval", 30*time.Second, "Interval at which invocation counts are saved and the counts of other provider instances are read")
+	instanceID            = flag.String("instance_id", "", "Unique ID of this provider instance used to save its invocation counts, defaults to NOMAD_ALLOC_NAME and must be set with the consul and file invocation stores when it is not available")
+	instanceTTL           = flag.Duration("invocation_instance_ttl", time.Hour, "Instances which have not saved their invocation counts for this long are removed from the invocation store and their counts are added to the instance which removes them, 0 disables removal")
 )
 
 var functionTimeout = flag.Duration("function_timeout", 30*time.Second, "Timeout for function execution")
@@ -60,9 +121,41 @@ var (
 	loggerOutput = flag.String("logger_output", "", "Filepath to write log file, if omitted stdOut is used")
 )
 
+// reloadableFlags are the settings which are applied without restarting the
+// provider when the configuration is reloaded
+var reloadableFlags = map[string]bool{
+	"logger_level":      true,
+	"nomad_acl":         true,
+	"consul_acl":        true,
+	"default_memory":    true,
+	"default_cpu":       true,
+	"policy_file":       true,
+	"volume_file":       true,
+	"job_template_file": true,
+	"job_template_dir":  true,
+}
+
 func main() {
 	flag.Parse()
 
+	if *configFile == "" {
+		*configFile = os.Getenv(config.EnvPrefix + "CONFIG")
+	}
+
+	loader := config.NewLoader(flag.CommandLine, *configFile)
+	values, err := loader.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if _, err := loader.Apply(values, nil, nil); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := validateFlags(); err != nil {
+		log.Fatal(err)
+	}
+
 	nomadConfig := &fntypes.NomadConfig{
 		TLSEnabled:    *enableNomadTLS,
 		Address:       *nomadAddr,
@@ -84,7 +177,29 @@ func main() {
 	logger.Info("Started version: " + version)
 	stats.Incr("started", nil, 1)
 
-	handlers := createFaaSHandlers(nomadClient, consulResolver, stats, logger)
+	// stopped last so that metrics recorded during shutdown are sent
+	stop := &shutdownFuncs{}
+	stop.add(func() {
+		stats.Flush()
+		stats.Close()
+	})
+	stop.add(consulResolver.Stop)
+
+	live := &reloadable{
+		logger:    logger,
+		nomad:     nomadClient,
+		consul:    consulResolver,
+		defaults:  fntypes.NewFunctionDefaults(*defaultMemory, *defaultCPU),
+		policy:    loadPolicy(logger),
+		volumes:   loadVolumes(logger),
+		templates: loadJobTemplates(logger),
+	}
+
+	tracker := handlers.NewRequestTracker()
+	handlers := createFaaSHandlers(nomadClient, *nomadConfig, consulResolver, tracker, live, stop, stats, logger)
+
+	// started once the handlers have added their clients to live
+	watchConfig(loader, live, stop)
 
 	config := &types.FaaSConfig{}
 	config.ReadTimeout = *functionTimeout
@@ -97,10 +212,289 @@ func main() {
 	logger.Info("Started Nomad provider", "port", *config.TCPPort)
 	logger.Info("Basic authentication", "enabled", fmt.Sprintf("%t", config.EnableBasicAuth))
 
-	bootstrap.Serve(handlers, config)
+	if err := server.RegisterRoutes(bootstrap.Router(), handlers, config); err != nil {
+		log.Fatal(err)
+	}
+
+	s := server.New(bootstrap.Router(), config, createTLSConfig(logger))
+	go func() {
+		if err := server.ListenAndServe(s); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	waitForShutdown(s, tracker, stop, logger, stats)
+}
+
+// validateFlags checks the settings which have a fixed set of values
+func validateFlags() error {
+	oneOf := map[string][]string{
+		"logger_format":      {"text", "json"},
+		"secret_store":       {fntypes.SecretStoreVault, fntypes.SecretStoreNomad, fntypes.SecretStoreConsul},
+		"secret_change_mode": {"restart", "signal", "noop"},
+		"vault_auth_method":  {vault.AuthMethodAppRole, vault.AuthMethodToken, vault.AuthMethodJWT, vault.AuthMethodNomad},
+		"invocation_store":   {"memory", "consul", "file"},
+	}
+
+	for name, valid := range oneOf {
+		value := flag.Lookup(name).Value.String()
+		if !contains(valid, value) {
+			return fmt.Errorf("Invalid value %q for %s, must be one of %s", value, name, strings.Join(valid, " | "))
+		}
+	}
+
+	if hclog.LevelFromString(*loggerLevel) == hclog.NoLevel {
+		return fmt.Errorf("Invalid value %q for logger_level", *loggerLevel)
+	}
+
+	if *vaultKVVersion < 0 || *vaultKVVersion > 2 {
+		return fmt.Errorf("Invalid value %d for vault_kv_version, must be 1 or 2", *vaultKVVersion)
+	}
+
+	if *defaultMemory <= 0 || *defaultCPU <= 0 {
+		return fmt.Errorf("default_memory and default_cpu must be greater than 0")
+	}
+
+	if *invocationSync <= 0 {
+		return fmt.Errorf("invocation_sync_interval must be greater than 0")
+	}
+
+	// a hostname is the container ID when the provider runs in Docker, it
+	// changes on every restart and would leave the saved counts behind
+	if *invocationStore != "memory" && *instanceID == "" && os.Getenv("NOMAD_ALLOC_NAME") == "" {
+		return fmt.Errorf("instance_id must be set with the %s invocation store when NOMAD_ALLOC_NAME is not available", *invocationStore)
+	}
+
+	if *instanceTTL != 0 && *instanceTTL <= *invocationSync {
+		return fmt.Errorf("invocation_instance_ttl must be 0 or greater than invocation_sync_interval (%s)", *invocationSync)
+	}
+
+	// the delete response is lost when the handler outlives the server write
+	// timeout, which is the function timeout, the stop timeout only delays
+	// the response when waiting for the allocations to stop
+	if *deleteWaitForStop && *deleteDrainTimeout+*deleteStopTimeout >= *functionTimeout {
+		return fmt.Errorf("delete_drain_timeout + delete_stop_timeout must be less than function_timeout (%s)", *functionTimeout)
+	}
+
+	if *deleteDrainTimeout >= *functionTimeout {
+		return fmt.Errorf("delete_drain_timeout must be less than function_timeout (%s)", *functionTimeout)
+	}
+
+	// the policy identifies callers by their verified credentials, without
+	// authentication every caller could claim any identity
+	if *policyFile != "" && !*enableBasicAuth && *jwtJWKSFile == "" && *jwtIssuer == "" {
+		return fmt.Errorf("policy_file requires enable_basic_auth, jwt_jwks_file or jwt_issuer")
+	}
+
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// reloadable holds the components whose settings can be changed without
+// restarting the provider
+type reloadable struct {
+	logger   hclog.Logger
+	nomad    *api.Client
+	consul   *consul.Resolver
+	defaults *fntypes.FunctionDefaults
+	// policy, volumes and templates are nil when they were not configured
+	// at startup
+	policy    *fnauth.Policy
+	volumes   *nomad.Volumes
+	templates *nomad.JobTemplates
+	// nomadTokens and consulTokens are the clients, other than the nomad and
+	// consul clients, which use the ACL tokens
+	nomadTokens  []tokenSetter
+	consulTokens []tokenSetter
+}
+
+// tokenSetter is a client whose ACL token can be replaced
+type tokenSetter interface {
+	SetToken(ACLToken string)
+}
+
+// reload reads the configuration file and environment again and applies the
+// reloadable settings, changes to other settings are logged and ignored
+func (r *reloadable) reload(loader *config.Loader) {
+	values, err := loader.Load()
+	if err != nil {
+		r.logger.Error("Unable to reload configuration", "error", err)
+		return
+	}
+
+	for _, name := range loader.Changed(values) {
+		if !reloadableFlags[name] {
+			r.logger.Warn("Configuration change requires a restart", "setting", name)
+		}
+	}
+
+	// flags are restored when the new values are not valid
+	changed, err := loader.Apply(values, func(name string) bool { return reloadableFlags[name] }, validateFlags)
+	if err != nil {
+		r.logger.Error("Unable to reload configuration", "error", err)
+		return
+	}
+
+	r.logger.SetLevel(hclog.LevelFromString(*loggerLevel))
+	r.nomad.SetSecretID(*nomadACL)
+	for _, t := range r.nomadTokens {
+		t.SetToken(*nomadACL)
+	}
+
+	if err := r.consul.SetToken(*consulACL); err != nil {
+		r.logger.Error("Unable to update Consul ACL token", "error", err)
+	}
+	for _, t := range r.consulTokens {
+		t.SetToken(*consulACL)
+	}
+
+	r.defaults.Set(*defaultMemory, *defaultCPU)
+
+	if r.policy != nil && *policyFile != "" {
+		policy, err := fnauth.LoadPolicy(*policyFile)
+		if err != nil {
+			r.logger.Error("Unable to reload authorization policy", "file", *policyFile, "error", err)
+		} else {
+			r.policy.Replace(policy)
+		}
+	} else if contains(changed, "policy_file") {
+		r.logger.Warn("Configuration change requires a restart", "setting", "policy_file")
+	}
+
+	if r.volumes != nil && *volumeFile != "" {
+		volumes, err := nomad.LoadVolumes(*volumeFile)
+		if err != nil {
+			r.logger.Error("Unable to reload volume allowlist", "file", *volumeFile, "error", err)
+		} else {
+			r.volumes.Replace(volumes)
+		}
+	} else if contains(changed, "volume_file") {
+		r.logger.Warn("Configuration change requires a restart", "setting", "volume_file")
+	}
+
+	if r.templates != nil && (*jobTemplateFile != "" || *jobTemplateDir != "") {
+		templates, err := nomad.LoadJobTemplates(*jobTemplateFile, *jobTemplateDir)
+		if err != nil {
+			r.logger.Error("Unable to reload job templates", "file", *jobTemplateFile, "dir", *jobTemplateDir, "error", err)
+		} else {
+			r.templates.Replace(templates)
+		}
+	} else if contains(changed, "job_template_file") || contains(changed, "job_template_dir") {
+		r.logger.Warn("Configuration change requires a restart", "setting", "job_template_file,job_template_dir")
+	}
+
+	r.logger.Info("Configuration reloaded", "changed", strings.Join(changed, ","))
+}
+
+// watchConfig reloads the configuration when SIGHUP is received or when the
+// configuration file changes
+func watchConfig(loader *config.Loader, live *reloadable, stop *shutdownFuncs) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	var changed <-chan struct{}
+	if *configFile != "" && *configWatchInterval > 0 {
+		watcher := config.NewWatcher(*configFile, *configWatchInterval)
+		stop.add(watcher.Stop)
+		changed = watcher.C
+	}
+
+	done := make(chan struct{})
+	stop.add(func() {
+		signal.Stop(hup)
+		close(done)
+	})
+
+	go func() {
+		for {
+			select {
+			case <-hup:
+				live.logger.Info("Reloading configuration", "reason", "SIGHUP")
+			case <-changed:
+				live.logger.Info("Reloading configuration", "reason", "file changed", "file", *configFile)
+			case <-done:
+				return
+			}
+
+			live.reload(loader)
+		}
+	}()
+}
+
+// shutdownFuncs are the functions which stop the provider's background
+// workers, they are called in the reverse order to which they were added
+type shutdownFuncs []func()
+
+func (s *shutdownFuncs) add(f func()) {
+	*s = append(*s, f)
+}
+
+func (s shutdownFuncs) run() {
+	for i := len(s) - 1; i >= 0; i-- {
+		s[i]()
+	}
+}
+
+// waitForShutdown blocks until SIGINT or SIGTERM is received, the server then
+// stops accepting connections and waits for in-flight requests to complete
+// before the background workers are stopped
+func waitForShutdown(s *http.Server, tracker *handlers.RequestTracker, stop *shutdownFuncs, logger hclog.Logger, stats *statsd.Client) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	sig := <-signals
+	logger.Info("Shutting down", "signal", sig.String(), "in_flight", tracker.Total(), "timeout", shutdownTimeout.String())
+	stats.Incr("shutdown", nil, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+
+	if err := s.Shutdown(ctx); err != nil {
+		logger.Warn("Timeout draining in-flight requests", "in_flight", tracker.Total(), "error", err)
+		stats.Incr("shutdown.error.draintimeout", nil, 1)
+		s.Close()
+	}
+
+	stop.run()
+	logger.Info("Shutdown complete")
+}
+
+// createTLSConfig returns the TLS config for the provider's server, nil is
+// returned when no certificate is configured and the server uses plain HTTP
+func createTLSConfig(logger hclog.Logger) *tls.Config {
+	if *tlsCertFile == "" && *tlsKeyFile == "" {
+		if *tlsClientCAFile != "" {
+			log.Fatal("tls_client_ca_file requires tls_cert_file and tls_key_file")
+		}
+
+		return nil
+	}
+
+	certs, err := server.NewCertReloader(*tlsCertFile, *tlsKeyFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	tlsConfig, err := server.NewTLSConfig(certs, *tlsClientCAFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	logger.Info("TLS", "cert_file", *tlsCertFile, "client_ca_file", *tlsClientCAFile)
+
+	return tlsConfig
 }
 
-func createFaaSHandlers(nomadClient *api.Client, consulResolver *consul.Resolver, stats *statsd.Client, logger hclog.Logger) *types.FaaSHandlers {
+func createFaaSHandlers(nomadClient *api.Client, nomadConfig fntypes.NomadConfig, consulResolver *consul.Resolver, tracker *handlers.RequestTracker, live *reloadable, stop *shutdownFuncs, stats *statsd.Client, logger hclog.Logger) *types.FaaSHandlers {
 
 	datacenter, err := nomadClient.Agent().Datacenter()
 	if err != nil {
@@ -125,36 +519,469 @@ func createFaaSHandlers(nomadClient *api.Client, consulResolver *consul.Resolver
 	vaultConfig.SecretPathPrefix = *vaultSecretPathPrefix
 	vaultConfig.AppRoleID = *vaultAppRoleID
 	vaultConfig.AppSecretID = *vaultAppRoleSecretID
+	vaultConfig.AppSecretIDFile = *vaultAppSecretIDFile
+	vaultConfig.AuthMethod = *vaultAuthMethod
+	vaultConfig.AuthMount = *vaultAuthMount
+	vaultConfig.TokenFile = *vaultTokenFile
+	vaultConfig.JWTRole = *vaultJWTRole
+	vaultConfig.JWTFile = *vaultJWTFile
 	vaultConfig.TLSSkipVerify = *vaultTLSSkipVerify
+	vaultConfig.KVVersion = *vaultKVVersion
+
+	vs := vault.NewVaultService(&vaultConfig, logger)
+	stop.add(vs.Stop)
+
+	secrets, secretsAvailable := createSecretStore(vs, nomadConfig, live, logger)
 
 	providerConfig := &fntypes.ProviderConfig{
-		Vault:             vaultConfig,
-		Datacenter:        datacenter,
-		ConsulAddress:     *consulAddr,
-		ConsulDNSEnabled:  *enableConsulDNS,
-		CPUArchConstraint: *cpuArchConstraint,
+		Secrets:            secrets,
+		Datacenter:         datacenter,
+		ConsulAddress:      *consulAddr,
+		ConsulDNSEnabled:   *enableConsulDNS,
+		CPUArchConstraint:  *cpuArchConstraint,
+		SecretChangeMode:   *secretChangeMode,
+		SecretChangeSignal: *secretChangeSignal,
+		Defaults:           live.defaults,
 	}
 
-	vs := vault.NewVaultService(&vaultConfig, logger)
+	// the interfaces are left nil when nothing is configured
+	if live.templates != nil {
+		providerConfig.JobTemplates = live.templates
+	}
 
-	_, loginErr := vs.Login()
-	if loginErr != nil {
-		logger.Error("Unable to login to Vault. Secrets will not work properly", loginErr.Error())
-	} else {
-		logger.Info("Vault authentication successful!")
+	if live.volumes != nil {
+		providerConfig.Volumes = live.volumes
+	}
+
+	connectService := createConnectService(logger)
+	if connectService != nil {
+		stop.add(connectService.Close)
+		providerConfig.Connect = &fntypes.ConnectConfig{SidecarImage: *connectSidecarImage}
+	}
+
+	backendVersions := handlers.NewBackendVersionCache(
+		nomadVersion(nomadClient),
+		consulVersion(*consulAddr, *consulACL),
+		vaultVersion(vs),
+		logger,
+	)
+	backendVersions.Start(*versionRefresh)
+	stop.add(backendVersions.Stop)
+
+	infoConfig := handlers.InfoConfig{
+		Version:    version,
+		SHA:        commit,
+		Datacenter: datacenter,
+		Region:     *nomadRegion,
+		Capabilities: handlers.Capabilities{
+			ScaleToZero: true,
+		},
+		SecretsAvailable: secretsAvailable,
+		Backends:         backendVersions,
+	}
+
+	secretLister := availableSecrets{store: secrets, available: secretsAvailable}
+
+	rec := reconciler.New(nomadClient.Jobs(), consulResolver, secretLister, *reconcileThreshold, logger, stats)
+	if *reconcileInterval > 0 {
+		rec.Start(*reconcileInterval)
+		stop.add(rec.Stop)
+	}
+
+	withJWT := makeJWTDecorator(logger, stats)
+	authorize := makeAuthorizer(live.policy, logger, stats)
+	audited := makeAuditor(stop, logger)
+	functionName := func(r *http.Request) string {
+		return mux.Vars(r)["name"]
+	}
+
+	// secure authenticates bearer tokens, then records the operation in the
+	// audit log and checks the authorization policy before calling the handler
+	secure := func(operation audit.Operation, function fnauth.FunctionName, mapper fnauth.RequestMapper, next http.HandlerFunc) http.HandlerFunc {
+		h := withJWT(audited(operation, function, authorize(mapper, next)))
+		if *enableBasicAuth {
+			// basic auth credentials are verified before secure is called
+			h = fnauth.MakeBasicAuthIdentity(h)
+		}
+
+		return h
+	}
+
+	bootstrap.Router().HandleFunc(
+		"/system/reconcile",
+		decorateWithBasicAuth(secure(nil, nil, fnauth.Action(fnauth.ActionRead, nil), handlers.MakeReconcileReport(rec, logger, stats))),
+	).Methods(http.MethodGet)
+
+	// dispatched invocations are read with the same permission as invoking
+	// the function
+	dispatchPath := handlers.DispatchPath + "/{name:[-a-zA-Z_0-9]+}/{id:dispatch-[-a-zA-Z0-9]+}"
+	bootstrap.Router().HandleFunc(
+		dispatchPath,
+		decorateWithBasicAuth(secure(nil, nil, fnauth.Action(fnauth.ActionInvoke, functionName), handlers.MakeDispatchStatus(nomadClient.Jobs(), mux.Vars, logger, stats))),
+	).Methods(http.MethodGet)
+	bootstrap.Router().HandleFunc(
+		dispatchPath+"/result",
+		decorateWithBasicAuth(secure(nil, nil, fnauth.Action(fnauth.ActionInvoke, functionName), handlers.MakeDispatchResult(nomadClient.Jobs(), nomadClient.Allocations(), nomadClient.AllocFS(), mux.Vars, logger, stats))),
+	).Methods(http.MethodGet)
+
+	deleteConfig := handlers.DeleteConfig{
+		DrainTimeout: *deleteDrainTimeout,
+		WaitForStop:  *deleteWaitForStop,
+		StopTimeout:  *deleteStopTimeout,
+	}
+
+	secretsConfig := handlers.SecretsConfig{
+		RestartOnUpdate: *secretRestartOnUpdate,
+	}
+
+	// function reads are served from the inventory, writes always go to Nomad
+	var functionJobs nomad.Job = nomadClient.Jobs()
+	if *enableInventory {
+		inv := inventory.New(nomadClient.Jobs(), logger, stats)
+		inv.Start()
+		stop.add(inv.Stop)
+
+		functionJobs = inv
+	}
+
+	invocations := createInvocations(live, logger)
+	invocations.Start(*invocationSync)
+	stop.add(invocations.Stop)
+
+	functionProxy := makeFunctionProxyHandler(functionJobs, consulResolver, connectService, tracker, invocations, logger, stats, *functionTimeout)
+	if *jwtProtectInvoke {
+		// the gateway invokes functions without credentials, so invocations
+		// can only be authorized when they require a bearer token
+		functionProxy = secure(nil, nil, fnauth.Action(fnauth.ActionInvoke, functionName), functionProxy)
 	}
 
 	return &types.FaaSHandlers{
-		FunctionReader: handlers.MakeReader(nomadClient.Jobs(), logger, stats),
-		DeployHandler:  handlers.MakeDeploy(nomadClient.Jobs(), *providerConfig, logger, stats),
-		DeleteHandler:  handlers.MakeDelete(consulResolver, nomadClient.Jobs(), logger, stats),
-		ReplicaReader:  makeReplicationReader(nomadClient.Jobs(), logger, stats),
-		ReplicaUpdater: makeReplicationUpdater(nomadClient.Jobs(), logger, stats),
-		FunctionProxy:  makeFunctionProxyHandler(consulResolver, logger, stats, *functionTimeout),
-		UpdateHandler:  handlers.MakeDeploy(nomadClient.Jobs(), *providerConfig, logger, stats),
-		InfoHandler:    handlers.MakeInfo(logger, stats, version),
+		FunctionReader: secure(nil, nil, fnauth.Action(fnauth.ActionRead, nil), handlers.MakeReader(functionJobs, invocations, logger, stats)),
+		DeployHandler:  secure(audit.StaticOperation("deploy"), fnauth.DeployFunction, fnauth.Action(fnauth.ActionDeploy, fnauth.DeployFunction), handlers.MakeDeploy(nomadClient.Jobs(), *providerConfig, logger, stats)),
+		DeleteHandler:  secure(audit.StaticOperation("delete"), fnauth.DeleteFunction, fnauth.Action(fnauth.ActionDelete, fnauth.DeleteFunction), handlers.MakeDelete(consulResolver, nomadClient.Jobs(), tracker, deleteConfig, logger, stats)),
+		ReplicaReader:  secure(nil, nil, fnauth.Action(fnauth.ActionRead, functionName), makeReplicationReader(functionJobs, invocations, logger, stats)),
+		ReplicaUpdater: secure(audit.StaticOperation("scale"), functionName, fnauth.Action(fnauth.ActionScale, functionName), makeReplicationUpdater(nomadClient.Jobs(), logger, stats)),
+		FunctionProxy:  functionProxy,
+		UpdateHandler:  secure(audit.StaticOperation("update"), fnauth.DeployFunction, fnauth.Action(fnauth.ActionUpdate, fnauth.DeployFunction), handlers.MakeDeploy(nomadClient.Jobs(), *providerConfig, logger, stats)),
+		InfoHandler:    secure(nil, nil, fnauth.Action(fnauth.ActionRead, nil), handlers.MakeInfo(infoConfig, logger, stats)),
 		Health:         handlers.MakeHealthHandler(),
-		SecretHandler:  handlers.MakeSecretHandler(vs, logger.Named("secrets_handler")),
+		SecretHandler:  secure(audit.SecretsOperation, nil, fnauth.SecretsAction, handlers.MakeSecretHandler(secrets, nomadClient.Jobs(), secretsConfig, logger.Named("secrets_handler"))),
+	}
+}
+
+// createSecretStore creates the secret store selected by the secret_store flag,
+// the returned function reports whether secrets can be used
+func createSecretStore(vs *vault.VaultService, nomadConfig fntypes.NomadConfig, live *reloadable, logger hclog.Logger) (fntypes.SecretStore, func() bool) {
+	logger.Info("Secret store", "backend", *secretStore)
+
+	always := func() bool { return true }
+
+	switch *secretStore {
+	case fntypes.SecretStoreNomad:
+		store, err := nomad.NewVariablesSecretStore(nomadConfig, *secretStorePrefix)
+		if err != nil {
+			log.Fatal(err)
+		}
+		live.nomadTokens = append(live.nomadTokens, store)
+
+		return store, always
+	case fntypes.SecretStoreConsul:
+		store, err := consul.NewKVSecretStore(*consulAddr, *consulACL, *secretStorePrefix)
+		if err != nil {
+			log.Fatal(err)
+		}
+		live.consulTokens = append(live.consulTokens, store)
+
+		return store, always
+	case fntypes.SecretStoreVault:
+	default:
+		log.Fatalf("Unknown secret store %q, expected vault, nomad or consul", *secretStore)
+	}
+
+	// the k/v version is detected by the Vault service after it logs in
+	_, loginErr := vs.Login()
+	if loginErr != nil {
+		logger.Error("Unable to login to Vault. Secrets will not work properly until login succeeds", loginErr.Error())
+		if vs.Config.Addr != "" {
+			vs.RetryLogin()
+		}
+
+		return vs, vs.Available
+	}
+
+	logger.Info("Vault authentication successful!")
+
+	return vs, vs.Available
+}
+
+// availableSecrets lists the secrets of a store once it can be used, the
+// Vault store is not available until the provider has logged in
+type availableSecrets struct {
+	store     fntypes.SecretStore
+	available func() bool
+}
+
+func (s availableSecrets) ListSecrets() ([]string, error) {
+	if !s.available() {
+		return nil, nil
+	}
+
+	return s.store.ListSecrets()
+}
+
+// createInvocations creates the invocation counters, counts are saved to the
+// store selected by the invocation_store flag
+func createInvocations(live *reloadable, logger hclog.Logger) *metrics.Invocations {
+	instance := *instanceID
+	if instance == "" {
+		instance = os.Getenv("NOMAD_ALLOC_NAME")
+	}
+
+	// counts are only kept in memory, so the ID does not need to be stable
+	if instance == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		instance = hostname
+	}
+	instance = strings.Replace(instance, "/", "_", -1)
+
+	logger.Info("Invocation store", "backend", *invocationStore, "instance", instance)
+
+	var store metrics.InvocationStore
+	switch *invocationStore {
+	case "consul":
+		s, err := consul.NewKVInvocationStore(*consulAddr, *consulACL, *invocationStorePrefix)
+		if err != nil {
+			log.Fatal(err)
+		}
+		live.consulTokens = append(live.consulTokens, s)
+
+		store = s
+	case "file":
+		store = metrics.NewFileInvocationStore(*invocationFile)
+	}
+
+	return metrics.NewInvocations(instance, store, *instanceTTL, logger)
+}
+
+// makeJWTDecorator returns a function which adds bearer token authentication
+// to a handler when a JWKS file or issuer is configured
+func makeJWTDecorator(logger hclog.Logger, stats metrics.StatsD) func(http.HandlerFunc) http.HandlerFunc {
+	if *jwtJWKSFile == "" && *jwtIssuer == "" {
+		return func(next http.HandlerFunc) http.HandlerFunc {
+			return next
+		}
+	}
+
+	if *enableBasicAuth {
+		log.Fatal("enable_basic_auth can not be used with JWT authentication, both use the Authorization header")
+	}
+
+	var keys fnauth.KeySource = fnauth.NewIssuerKeySource(*jwtIssuer)
+	if *jwtJWKSFile != "" {
+		fileKeys, err := fnauth.NewFileKeySource(*jwtJWKSFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		keys = fileKeys
+	}
+
+	validator := &fnauth.Validator{
+		Keys:     keys,
+		Issuer:   *jwtIssuer,
+		Audience: *jwtAudience,
+		Leeway:   30 * time.Second,
+	}
+
+	logger.Info("JWT authentication", "issuer", *jwtIssuer, "audience", *jwtAudience, "jwks_file", *jwtJWKSFile, "invoke", *jwtProtectInvoke)
+
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return fnauth.MakeJWTMiddleware(validator, next, logger, stats)
+	}
+}
+
+// loadJobTemplates loads the job templates, nil is returned when no templates
+// are configured
+func loadJobTemplates(logger hclog.Logger) *nomad.JobTemplates {
+	if *jobTemplateFile == "" && *jobTemplateDir == "" {
+		return nil
+	}
+
+	templates, err := nomad.LoadJobTemplates(*jobTemplateFile, *jobTemplateDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	logger.Info("Job templates", "file", *jobTemplateFile, "dir", *jobTemplateDir, "count", templates.Len())
+
+	return templates
+}
+
+// createConnectService creates the provider's Consul Connect identity, nil is
+// returned when Connect is not enabled
+func createConnectService(logger hclog.Logger) *consul.ConnectService {
+	if !*enableConnect {
+		return nil
+	}
+
+	svc, err := consul.NewConnectService(*connectServiceName, *consulAddr, *consulACL)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	logger.Info("Consul Connect enabled", "service", *connectServiceName, "sidecar_image", *connectSidecarImage)
+
+	return svc
+}
+
+// loadVolumes loads the volume allowlist, nil is returned when no allowlist
+// is configured
+func loadVolumes(logger hclog.Logger) *nomad.Volumes {
+	if *volumeFile == "" {
+		return nil
+	}
+
+	volumes, err := nomad.LoadVolumes(*volumeFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	logger.Info("Volume allowlist", "file", *volumeFile, "count", volumes.Len())
+
+	return volumes
+}
+
+// loadPolicy loads the policy file, nil is returned when no policy file is
+// configured
+func loadPolicy(logger hclog.Logger) *fnauth.Policy {
+	if *policyFile == "" {
+		return nil
+	}
+
+	policy, err := fnauth.LoadPolicy(*policyFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	logger.Info("Authorization policy", "file", *policyFile, "rules", len(policy.Rules))
+
+	return policy
+}
+
+// makeAuthorizer returns a function which checks the policy before a handler
+// is called, handlers are not changed when there is no policy
+func makeAuthorizer(policy *fnauth.Policy, logger hclog.Logger, stats metrics.StatsD) func(fnauth.RequestMapper, http.HandlerFunc) http.HandlerFunc {
+	if policy == nil {
+		return func(mapper fnauth.RequestMapper, next http.HandlerFunc) http.HandlerFunc {
+			return next
+		}
+	}
+
+	return func(mapper fnauth.RequestMapper, next http.HandlerFunc) http.HandlerFunc {
+		return fnauth.MakeAuthorizer(policy, mapper, next, logger, stats)
+	}
+}
+
+// makeAuditor returns a function which records the operations performed by
+// a handler to the audit log, handlers are not changed when auditing is
+// disabled or the operation is nil
+func makeAuditor(stop *shutdownFuncs, logger hclog.Logger) func(audit.Operation, fnauth.FunctionName, http.HandlerFunc) http.HandlerFunc {
+	sinks := []audit.Sink{}
+
+	if *auditLogFile != "" {
+		file, err := audit.NewFileSink(*auditLogFile, *auditLogMaxSize*1024*1024, *auditLogMaxBackups)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		sinks = append(sinks, file)
+		stop.add(func() { file.Close() })
+	}
+
+	if *auditWebhookURL != "" {
+		webhook := audit.NewWebhookSink(*auditWebhookURL, logger)
+		sinks = append(sinks, webhook)
+		stop.add(webhook.Close)
+	}
+
+	if len(sinks) == 0 {
+		return func(operation audit.Operation, function fnauth.FunctionName, next http.HandlerFunc) http.HandlerFunc {
+			return next
+		}
+	}
+
+	logger.Info("Audit log", "file", *auditLogFile, "webhook", *auditWebhookURL)
+	auditLog := audit.NewLog(logger, sinks...)
+
+	return func(operation audit.Operation, function fnauth.FunctionName, next http.HandlerFunc) http.HandlerFunc {
+		if operation == nil {
+			return next
+		}
+
+		return audit.MakeAuditMiddleware(auditLog, operation, function, next)
+	}
+}
+
+// decorateWithBasicAuth adds basic authentication to handlers which are not
+// registered by the faas-provider
+func decorateWithBasicAuth(next http.HandlerFunc) http.HandlerFunc {
+	if !*enableBasicAuth {
+		return next
+	}
+
+	reader := auth.ReadBasicAuthFromDisk{SecretMountPath: *basicAuthSecretPath}
+	credentials, err := reader.Read()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return auth.DecorateWithBasicAuth(next, credentials)
+}
+
+func nomadVersion(client *api.Client) handlers.VersionFetcher {
+	return func() (string, error) {
+		self, err := client.Agent().Self()
+		if err != nil {
+			return "", err
+		}
+
+		return self.Member.Tags["build"], nil
+	}
+}
+
+func consulVersion(address, ACLToken string) handlers.VersionFetcher {
+	client, err := consulapi.NewClient(&consulapi.Config{Address: address, Token: ACLToken})
+	if err != nil {
+		return nil
+	}
+
+	return func() (string, error) {
+		self, err := client.Agent().Self()
+		if err != nil {
+			return "", err
+		}
+
+		v, _ := self["Config"]["Version"].(string)
+		return v, nil
+	}
+}
+
+func vaultVersion(vs *vault.VaultService) handlers.VersionFetcher {
+	if vs.Config.Addr == "" {
+		return nil
+	}
+
+	return func() (string, error) {
+		health, err := vs.Client.Sys().Health()
+		if err != nil {
+			return "", err
+		}
+
+		return health.Version, nil
 	}
 }
 
@@ -182,7 +1009,6 @@ func makeDependencies(statsDAddr string, thisAddr string, nomadConfig fntypes.No
 			ClientKey:  nomadConfig.TLSPrivateKey,
 			Insecure:   nomadConfig.TLSSkipVerify,
 		}
-		clientConfig.ConfigureTLS()
 	}
 
 	nomadClient, err := api.NewClient(clientConfig)
@@ -212,7 +1038,13 @@ func setupLogging() hclog.Logger {
 }
 
 func createLogFile() *os.File {
-	if logFile := os.Getenv("logger_output"); logFile != "" {
+	logFile := *loggerOutput
+	if logFile == "" {
+		// logger_output was read from the environment before the configuration file was supported
+		logFile = os.Getenv("logger_output")
+	}
+
+	if logFile != "" {
 		f, err := os.OpenFile(logFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
 		if err == nil {
 			return f
@@ -223,29 +1055,47 @@ func createLogFile() *os.File {
 
 	return os.Stdout
 }
-func makeFunctionProxyHandler(r consul.ServiceResolver, logger hclog.Logger, s *statsd.Client, timeout time.Duration) http.HandlerFunc {
+func makeFunctionProxyHandler(client nomad.Job, r consul.ServiceResolver, connectService *consul.ConnectService, tracker *handlers.RequestTracker, invocations metrics.InvocationCounter, logger hclog.Logger, s *statsd.Client, timeout time.Duration) http.HandlerFunc {
+	proxyClient := handlers.MakeProxyClient(timeout, logger)
+	if connectService != nil {
+		// functions with a Connect proxy are called over mTLS, others are
+		// resolved and called as before
+		r = consul.NewConnectResolver(r, connectService.Health())
+		proxyClient = handlers.MakeConnectProxyClient(timeout, connectService.HTTPClient(timeout), logger)
+	}
+
 	return handlers.MakeExtractFunctionMiddleWare(
 		func(r *http.Request) map[string]string {
 			return mux.Vars(r)
 		},
-		handlers.MakeProxy(
-			handlers.ProxyConfig{
-				Client:   handlers.MakeProxyClient(timeout, logger),
-				Resolver: r,
-				Logger:   logger,
-				StatsD:   s,
-				Timeout:  timeout,
-			},
+		handlers.MakeInvocationCounter(
+			invocations,
+			handlers.MakeDispatchProxy(
+				client,
+				tracker,
+				handlers.MakeProxy(
+					handlers.ProxyConfig{
+						Client:   proxyClient,
+						Resolver: r,
+						Tracker:  tracker,
+						Logger:   logger,
+						StatsD:   s,
+						Timeout:  timeout,
+					},
+				),
+				logger,
+				s,
+			),
 		),
 	)
 }
 
-func makeReplicationReader(client nomad.Job, logger hclog.Logger, stats metrics.StatsD) http.HandlerFunc {
+func makeReplicationReader(client nomad.Job, invocations metrics.InvocationCounter, logger hclog.Logger, stats metrics.StatsD) http.HandlerFunc {
 	return handlers.MakeExtractFunctionMiddleWare(
 		func(r *http.Request) map[string]string {
 			return mux.Vars(r)
 		},
-		handlers.MakeReplicationReader(client, logger, stats),
+		handlers.MakeReplicationReader(client, invocations, logger, stats),
 	)
 }
 
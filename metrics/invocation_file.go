@@ -0,0 +1,111 @@
+package metrics
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FileInvocationStore implements InvocationStore with a JSON file, it is
+// used by a single provider or by instances which share a volume
+type FileInvocationStore struct {
+	path  string
+	mutex sync.Mutex
+}
+
+// NewFileInvocationStore creates a FileInvocationStore, the file is created
+// on the first save
+func NewFileInvocationStore(path string) *FileInvocationStore {
+	return &FileInvocationStore{path: path}
+}
+
+// Load implements the InvocationStore interface
+func (s *FileInvocationStore) Load() (map[string]SavedInvocations, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.read()
+}
+
+// Save implements the InvocationStore interface, the file is replaced
+// atomically so that a crash does not lose the saved counts
+func (s *FileInvocationStore) Save(instance string, counts InvocationCounts) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	saved, err := s.read()
+	if err != nil {
+		return err
+	}
+
+	saved[instance] = SavedInvocations{Counts: counts, Updated: time.Now().UTC()}
+
+	return s.write(saved)
+}
+
+// Remove implements the InvocationStore interface
+func (s *FileInvocationStore) Remove(instance string, removed SavedInvocations) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	saved, err := s.read()
+	if err != nil {
+		return false, err
+	}
+
+	current, ok := saved[instance]
+	if !ok || !current.Updated.Equal(removed.Updated) {
+		return false, nil
+	}
+
+	delete(saved, instance)
+
+	return true, s.write(saved)
+}
+
+func (s *FileInvocationStore) write(saved map[string]SavedInvocations) error {
+	data, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path)
+}
+
+func (s *FileInvocationStore) read() (map[string]SavedInvocations, error) {
+	saved := map[string]SavedInvocations{}
+
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return saved, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, err
+	}
+
+	return saved, nil
+}
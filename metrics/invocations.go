@@ -0,0 +1,221 @@
+package metrics
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// InvocationCounts are the invocations of each function by status class,
+// e.g. counts["echo"]["2xx"]
+type InvocationCounts map[string]map[string]uint64
+
+// SavedInvocations are the invocation counts saved by an instance and the
+// time they were saved
+type SavedInvocations struct {
+	Counts  InvocationCounts `json:"counts"`
+	Updated time.Time        `json:"updated"`
+
+	// Index identifies the saved version in stores which support check and
+	// set, it is not saved
+	Index uint64 `json:"-"`
+}
+
+// InvocationStore persists the invocation counts of each provider instance
+type InvocationStore interface {
+	// Load returns the saved counts of every instance keyed by instance ID
+	Load() (map[string]SavedInvocations, error)
+	// Save replaces the saved counts of the instance
+	Save(instance string, counts InvocationCounts) error
+	// Remove deletes the saved counts of the instance when they have not
+	// changed since they were loaded, false is returned when they have
+	Remove(instance string, saved SavedInvocations) (bool, error)
+}
+
+// InvocationCounter records function invocations and returns the totals
+type InvocationCounter interface {
+	Incr(function string, status int)
+	Counts(function string) map[string]uint64
+}
+
+// StatusClass returns the class of an HTTP status code, e.g. 2xx
+func StatusClass(status int) string {
+	if status < 100 || status > 599 {
+		return "5xx"
+	}
+
+	return fmt.Sprintf("%dxx", status/100)
+}
+
+// Invocations counts the invocations of functions made through this
+// instance, the counts are periodically saved to the store and the counts
+// saved by other instances are added to the totals. Instances which have
+// not saved their counts for longer than staleAfter are removed from the
+// store and their counts are added to the counts of this instance, so that
+// the store does not grow as instances are replaced
+type Invocations struct {
+	instance   string
+	store      InvocationStore
+	staleAfter time.Duration
+	logger     hclog.Logger
+
+	mutex  sync.RWMutex
+	local  InvocationCounts
+	others InvocationCounts
+	loaded bool
+	stop   chan struct{}
+}
+
+// NewInvocations creates an Invocations for the instance, the store can be
+// nil when counts are not persisted and stale instances are not removed when
+// staleAfter is 0
+func NewInvocations(instance string, store InvocationStore, staleAfter time.Duration, logger hclog.Logger) *Invocations {
+	return &Invocations{
+		instance:   instance,
+		store:      store,
+		staleAfter: staleAfter,
+		logger:     logger.Named("invocations"),
+		local:      InvocationCounts{},
+		others:     InvocationCounts{},
+		stop:       make(chan struct{}),
+	}
+}
+
+// Incr records an invocation of the function which returned the status
+func (i *Invocations) Incr(function string, status int) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	add(i.local, function, StatusClass(status), 1)
+}
+
+// Counts returns the invocations of the function by status class across all
+// instances
+func (i *Invocations) Counts(function string) map[string]uint64 {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	counts := map[string]uint64{}
+	for _, c := range []InvocationCounts{i.local, i.others} {
+		for class, n := range c[function] {
+			counts[class] += n
+		}
+	}
+
+	return counts
+}
+
+// Start loads the saved counts and then saves the counts of this instance at
+// the given interval until Stop is called
+func (i *Invocations) Start(interval time.Duration) {
+	i.sync()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				i.sync()
+			case <-i.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the periodic sync and saves the counts of this instance
+func (i *Invocations) Stop() {
+	close(i.stop)
+	i.sync()
+}
+
+func (i *Invocations) sync() {
+	if err := i.Sync(); err != nil {
+		i.logger.Warn("Unable to sync invocation counts", "error", err)
+	}
+}
+
+// Sync saves the counts of this instance and reads the counts of the other
+// instances, the counts saved by this instance before a restart are added to
+// the first sync
+func (i *Invocations) Sync() error {
+	if i.store == nil {
+		return nil
+	}
+
+	saved, err := i.store.Load()
+	if err != nil {
+		return err
+	}
+
+	others := InvocationCounts{}
+	pruned := InvocationCounts{}
+	for instance, s := range saved {
+		if instance == i.instance {
+			continue
+		}
+
+		if i.isStale(s) {
+			removed, err := i.store.Remove(instance, s)
+			if err != nil {
+				i.logger.Warn("Unable to remove stale instance", "instance", instance, "error", err)
+			}
+
+			// only the instance which removes the counts adds them to its
+			// own, counts which changed or were removed by another instance
+			// are read again on the next sync
+			if removed {
+				i.logger.Info("Removed stale instance", "instance", instance, "updated", s.Updated)
+				merge(pruned, s.Counts)
+			}
+
+			if err == nil {
+				continue
+			}
+		}
+
+		merge(others, s.Counts)
+	}
+
+	i.mutex.Lock()
+	if !i.loaded {
+		// counts are not saved until the previous counts are loaded so that
+		// they are not overwritten after a restart
+		merge(i.local, saved[i.instance].Counts)
+		i.loaded = true
+	}
+
+	merge(i.local, pruned)
+
+	i.others = others
+
+	local := InvocationCounts{}
+	merge(local, i.local)
+	i.mutex.Unlock()
+
+	return i.store.Save(i.instance, local)
+}
+
+func (i *Invocations) isStale(s SavedInvocations) bool {
+	return i.staleAfter > 0 && time.Since(s.Updated) > i.staleAfter
+}
+
+func merge(dst, src InvocationCounts) {
+	for function, classes := range src {
+		for class, n := range classes {
+			add(dst, function, class, n)
+		}
+	}
+}
+
+func add(c InvocationCounts, function, class string, n uint64) {
+	if c[function] == nil {
+		c[function] = map[string]uint64{}
+	}
+
+	c[function][class] += n
+}
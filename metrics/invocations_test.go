@@ -0,0 +1,141 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupInvocationStore(t *testing.T) (*FileInvocationStore, func()) {
+	dir, err := ioutil.TempDir("", "invocations")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewFileInvocationStore(filepath.Join(dir, "invocations.json")), func() { os.RemoveAll(dir) }
+}
+
+func TestStatusClassGroupsStatusCodes(t *testing.T) {
+	assert.Equal(t, "2xx", StatusClass(202))
+	assert.Equal(t, "4xx", StatusClass(404))
+	assert.Equal(t, "5xx", StatusClass(0))
+}
+
+func TestInvocationsSumsCountsOfOtherInstances(t *testing.T) {
+	store, cleanup := setupInvocationStore(t)
+	defer cleanup()
+
+	store.Save("b", InvocationCounts{"echo": {"2xx": 5}})
+
+	i := NewInvocations("a", store, 0, hclog.Default())
+	i.Incr("echo", 200)
+	i.Incr("echo", 500)
+
+	assert.Nil(t, i.Sync())
+	assert.Equal(t, map[string]uint64{"2xx": 6, "5xx": 1}, i.Counts("echo"))
+
+	saved, _ := store.Load()
+	assert.Equal(t, InvocationCounts{"echo": {"2xx": 1, "5xx": 1}}, saved["a"].Counts)
+}
+
+func TestInvocationsRestoresCountsAfterRestart(t *testing.T) {
+	store, cleanup := setupInvocationStore(t)
+	defer cleanup()
+
+	i := NewInvocations("a", store, 0, hclog.Default())
+	i.Incr("echo", 200)
+	i.Sync()
+
+	restarted := NewInvocations("a", store, 0, hclog.Default())
+	restarted.Incr("echo", 200)
+	restarted.Sync()
+	restarted.Sync()
+
+	assert.Equal(t, map[string]uint64{"2xx": 2}, restarted.Counts("echo"))
+}
+
+func TestInvocationsDoesNotSaveWhenLoadFails(t *testing.T) {
+	store, cleanup := setupInvocationStore(t)
+	defer cleanup()
+
+	ioutil.WriteFile(store.path, []byte("not json"), 0600)
+
+	i := NewInvocations("a", store, 0, hclog.Default())
+	i.Incr("echo", 200)
+
+	assert.NotNil(t, i.Sync())
+
+	data, _ := ioutil.ReadFile(store.path)
+	assert.Equal(t, "not json", string(data))
+}
+
+func setStale(t *testing.T, store *FileInvocationStore, instance string) {
+	saved, err := store.read()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := saved[instance]
+	s.Updated = time.Now().Add(-2 * time.Hour)
+	saved[instance] = s
+
+	if err := store.write(saved); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInvocationsRemovesStaleInstancesAndKeepsTheirCounts(t *testing.T) {
+	store, cleanup := setupInvocationStore(t)
+	defer cleanup()
+
+	store.Save("b", InvocationCounts{"echo": {"2xx": 5}})
+	setStale(t, store, "b")
+	store.Save("c", InvocationCounts{"echo": {"2xx": 2}})
+
+	i := NewInvocations("a", store, time.Hour, hclog.Default())
+	i.Incr("echo", 200)
+
+	assert.Nil(t, i.Sync())
+	assert.Equal(t, map[string]uint64{"2xx": 8}, i.Counts("echo"))
+
+	saved, _ := store.Load()
+	assert.NotContains(t, saved, "b")
+	assert.Equal(t, InvocationCounts{"echo": {"2xx": 6}}, saved["a"].Counts)
+}
+
+func TestInvocationsKeepsStaleInstancesWhenDisabled(t *testing.T) {
+	store, cleanup := setupInvocationStore(t)
+	defer cleanup()
+
+	store.Save("b", InvocationCounts{"echo": {"2xx": 5}})
+	setStale(t, store, "b")
+
+	i := NewInvocations("a", store, 0, hclog.Default())
+
+	assert.Nil(t, i.Sync())
+	assert.Equal(t, map[string]uint64{"2xx": 5}, i.Counts("echo"))
+
+	saved, _ := store.Load()
+	assert.Contains(t, saved, "b")
+}
+
+func TestFileInvocationStoreDoesNotRemoveUpdatedInstance(t *testing.T) {
+	store, cleanup := setupInvocationStore(t)
+	defer cleanup()
+
+	store.Save("b", InvocationCounts{"echo": {"2xx": 5}})
+	saved, _ := store.Load()
+
+	time.Sleep(time.Millisecond)
+	store.Save("b", InvocationCounts{"echo": {"2xx": 6}})
+
+	removed, err := store.Remove("b", saved["b"])
+
+	assert.Nil(t, err)
+	assert.False(t, removed)
+}
@@ -0,0 +1,17 @@
+package nomad
+
+import (
+	"io"
+
+	"github.com/hashicorp/nomad/api"
+)
+
+// Allocations is an interface for the Nomad allocations API
+type Allocations interface {
+	Info(allocID string, q *api.QueryOptions) (*api.Allocation, *api.QueryMeta, error)
+}
+
+// AllocFS is an interface for reading files from an allocation
+type AllocFS interface {
+	Cat(alloc *api.Allocation, path string, q *api.QueryOptions) (io.ReadCloser, error)
+}
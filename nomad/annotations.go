@@ -0,0 +1,99 @@
+package nomad
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// envNamePattern matches the environment variable names which can be set
+// from a secret
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Function annotations which control how a function job is created
+const (
+	// SecretEnvAnnotation maps environment variables to secrets,
+	// e.g. "DB_PASSWORD=db_password,API_KEY=api_key"
+	SecretEnvAnnotation = "com.hashicorp.nomad.secrets.env"
+	// SecretChangeModeAnnotation sets the action taken when a secret changes, restart, signal or noop
+	SecretChangeModeAnnotation = "com.hashicorp.nomad.secrets.change_mode"
+	// SecretChangeSignalAnnotation sets the signal sent when the change mode is signal
+	SecretChangeSignalAnnotation = "com.hashicorp.nomad.secrets.change_signal"
+	// JobTemplateAnnotation selects a named job template which is merged into the function job
+	JobTemplateAnnotation = "com.hashicorp.nomad.job_template"
+	// ScheduleAnnotation is a cron expression which makes the function a periodic batch job
+	ScheduleAnnotation = "com.hashicorp.nomad.schedule"
+	// ProhibitOverlapAnnotation prevents a scheduled function starting while the previous run is active, defaults to true
+	ProhibitOverlapAnnotation = "com.hashicorp.nomad.prohibit_overlap"
+	// TimeZoneAnnotation is the time zone the schedule is evaluated in, defaults to UTC
+	TimeZoneAnnotation = "com.hashicorp.nomad.time_zone"
+	// NextLaunchAnnotation is reported by the reader with the next time a scheduled function runs
+	NextLaunchAnnotation = "com.hashicorp.nomad.next_launch"
+	// DispatchAnnotation makes the function a parameterized batch job which is
+	// dispatched for each invocation, set to true to enable
+	DispatchAnnotation = "com.hashicorp.nomad.dispatch"
+	// VolumesAnnotation mounts volumes from the operator allowlist,
+	// e.g. "models:/models:ro,scratch:/scratch"
+	VolumesAnnotation = "com.hashicorp.nomad.volumes"
+	// ConnectAnnotation deploys the function with a Consul Connect sidecar
+	// proxy, set to true to enable
+	ConnectAnnotation = "com.hashicorp.nomad.connect"
+)
+
+// FunctionSpecMeta is the job meta key which stores the versioned deploy
+// request of the function, it is not returned as an annotation
+const FunctionSpecMeta = "com.hashicorp.nomad.function_spec"
+
+// ParseSecretEnv returns the environment variable to secret name mapping
+// from the function annotations
+func ParseSecretEnv(annotations map[string]string) (map[string]string, error) {
+	env := map[string]string{}
+
+	value := strings.TrimSpace(annotations[SecretEnvAnnotation])
+	if value == "" {
+		return env, nil
+	}
+
+	for _, pair := range strings.Split(value, ",") {
+		kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("Invalid %s annotation, expected ENV_VAR=secret_name but got %q", SecretEnvAnnotation, pair)
+		}
+
+		if !envNamePattern.MatchString(kv[0]) {
+			return nil, fmt.Errorf("Invalid %s annotation, %q is not a valid environment variable name", SecretEnvAnnotation, kv[0])
+		}
+
+		env[kv[0]] = kv[1]
+	}
+
+	return env, nil
+}
+
+// parseBoolAnnotation returns the value of an optional true or false annotation
+func parseBoolAnnotation(annotations map[string]string, name string) (bool, error) {
+	v := strings.TrimSpace(annotations[name])
+	if v == "" {
+		return false, nil
+	}
+
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("Invalid %s annotation, expected true or false but got %q", name, v)
+	}
+
+	return b, nil
+}
+
+// SortedKeys returns the keys of a string map in a stable order
+func SortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,17 @@
+package nomad
+
+// ConnectFunctionPort is the port the function listens on inside its network
+// namespace, the Connect sidecar forwards requests to it
+const ConnectFunctionPort = "8080"
+
+// ParseConnect returns true when the ConnectAnnotation deploys the function
+// with a Connect sidecar
+func ParseConnect(annotations map[string]string) (bool, error) {
+	return parseBoolAnnotation(annotations, ConnectAnnotation)
+}
+
+// ConnectProxyTaskName returns the name of the Connect sidecar task which
+// Nomad injects for a function
+func ConnectProxyTaskName(function string) string {
+	return "connect-proxy-" + function
+}
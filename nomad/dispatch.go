@@ -0,0 +1,26 @@
+package nomad
+
+import "strings"
+
+const (
+	// DispatchPayloadFile is the file in the task local directory which
+	// contains the request body of a dispatched invocation
+	DispatchPayloadFile = "request"
+	// DispatchOutputPath is the path in the allocation filesystem which the
+	// output of a dispatched invocation is written to
+	DispatchOutputPath = "alloc/output"
+	// DispatchPayloadLimit is the largest payload Nomad accepts for a dispatch
+	DispatchPayloadLimit = 16 * 1024
+)
+
+// ParseDispatch returns true when the DispatchAnnotation makes the function a
+// parameterized job
+func ParseDispatch(annotations map[string]string) (bool, error) {
+	return parseBoolAnnotation(annotations, DispatchAnnotation)
+}
+
+// DispatchID returns the ID of a dispatched job relative to its parent, e.g.
+// dispatch-1528453553-0aa3b2f8 for OpenFaaS-report/dispatch-1528453553-0aa3b2f8
+func DispatchID(parentID, jobID string) string {
+	return strings.TrimPrefix(jobID, parentID+"/")
+}
@@ -0,0 +1,33 @@
+package nomad
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDispatchDefaultsToFalse(t *testing.T) {
+	dispatch, err := ParseDispatch(map[string]string{})
+
+	assert.Nil(t, err)
+	assert.False(t, dispatch)
+}
+
+func TestParseDispatchReturnsTrueWhenEnabled(t *testing.T) {
+	dispatch, err := ParseDispatch(map[string]string{DispatchAnnotation: "true"})
+
+	assert.Nil(t, err)
+	assert.True(t, dispatch)
+}
+
+func TestParseDispatchReturnsErrorWhenInvalid(t *testing.T) {
+	_, err := ParseDispatch(map[string]string{DispatchAnnotation: "sometimes"})
+
+	assert.NotNil(t, err)
+}
+
+func TestDispatchIDIsRelativeToParent(t *testing.T) {
+	id := DispatchID("OpenFaaS-report", "OpenFaaS-report/dispatch-1528453553-0aa3b2f8")
+
+	assert.Equal(t, "dispatch-1528453553-0aa3b2f8", id)
+}
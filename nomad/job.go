@@ -9,8 +9,12 @@ const JobPrefix = "OpenFaaS-"
 type Job interface {
 	// Register creates a new Nomad job
 	Register(*api.Job, *api.WriteOptions) (*api.JobRegisterResponse, *api.WriteMeta, error)
+	// Validate checks a job without registering it
+	Validate(*api.Job, *api.WriteOptions) (*api.JobValidateResponse, *api.WriteMeta, error)
 	Info(jobID string, q *api.QueryOptions) (*api.Job, *api.QueryMeta, error)
 	List(q *api.QueryOptions) ([]*api.JobListStub, *api.QueryMeta, error)
 	Deregister(jobID string, purge bool, q *api.WriteOptions) (string, *api.WriteMeta, error)
 	Allocations(jobID string, allAllocs bool, q *api.QueryOptions) ([]*api.AllocationListStub, *api.QueryMeta, error)
+	// Dispatch launches a child job of a parameterized job
+	Dispatch(jobID string, meta map[string]string, payload []byte, q *api.WriteOptions) (*api.JobDispatchResponse, *api.WriteMeta, error)
 }
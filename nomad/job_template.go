@@ -0,0 +1,470 @@
+package nomad
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/nomad/api"
+)
+
+// protectedFields are the fields of a generated job which are owned by the
+// provider and can not be set by a template
+var protectedFields = map[string][]string{
+	"job":            {"ID", "Name", "Type", "TaskGroups"},
+	"group":          {"Name", "Count", "Tasks", "Networks"},
+	"task":           {"Name", "Driver"},
+	"task.Config":    {"image", "port_map"},
+	"task.Resources": {"Networks"},
+}
+
+// appendedLists are the lists in a template which are appended to the
+// generated list rather than replacing it, so that the secrets, volumes and
+// services of a function are kept
+var appendedLists = map[string]bool{
+	"Constraints":  true,
+	"Templates":    true,
+	"VolumeMounts": true,
+	"Services":     true,
+	"volumes":      true,
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// JobTemplate is an operator supplied fragment which is deep merged into a
+// generated function job. The job block is merged into the job, the group
+// block into every task group and the task block into every task.
+type JobTemplate struct {
+	Name  string
+	Job   map[string]interface{}
+	Group map[string]interface{}
+	Task  map[string]interface{}
+}
+
+// LoadJobTemplate reads a job template from an HCL or JSON file, the
+// template is named after the file without its extension
+func LoadJobTemplate(file string) (*JobTemplate, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	name := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+
+	return ParseJobTemplate(name, string(data))
+}
+
+// ParseJobTemplate parses and validates an HCL or JSON job template, field
+// names match the Nomad API and may also be written in snake case
+func ParseJobTemplate(name, data string) (*JobTemplate, error) {
+	raw := map[string]interface{}{}
+	if err := hcl.Decode(&raw, data); err != nil {
+		return nil, fmt.Errorf("Unable to parse job template %s: %s", name, err)
+	}
+
+	t := &JobTemplate{Name: name}
+	blocks := map[string]struct {
+		dest *map[string]interface{}
+		typ  reflect.Type
+	}{
+		"job":   {&t.Job, reflect.TypeOf(api.Job{})},
+		"group": {&t.Group, reflect.TypeOf(api.TaskGroup{})},
+		"task":  {&t.Task, reflect.TypeOf(api.Task{})},
+	}
+
+	for k, v := range raw {
+		block, ok := blocks[k]
+		if !ok {
+			return nil, fmt.Errorf("Job template %s has unknown block %q, expected job, group or task", name, k)
+		}
+
+		n, err := normalize(v, block.typ, k)
+		if err != nil {
+			return nil, fmt.Errorf("Job template %s is invalid: %s", name, err)
+		}
+
+		*block.dest = n.(map[string]interface{})
+	}
+
+	for path, fields := range protectedFields {
+		if err := checkProtected(t, path, fields); err != nil {
+			return nil, fmt.Errorf("Job template %s is invalid: %s", name, err)
+		}
+	}
+
+	return t, nil
+}
+
+// Apply deep merges the template into the job, maps are merged, the
+// appendedLists are appended and other lists and scalar values replace the
+// generated values
+func (t *JobTemplate) Apply(job *api.Job) error {
+	base, err := toMap(job)
+	if err != nil {
+		return err
+	}
+
+	merge(base, t.Job)
+
+	groups, _ := base["TaskGroups"].([]interface{})
+	for _, g := range groups {
+		group, ok := g.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		merge(group, t.Group)
+
+		tasks, _ := group["Tasks"].([]interface{})
+		for _, tk := range tasks {
+			if task, ok := tk.(map[string]interface{}); ok {
+				merge(task, t.Task)
+			}
+		}
+	}
+
+	data, err := json.Marshal(base)
+	if err != nil {
+		return err
+	}
+
+	merged := &api.Job{}
+	if err := json.Unmarshal(data, merged); err != nil {
+		return fmt.Errorf("Unable to apply job template %s: %s", t.Name, err)
+	}
+
+	*job = *merged
+
+	return nil
+}
+
+// JobTemplates holds the default template which is applied to every job and
+// the named templates which are selected with the JobTemplateAnnotation
+type JobTemplates struct {
+	Default *JobTemplate
+	Named   map[string]*JobTemplate
+
+	mutex sync.RWMutex
+}
+
+// LoadJobTemplates loads the default template file and every .hcl and .json
+// file in dir as a named template, either can be empty
+func LoadJobTemplates(defaultFile, dir string) (*JobTemplates, error) {
+	t := &JobTemplates{Named: map[string]*JobTemplate{}}
+
+	if defaultFile != "" {
+		d, err := LoadJobTemplate(defaultFile)
+		if err != nil {
+			return nil, err
+		}
+
+		t.Default = d
+	}
+
+	if dir == "" {
+		return t, nil
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range files {
+		ext := filepath.Ext(f.Name())
+		if f.IsDir() || (ext != ".hcl" && ext != ".json") {
+			continue
+		}
+
+		named, err := LoadJobTemplate(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := t.Named[named.Name]; ok {
+			return nil, fmt.Errorf("Duplicate job template %s in %s", named.Name, dir)
+		}
+
+		t.Named[named.Name] = named
+	}
+
+	return t, nil
+}
+
+// Apply merges the default template and then the template named by the
+// function's JobTemplateAnnotation into the job, the named template takes
+// precedence. An error is returned when the named template does not exist.
+func (t *JobTemplates) Apply(job *api.Job, annotations map[string]string) error {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	if t.Default != nil {
+		if err := t.Default.Apply(job); err != nil {
+			return err
+		}
+	}
+
+	name := strings.TrimSpace(annotations[JobTemplateAnnotation])
+	if name == "" {
+		return nil
+	}
+
+	named, ok := t.Named[name]
+	if !ok {
+		return fmt.Errorf("Unknown job template %q in %s annotation", name, JobTemplateAnnotation)
+	}
+
+	return named.Apply(job)
+}
+
+// Replace replaces the templates with those of another set, it allows the
+// templates to be reloaded while functions are being deployed
+func (t *JobTemplates) Replace(other *JobTemplates) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.Default = other.Default
+	t.Named = other.Named
+}
+
+// Len returns the number of templates which have been loaded
+func (t *JobTemplates) Len() int {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	n := len(t.Named)
+	if t.Default != nil {
+		n++
+	}
+
+	return n
+}
+
+// normalize converts a decoded HCL or JSON value to the shape of the Nomad
+// API type so that it can be merged with the JSON encoding of a job. HCL
+// decodes blocks as lists of objects which are collapsed for structs and
+// maps, field names are matched to the Go field names and durations may be
+// written as strings e.g. "10s".
+func normalize(v interface{}, t reflect.Type, path string) (interface{}, error) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t == durationType {
+		if s, ok := v.(string); ok {
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				return nil, fmt.Errorf("%s is not a valid duration: %s", path, err)
+			}
+
+			return int64(d), nil
+		}
+
+		return v, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		obj, err := collapse(v, path)
+		if err != nil {
+			return nil, err
+		}
+
+		out := map[string]interface{}{}
+		for k, fv := range obj {
+			field, ok := fieldByName(t, k)
+			if !ok {
+				return nil, fmt.Errorf("unknown field %s.%s", path, k)
+			}
+
+			n, err := normalize(fv, field.Type, path+"."+field.Name)
+			if err != nil {
+				return nil, err
+			}
+
+			out[field.Name] = n
+		}
+
+		return out, nil
+	case reflect.Map:
+		obj, err := collapse(v, path)
+		if err != nil {
+			return nil, err
+		}
+
+		out := map[string]interface{}{}
+		for k, mv := range obj {
+			if t.Elem().Kind() == reflect.Interface {
+				out[k] = mv
+				continue
+			}
+
+			n, err := normalize(mv, t.Elem(), path+"."+k)
+			if err != nil {
+				return nil, err
+			}
+
+			out[k] = n
+		}
+
+		return out, nil
+	case reflect.Slice:
+		items := []interface{}{}
+		switch list := v.(type) {
+		case []interface{}:
+			items = list
+		case []map[string]interface{}:
+			for _, i := range list {
+				items = append(items, i)
+			}
+		default:
+			return nil, fmt.Errorf("%s must be a list", path)
+		}
+
+		out := []interface{}{}
+		for i, item := range items {
+			n, err := normalize(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+
+			out = append(out, n)
+		}
+
+		return out, nil
+	}
+
+	return v, nil
+}
+
+// collapse merges the list of objects HCL creates for a block into one object
+func collapse(v interface{}, path string) (map[string]interface{}, error) {
+	switch obj := v.(type) {
+	case map[string]interface{}:
+		return obj, nil
+	case []map[string]interface{}:
+		out := map[string]interface{}{}
+		for _, o := range obj {
+			for k, v := range o {
+				out[k] = v
+			}
+		}
+
+		return out, nil
+	}
+
+	return nil, fmt.Errorf("%s must be an object", path)
+}
+
+// fieldByName finds a struct field ignoring case and underscores so that
+// both MaxFiles and max_files match, lists can also be written as repeated
+// singular blocks e.g. constraint { } for Constraints
+func fieldByName(t reflect.Type, name string) (reflect.StructField, bool) {
+	key := strings.ToLower(strings.Replace(name, "_", "", -1))
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		fieldName := strings.ToLower(f.Name)
+		if fieldName == key || (f.Type.Kind() == reflect.Slice && fieldName == key+"s") {
+			return f, true
+		}
+	}
+
+	return reflect.StructField{}, false
+}
+
+func checkProtected(t *JobTemplate, path string, fields []string) error {
+	blocks := map[string]map[string]interface{}{"job": t.Job, "group": t.Group, "task": t.Task}
+
+	parts := strings.SplitN(path, ".", 2)
+	obj := blocks[parts[0]]
+	if len(parts) == 2 {
+		obj, _ = obj[parts[1]].(map[string]interface{})
+	}
+
+	for _, f := range fields {
+		if _, ok := obj[f]; ok {
+			return fmt.Errorf("%s.%s is set by the provider and can not be changed", path, f)
+		}
+	}
+
+	return nil
+}
+
+func toMap(job *api.Job) (map[string]interface{}, error) {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return nil, err
+	}
+
+	// numbers are kept as json.Number so that large integers are not changed
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+
+	m := map[string]interface{}{}
+	if err := d.Decode(&m); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func merge(base, overlay map[string]interface{}) {
+	for k, ov := range overlay {
+		switch o := ov.(type) {
+		case map[string]interface{}:
+			if b, ok := base[k].(map[string]interface{}); ok {
+				merge(b, o)
+				continue
+			}
+		case []interface{}:
+			if b, ok := base[k].([]interface{}); ok && appendedLists[k] {
+				base[k] = append(b, deepCopy(o).([]interface{})...)
+				continue
+			}
+		}
+
+		base[k] = deepCopy(ov)
+	}
+}
+
+// deepCopy copies the maps and lists in a template value so that merged jobs
+// never share them with the template
+func deepCopy(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(val))
+		for k, i := range val {
+			out[k] = deepCopy(i)
+		}
+
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(val))
+		for k, i := range val {
+			out[k] = deepCopy(i)
+		}
+
+		return out
+	case []map[string]interface{}:
+		out := make([]map[string]interface{}, len(val))
+		for k, i := range val {
+			out[k] = deepCopy(i).(map[string]interface{})
+		}
+
+		return out
+	}
+
+	return v
+}
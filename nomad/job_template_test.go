@@ -0,0 +1,235 @@
+package nomad
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/stretchr/testify/assert"
+)
+
+var testJobTemplate = `
+job {
+  priority = 70
+
+  meta {
+    team = "platform"
+  }
+
+  constraint {
+    l_target = "${node.class}"
+    operand  = "="
+    r_target = "functions"
+  }
+}
+
+group {
+  restart_policy {
+    attempts = 5
+    delay    = "10s"
+  }
+}
+
+task {
+  kill_timeout = "20s"
+
+  log_config {
+    max_files = 10
+  }
+
+  config {
+    dns_servers = ["10.0.0.2"]
+  }
+
+  env {
+    REGION = "eu-west"
+  }
+}
+`
+
+func createTestJob() *api.Job {
+	name := "OpenFaaS-figlet"
+	task := api.NewTask("figlet", "docker")
+	task.Config = map[string]interface{}{"image": "functions/figlet"}
+	task.Env = map[string]string{"fprocess": "figlet"}
+	task.LogConfig = &api.LogConfig{MaxFiles: intPtr(5), MaxFileSizeMB: intPtr(2)}
+
+	attempts, delay, mode := 25, time.Second, "delay"
+	group := api.NewTaskGroup("figlet", 1)
+	group.RestartPolicy = &api.RestartPolicy{Attempts: &attempts, Delay: &delay, Mode: &mode}
+	group.Tasks = []*api.Task{task}
+
+	job := api.NewServiceJob(name, name, "global", 1)
+	job.Constraints = []*api.Constraint{api.NewConstraint("${attr.cpu.arch}", "=", "amd64")}
+	job.Meta = map[string]string{"com.openfaas.test": "true"}
+	job.TaskGroups = []*api.TaskGroup{group}
+
+	return job
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func TestParseJobTemplateNormalizesFields(t *testing.T) {
+	tmpl, err := ParseJobTemplate("default", testJobTemplate)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 70, tmpl.Job["Priority"])
+	assert.Equal(t, int64(20*time.Second), tmpl.Task["KillTimeout"])
+	assert.Equal(t, map[string]interface{}{"MaxFiles": 10}, tmpl.Task["LogConfig"])
+	assert.Len(t, tmpl.Job["Constraints"], 1)
+}
+
+func TestParseJobTemplateAcceptsJSON(t *testing.T) {
+	tmpl, err := ParseJobTemplate("default", `{"group": {"EphemeralDisk": {"SizeMB": 50}}}`)
+
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"SizeMB": 50}, tmpl.Group["EphemeralDisk"])
+}
+
+func TestParseJobTemplateRejectsInvalidTemplates(t *testing.T) {
+	for _, tmpl := range []string{
+		`job { id = "other" }`,
+		`group { count = 3 }`,
+		`task { driver = "exec" }`,
+		`task { config { image = "other" } }`,
+		`task { unknown = 1 }`,
+		`task { kill_timeout = "soon" }`,
+		`service { name = "x" }`,
+		`group { network { mode = "host" } }`,
+		`task { resources { network { mbits = 10 } } }`,
+	} {
+		_, err := ParseJobTemplate("invalid", tmpl)
+		assert.NotNil(t, err, tmpl)
+	}
+}
+
+func TestApplyJobTemplateMergesIntoJob(t *testing.T) {
+	tmpl, _ := ParseJobTemplate("default", testJobTemplate)
+	job := createTestJob()
+
+	err := tmpl.Apply(job)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 70, *job.Priority)
+	assert.Equal(t, "platform", job.Meta["team"])
+	assert.Equal(t, "true", job.Meta["com.openfaas.test"])
+	assert.Len(t, job.Constraints, 2)
+
+	group := job.TaskGroups[0]
+	assert.Equal(t, "figlet", *group.Name)
+	assert.Equal(t, 5, *group.RestartPolicy.Attempts)
+	assert.Equal(t, 10*time.Second, *group.RestartPolicy.Delay)
+	assert.Equal(t, "delay", *group.RestartPolicy.Mode)
+
+	task := group.Tasks[0]
+	assert.Equal(t, 20*time.Second, *task.KillTimeout)
+	assert.Equal(t, 10, *task.LogConfig.MaxFiles)
+	assert.Equal(t, 2, *task.LogConfig.MaxFileSizeMB)
+	assert.Equal(t, "functions/figlet", task.Config["image"])
+	assert.Equal(t, []interface{}{"10.0.0.2"}, task.Config["dns_servers"])
+	assert.Equal(t, map[string]string{"fprocess": "figlet", "REGION": "eu-west"}, task.Env)
+}
+
+func TestApplyJobTemplateKeepsFunctionSecretsAndVolumes(t *testing.T) {
+	tmpl, err := ParseJobTemplate("default", `
+task {
+  template {
+    dest_path     = "local/ca.pem"
+    embedded_tmpl = "{{ key \"ca\" }}"
+  }
+
+  volume_mount {
+    volume      = "logs"
+    destination = "/logs"
+  }
+
+  config {
+    volumes = ["/etc/ssl:/etc/ssl:ro"]
+  }
+}
+`)
+	assert.Nil(t, err)
+
+	job := createTestJob()
+	task := job.TaskGroups[0].Tasks[0]
+	secretPath, volume, destination := SecretDestPrefix+"db_password", "models", "/models"
+	task.Templates = []*api.Template{&api.Template{DestPath: &secretPath}}
+	task.VolumeMounts = []*api.VolumeMount{&api.VolumeMount{Volume: &volume, Destination: &destination}}
+	task.Config["volumes"] = []string{"secrets/db_password:/var/openfaas/secrets/db_password"}
+
+	err = tmpl.Apply(job)
+
+	assert.Nil(t, err)
+	task = job.TaskGroups[0].Tasks[0]
+	assert.Len(t, task.Templates, 2)
+	assert.Equal(t, secretPath, *task.Templates[0].DestPath)
+	assert.Equal(t, "local/ca.pem", *task.Templates[1].DestPath)
+	assert.Len(t, task.VolumeMounts, 2)
+	assert.Equal(t, "models", *task.VolumeMounts[0].Volume)
+	assert.Equal(t, []interface{}{"secrets/db_password:/var/openfaas/secrets/db_password", "/etc/ssl:/etc/ssl:ro"}, task.Config["volumes"])
+}
+
+func TestApplyJobTemplateDoesNotShareValues(t *testing.T) {
+	tmpl, _ := ParseJobTemplate("default", testJobTemplate)
+
+	first := createTestJob()
+	tmpl.Apply(first)
+	first.Meta["team"] = "changed"
+
+	second := createTestJob()
+	tmpl.Apply(second)
+
+	assert.Equal(t, "platform", second.Meta["team"])
+	assert.Len(t, second.Constraints, 2)
+}
+
+func setupJobTemplates(t *testing.T) (*JobTemplates, func()) {
+	dir, err := ioutil.TempDir("", "faas-nomad-templates")
+	assert.Nil(t, err)
+
+	ioutil.WriteFile(filepath.Join(dir, "default.hcl"), []byte(testJobTemplate), 0600)
+	os.Mkdir(filepath.Join(dir, "named"), 0700)
+	ioutil.WriteFile(filepath.Join(dir, "named", "gpu.hcl"), []byte(`job { priority = 90 }`), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "named", "batch.json"), []byte(`{"group": {"RestartPolicy": {"Attempts": 1}}}`), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "named", "README.md"), []byte(`ignored`), 0600)
+
+	templates, err := LoadJobTemplates(filepath.Join(dir, "default.hcl"), filepath.Join(dir, "named"))
+	assert.Nil(t, err)
+
+	return templates, func() { os.RemoveAll(dir) }
+}
+
+func TestLoadJobTemplatesLoadsDefaultAndNamed(t *testing.T) {
+	templates, cleanup := setupJobTemplates(t)
+	defer cleanup()
+
+	assert.Equal(t, 3, templates.Len())
+	assert.Contains(t, templates.Named, "gpu")
+	assert.Contains(t, templates.Named, "batch")
+}
+
+func TestApplyJobTemplatesNamedTemplateTakesPrecedence(t *testing.T) {
+	templates, cleanup := setupJobTemplates(t)
+	defer cleanup()
+
+	job := createTestJob()
+	err := templates.Apply(job, map[string]string{JobTemplateAnnotation: "gpu"})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 90, *job.Priority)
+	assert.Equal(t, "platform", job.Meta["team"])
+}
+
+func TestApplyJobTemplatesReturnsErrorForUnknownTemplate(t *testing.T) {
+	templates, cleanup := setupJobTemplates(t)
+	defer cleanup()
+
+	err := templates.Apply(createTestJob(), map[string]string{JobTemplateAnnotation: "unknown"})
+
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,47 @@
+package nomad
+
+import (
+	"io"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/stretchr/testify/mock"
+)
+
+// MockAllocations is a mock implementation of the Allocations interface
+type MockAllocations struct {
+	mock.Mock
+}
+
+// Info returns mock info from the allocations API
+func (m *MockAllocations) Info(allocID string, q *api.QueryOptions) (*api.Allocation, *api.QueryMeta, error) {
+	args := m.Called(allocID, q)
+
+	var alloc *api.Allocation
+	if a := args.Get(0); a != nil {
+		alloc = a.(*api.Allocation)
+	}
+
+	var meta *api.QueryMeta
+	if r := args.Get(1); r != nil {
+		meta = r.(*api.QueryMeta)
+	}
+
+	return alloc, meta, args.Error(2)
+}
+
+// MockAllocFS is a mock implementation of the AllocFS interface
+type MockAllocFS struct {
+	mock.Mock
+}
+
+// Cat returns a mock file from the allocation filesystem
+func (m *MockAllocFS) Cat(alloc *api.Allocation, path string, q *api.QueryOptions) (io.ReadCloser, error) {
+	args := m.Called(alloc, path, q)
+
+	var r io.ReadCloser
+	if f := args.Get(0); f != nil {
+		r = f.(io.ReadCloser)
+	}
+
+	return r, args.Error(1)
+}
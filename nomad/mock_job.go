@@ -31,6 +31,23 @@ func (m *MockJob) Register(job *api.Job, options *api.WriteOptions) (
 
 }
 
+// Validate is a mock implementation of the validate interface method
+func (m *MockJob) Validate(job *api.Job, options *api.WriteOptions) (*api.JobValidateResponse, *api.WriteMeta, error) {
+	args := m.Called(job, options)
+
+	var resp *api.JobValidateResponse
+	if r := args.Get(0); r != nil {
+		resp = r.(*api.JobValidateResponse)
+	}
+
+	var meta *api.WriteMeta
+	if r := args.Get(1); r != nil {
+		meta = r.(*api.WriteMeta)
+	}
+
+	return resp, meta, args.Error(2)
+}
+
 // Info returns mock info from the job API
 func (m *MockJob) Info(jobID string, q *api.QueryOptions) (*api.Job, *api.QueryMeta, error) {
 	args := m.Called(jobID, q)
@@ -89,3 +106,20 @@ func (m *MockJob) Allocations(jobID string, allAllocs bool, q *api.QueryOptions)
 
 	return allocs, meta, args.Error(2)
 }
+
+// Dispatch is a mock implementation of the interface method
+func (m *MockJob) Dispatch(jobID string, meta map[string]string, payload []byte, q *api.WriteOptions) (*api.JobDispatchResponse, *api.WriteMeta, error) {
+	args := m.Called(jobID, meta, payload, q)
+
+	var resp *api.JobDispatchResponse
+	if r := args.Get(0); r != nil {
+		resp = r.(*api.JobDispatchResponse)
+	}
+
+	var wm *api.WriteMeta
+	if r := args.Get(1); r != nil {
+		wm = r.(*api.WriteMeta)
+	}
+
+	return resp, wm, args.Error(2)
+}
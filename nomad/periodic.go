@@ -0,0 +1,86 @@
+package nomad
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+)
+
+// ParsePeriodic returns the periodic config for a scheduled function, nil is
+// returned when the function does not have a ScheduleAnnotation
+func ParsePeriodic(annotations map[string]string) (*api.PeriodicConfig, error) {
+	spec := strings.TrimSpace(annotations[ScheduleAnnotation])
+	if spec == "" {
+		return nil, nil
+	}
+
+	prohibitOverlap := true
+	if v, ok := annotations[ProhibitOverlapAnnotation]; ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s annotation, expected true or false but got %q", ProhibitOverlapAnnotation, v)
+		}
+
+		prohibitOverlap = b
+	}
+
+	enabled := true
+	specType := api.PeriodicSpecCron
+	timeZone := strings.TrimSpace(annotations[TimeZoneAnnotation])
+	if timeZone == "" {
+		timeZone = "UTC"
+	}
+
+	periodic := &api.PeriodicConfig{
+		Enabled:         &enabled,
+		Spec:            &spec,
+		SpecType:        &specType,
+		ProhibitOverlap: &prohibitOverlap,
+		TimeZone:        &timeZone,
+	}
+
+	if _, err := periodic.GetLocation(); err != nil {
+		return nil, fmt.Errorf("Invalid %s annotation: %s", TimeZoneAnnotation, err)
+	}
+
+	if next, err := periodic.Next(time.Now()); err != nil || next.IsZero() {
+		return nil, fmt.Errorf("Invalid %s annotation, %q is not a valid cron expression", ScheduleAnnotation, spec)
+	}
+
+	return periodic, nil
+}
+
+// NextLaunch returns the next time a periodic job will run, the zero time is
+// returned when the job is not periodic or its schedule is disabled
+func NextLaunch(job *api.Job, from time.Time) time.Time {
+	p := job.Periodic
+	if p == nil || p.Spec == nil || (p.Enabled != nil && !*p.Enabled) {
+		return time.Time{}
+	}
+
+	if p.SpecType == nil {
+		specType := api.PeriodicSpecCron
+		p = &api.PeriodicConfig{Spec: p.Spec, SpecType: &specType, TimeZone: p.TimeZone}
+	}
+
+	loc, err := p.GetLocation()
+	if err != nil {
+		loc = time.UTC
+	}
+
+	next, err := p.Next(from.In(loc))
+	if err != nil {
+		return time.Time{}
+	}
+
+	return next
+}
+
+// IsChildJob returns true for the jobs Nomad launches from a periodic or a
+// parameterized job, they are not functions in their own right
+func IsChildJob(j *api.JobListStub) bool {
+	return j.ParentID != ""
+}
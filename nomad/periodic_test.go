@@ -0,0 +1,67 @@
+package nomad
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePeriodicReturnsNilWithoutSchedule(t *testing.T) {
+	p, err := ParsePeriodic(map[string]string{})
+
+	assert.Nil(t, err)
+	assert.Nil(t, p)
+}
+
+func TestParsePeriodicCreatesCronConfig(t *testing.T) {
+	p, err := ParsePeriodic(map[string]string{ScheduleAnnotation: "*/5 * * * *"})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "*/5 * * * *", *p.Spec)
+	assert.Equal(t, api.PeriodicSpecCron, *p.SpecType)
+	assert.True(t, *p.ProhibitOverlap)
+	assert.Equal(t, "UTC", *p.TimeZone)
+}
+
+func TestParsePeriodicSetsOverlapAndTimeZone(t *testing.T) {
+	p, err := ParsePeriodic(map[string]string{
+		ScheduleAnnotation:        "0 9 * * *",
+		ProhibitOverlapAnnotation: "false",
+		TimeZoneAnnotation:        "Europe/London",
+	})
+
+	assert.Nil(t, err)
+	assert.False(t, *p.ProhibitOverlap)
+	assert.Equal(t, "Europe/London", *p.TimeZone)
+}
+
+func TestParsePeriodicReturnsErrorForInvalidAnnotations(t *testing.T) {
+	for _, a := range []map[string]string{
+		{ScheduleAnnotation: "every five minutes"},
+		{ScheduleAnnotation: "*/5 * * * *", ProhibitOverlapAnnotation: "sometimes"},
+		{ScheduleAnnotation: "*/5 * * * *", TimeZoneAnnotation: "Nowhere/Special"},
+	} {
+		_, err := ParsePeriodic(a)
+		assert.NotNil(t, err, a)
+	}
+}
+
+func TestNextLaunchReturnsNextScheduledTime(t *testing.T) {
+	p, _ := ParsePeriodic(map[string]string{ScheduleAnnotation: "0 * * * *"})
+	from := time.Date(2018, 10, 1, 12, 30, 0, 0, time.UTC)
+
+	next := NextLaunch(&api.Job{Periodic: p}, from)
+
+	assert.Equal(t, time.Date(2018, 10, 1, 13, 0, 0, 0, time.UTC), next)
+}
+
+func TestNextLaunchReturnsZeroWhenDisabled(t *testing.T) {
+	p, _ := ParsePeriodic(map[string]string{ScheduleAnnotation: "0 * * * *"})
+	enabled := false
+	p.Enabled = &enabled
+
+	assert.True(t, NextLaunch(&api.Job{Periodic: p}, time.Now()).IsZero())
+	assert.True(t, NextLaunch(&api.Job{}, time.Now()).IsZero())
+}
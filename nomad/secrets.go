@@ -0,0 +1,115 @@
+package nomad
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/nomad/api"
+)
+
+// SecretDestPrefix is the template destination used for function secrets
+const SecretDestPrefix = "secrets/"
+
+// SecretMountPath is the path secrets are mounted at inside a function
+const SecretMountPath = "/var/openfaas/secrets/"
+
+// JobSecrets returns the names of the secrets which are templated into a
+// function job, either as files, as environment variables or as registry
+// credentials, or which are mounted into the function by the task config
+func JobSecrets(job *api.Job) []string {
+	secrets := []string{}
+	if job == nil {
+		return secrets
+	}
+
+	seen := map[string]bool{}
+	add := func(s string) {
+		if !seen[s] {
+			seen[s] = true
+			secrets = append(secrets, s)
+		}
+	}
+
+	if env, err := ParseSecretEnv(job.Meta); err == nil {
+		for _, k := range SortedKeys(env) {
+			add(env[k])
+		}
+	}
+
+	for _, tg := range job.TaskGroups {
+		for _, t := range tg.Tasks {
+			for _, tmpl := range t.Templates {
+				if tmpl.DestPath == nil || !strings.HasPrefix(*tmpl.DestPath, SecretDestPrefix) {
+					continue
+				}
+
+				add(strings.TrimPrefix(*tmpl.DestPath, SecretDestPrefix))
+			}
+
+			for _, v := range taskVolumes(t) {
+				parts := strings.SplitN(v, ":", 2)
+				if len(parts) == 2 && strings.HasPrefix(parts[0], SecretDestPrefix) && strings.HasPrefix(parts[1], SecretMountPath) {
+					add(strings.TrimPrefix(parts[0], SecretDestPrefix))
+				}
+			}
+		}
+	}
+
+	return secrets
+}
+
+// SecretUsage returns the names of the functions which reference each
+// secret, keyed by secret name.  Functions scaled to zero are dead but still
+// use their secrets, only stopped jobs are skipped.
+func SecretUsage(client Job) (map[string][]string, error) {
+	options := &api.QueryOptions{}
+	options.Prefix = JobPrefix
+
+	jobs, _, err := client.List(options)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := map[string][]string{}
+	for _, j := range jobs {
+		if j.Stop || IsChildJob(j) {
+			continue
+		}
+
+		job, _, err := client.Info(j.ID, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		function := strings.TrimPrefix(j.ID, JobPrefix)
+		for _, s := range JobSecrets(job) {
+			usage[s] = append(usage[s], function)
+		}
+	}
+
+	for _, functions := range usage {
+		sort.Strings(functions)
+	}
+
+	return usage, nil
+}
+
+// taskVolumes returns the docker volumes in the task config, jobs created by
+// the provider contain a []string but jobs read from Nomad contain []interface{}
+func taskVolumes(t *api.Task) []string {
+	switch v := t.Config["volumes"].(type) {
+	case []string:
+		return v
+	case []interface{}:
+		volumes := []string{}
+		for _, i := range v {
+			if s, ok := i.(string); ok {
+				volumes = append(volumes, s)
+			}
+		}
+
+		return volumes
+	}
+
+	return nil
+}
@@ -0,0 +1,226 @@
+package nomad
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/faas-nomad/types"
+)
+
+// variable is the subset of a Nomad Variable used to store a secret
+type variable struct {
+	Path       string            `json:"Path"`
+	Items      map[string]string `json:"Items,omitempty"`
+	CreateTime int64             `json:"CreateTime,omitempty"`
+	ModifyTime int64             `json:"ModifyTime,omitempty"`
+}
+
+// VariablesSecretStore implements types.SecretStore using Nomad Variables,
+// secrets are rendered into functions with the nomadVar template function.
+// The Nomad API client vendored by the provider predates Variables so the
+// HTTP API is called directly.
+type VariablesSecretStore struct {
+	address string
+	prefix  string
+	client  *http.Client
+
+	// token is replaced when the configuration is reloaded
+	mutex sync.RWMutex
+	token string
+}
+
+// NewVariablesSecretStore creates a VariablesSecretStore which stores secrets
+// under the prefix, connecting to Nomad with the given config
+func NewVariablesSecretStore(config types.NomadConfig, prefix string) (*VariablesSecretStore, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+
+	if config.TLSEnabled {
+		tlsConfig, err := variablesTLSConfig(config)
+		if err != nil {
+			return nil, err
+		}
+
+		client.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+	}
+
+	address := config.Address
+	if !strings.Contains(address, "://") {
+		scheme := "http://"
+		if config.TLSEnabled {
+			scheme = "https://"
+		}
+
+		address = scheme + address
+	}
+
+	return &VariablesSecretStore{
+		address: strings.TrimSuffix(address, "/"),
+		token:   config.ACLToken,
+		prefix:  strings.Trim(prefix, "/"),
+		client:  client,
+	}, nil
+}
+
+// SetToken replaces the ACL token used by later requests
+func (s *VariablesSecretStore) SetToken(ACLToken string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.token = ACLToken
+}
+
+// ListSecrets returns the names of the secrets stored under the prefix
+func (s *VariablesSecretStore) ListSecrets() ([]string, error) {
+	vars := []variable{}
+	if err := s.do(http.MethodGet, "/v1/vars?prefix="+url.QueryEscape(s.prefix+"/"), nil, &vars); err != nil {
+		return nil, err
+	}
+
+	secrets := []string{}
+	for _, v := range vars {
+		name := strings.TrimPrefix(v.Path, s.prefix+"/")
+
+		// variables in nested paths are not secrets created by the provider
+		if name == "" || strings.Contains(name, "/") {
+			continue
+		}
+
+		secrets = append(secrets, name)
+	}
+
+	return secrets, nil
+}
+
+// GetSecretMetadata returns the created and modified times of a secret
+func (s *VariablesSecretStore) GetSecretMetadata(name string) (types.SecretMetadata, error) {
+	v := variable{}
+	if err := s.do(http.MethodGet, "/v1/var/"+s.path(name), nil, &v); err != nil {
+		return types.SecretMetadata{}, err
+	}
+
+	return types.SecretMetadata{
+		CreatedTime: formatTime(v.CreateTime),
+		UpdatedTime: formatTime(v.ModifyTime),
+	}, nil
+}
+
+// SetSecret creates or updates the value of a secret
+func (s *VariablesSecretStore) SetSecret(name, value string) error {
+	v := variable{Path: s.path(name), Items: map[string]string{"value": value}}
+
+	return s.do(http.MethodPut, "/v1/var/"+s.path(name), v, nil)
+}
+
+// DeleteSecret removes a secret
+func (s *VariablesSecretStore) DeleteSecret(name string) error {
+	return s.do(http.MethodDelete, "/v1/var/"+s.path(name), nil, nil)
+}
+
+// SecretTemplate returns the Nomad template which renders the value of a secret
+func (s *VariablesSecretStore) SecretTemplate(name string) string {
+	return fmt.Sprintf(`{{with nomadVar "%s"}}{{.value}}{{end}}`, s.path(name))
+}
+
+// SecretTemplateFormat returns the Nomad template which renders the value of
+// a secret through the format action
+func (s *VariablesSecretStore) SecretTemplateFormat(name, format string) string {
+	return fmt.Sprintf(`{{with nomadVar "%s"}}`, s.path(name)) + fmt.Sprintf(format, ".value") + "{{end}}"
+}
+
+// Policies returns nil, Nomad Variables do not need Vault policies
+func (s *VariablesSecretStore) Policies() []string {
+	return nil
+}
+
+func (s *VariablesSecretStore) path(name string) string {
+	return s.prefix + "/" + name
+}
+
+func (s *VariablesSecretStore) do(method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, s.address+path, body)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.RLock()
+	token := s.token
+	s.mutex.RUnlock()
+
+	if token != "" {
+		req.Header.Set("X-Nomad-Token", token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Error in request to Nomad: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("Nomad returned unexpected response: %v %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func formatTime(nanos int64) string {
+	if nanos == 0 {
+		return ""
+	}
+
+	return time.Unix(0, nanos).UTC().Format(time.RFC3339)
+}
+
+func variablesTLSConfig(config types.NomadConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: config.TLSSkipVerify}
+
+	if config.TLSCA != "" {
+		ca, err := ioutil.ReadFile(config.TLSCA)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("Unable to parse CA certificate %s", config.TLSCA)
+		}
+
+		tlsConfig.RootCAs = pool
+	}
+
+	if config.TLSCert != "" && config.TLSPrivateKey != "" {
+		cert, err := tls.LoadX509KeyPair(config.TLSCert, config.TLSPrivateKey)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,82 @@
+package nomad
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/faas-nomad/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupVariables(handler http.HandlerFunc) (*VariablesSecretStore, *httptest.Server) {
+	server := httptest.NewServer(handler)
+	store, _ := NewVariablesSecretStore(types.NomadConfig{Address: server.URL, ACLToken: "token"}, "openfaas/secrets")
+
+	return store, server
+}
+
+func TestVariablesSecretStoreListsSecrets(t *testing.T) {
+	store, server := setupVariables(func(rw http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/vars", r.URL.Path)
+		assert.Equal(t, "openfaas/secrets/", r.URL.Query().Get("prefix"))
+		assert.Equal(t, "token", r.Header.Get("X-Nomad-Token"))
+
+		rw.Write([]byte(`[{"Path":"openfaas/secrets/figlet"},{"Path":"openfaas/secrets/nested/other"}]`))
+	})
+	defer server.Close()
+
+	secrets, err := store.ListSecrets()
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"figlet"}, secrets)
+}
+
+func TestVariablesSecretStoreSetsSecret(t *testing.T) {
+	body := variable{}
+	store, server := setupVariables(func(rw http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPut, r.Method)
+		assert.Equal(t, "/v1/var/openfaas/secrets/figlet", r.URL.Path)
+		json.NewDecoder(r.Body).Decode(&body)
+
+		rw.Write([]byte(`{}`))
+	})
+	defer server.Close()
+
+	err := store.SetSecret("figlet", "abc")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "openfaas/secrets/figlet", body.Path)
+	assert.Equal(t, "abc", body.Items["value"])
+}
+
+func TestVariablesSecretStoreReturnsMetadata(t *testing.T) {
+	store, server := setupVariables(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Write([]byte(`{"Path":"openfaas/secrets/figlet","CreateTime":1543233600000000000,"ModifyTime":1543311000000000000}`))
+	})
+	defer server.Close()
+
+	metadata, err := store.GetSecretMetadata("figlet")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "2018-11-26T12:00:00Z", metadata.CreatedTime)
+	assert.Equal(t, "2018-11-27T09:30:00Z", metadata.UpdatedTime)
+}
+
+func TestVariablesSecretStoreReturnsErrorOnFailure(t *testing.T) {
+	store, server := setupVariables(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusForbidden)
+	})
+	defer server.Close()
+
+	assert.NotNil(t, store.DeleteSecret("figlet"))
+}
+
+func TestVariablesSecretStoreRendersNomadVarTemplate(t *testing.T) {
+	store, _ := NewVariablesSecretStore(types.NomadConfig{Address: "localhost:4646"}, "openfaas/secrets")
+
+	assert.Equal(t, `{{with nomadVar "openfaas/secrets/figlet"}}{{.value}}{{end}}`, store.SecretTemplate("figlet"))
+	assert.Equal(t, `{{with nomadVar "openfaas/secrets/figlet"}}{{.value | toUpper}}{{end}}`, store.SecretTemplateFormat("figlet", "{{%s | toUpper}}"))
+	assert.Equal(t, "http://localhost:4646", store.address)
+}
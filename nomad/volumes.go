@@ -0,0 +1,234 @@
+package nomad
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/hcl/hcl/ast"
+	"github.com/hashicorp/nomad/api"
+)
+
+// Volume types which functions can mount
+const (
+	// VolumeTypeHost is a host_volume configured on the Nomad clients
+	VolumeTypeHost = "host"
+	// VolumeTypeCSI is a CSI volume registered with Nomad
+	VolumeTypeCSI = "csi"
+)
+
+// Volume is a volume which the operator allows functions to mount
+type Volume struct {
+	Name string `hcl:",key"`
+	// Type is host or csi
+	Type string `hcl:"type"`
+	// Source is the name of the client host_volume or the ID of the CSI volume
+	Source string `hcl:"source"`
+	// ReadOnly forces the volume to be mounted read only
+	ReadOnly bool `hcl:"read_only"`
+}
+
+// volumeKeys are the keys of a volume block
+var volumeKeys = map[string]bool{"type": true, "source": true, "read_only": true}
+
+// VolumeMount is a request from a function to mount a volume
+type VolumeMount struct {
+	Volume      string
+	Destination string
+	ReadOnly    bool
+}
+
+// Volumes is the allowlist of volumes which functions can mount with the
+// VolumesAnnotation. Volumes are added to the task group as volume blocks and
+// mounted into the function task with volume_mount blocks, so Nomad places
+// the function on a client which has the volume.
+type Volumes struct {
+	Volumes []*Volume `hcl:"volume"`
+
+	mutex  sync.RWMutex
+	byName map[string]*Volume
+}
+
+// LoadVolumes reads a volume allowlist from an HCL or JSON file
+func LoadVolumes(file string) (*Volumes, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseVolumes(string(data))
+}
+
+// ParseVolumes parses and validates an HCL or JSON volume allowlist
+func ParseVolumes(data string) (*Volumes, error) {
+	file, err := hcl.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse volumes: %s", err)
+	}
+
+	// HCL ignores keys which do not match a field, such as the driver
+	// setting of earlier versions, so they are rejected here
+	if err := checkVolumeKeys(file); err != nil {
+		return nil, err
+	}
+
+	v := &Volumes{}
+	if err := hcl.DecodeObject(v, file); err != nil {
+		return nil, fmt.Errorf("Unable to parse volumes: %s", err)
+	}
+
+	v.byName = map[string]*Volume{}
+	for _, vol := range v.Volumes {
+		if vol.Type != VolumeTypeHost && vol.Type != VolumeTypeCSI {
+			return nil, fmt.Errorf("Volume %q has unknown type %q, expected host or csi", vol.Name, vol.Type)
+		}
+
+		if vol.Source == "" || strings.Contains(vol.Source, "/") {
+			return nil, fmt.Errorf("Volume %q must have a source, the name of a client host_volume or the ID of a CSI volume", vol.Name)
+		}
+
+		if _, ok := v.byName[vol.Name]; ok {
+			return nil, fmt.Errorf("Volume %q is defined more than once", vol.Name)
+		}
+
+		v.byName[vol.Name] = vol
+	}
+
+	return v, nil
+}
+
+func checkVolumeKeys(file *ast.File) error {
+	list, ok := file.Node.(*ast.ObjectList)
+	if !ok {
+		return fmt.Errorf("Unable to parse volumes: expected volume blocks")
+	}
+
+	for _, item := range list.Items {
+		if key := item.Keys[0].Token.Value(); key != "volume" {
+			return fmt.Errorf("Unknown key %q in volumes, expected volume blocks", key)
+		}
+	}
+
+	for _, item := range list.Filter("volume").Items {
+		obj, ok := item.Val.(*ast.ObjectType)
+		if !ok || len(item.Keys) == 0 {
+			return fmt.Errorf("Unable to parse volumes: volume blocks must be named")
+		}
+
+		name := item.Keys[0].Token.Value()
+		for _, field := range obj.List.Items {
+			key, _ := field.Keys[0].Token.Value().(string)
+			if !volumeKeys[key] {
+				return fmt.Errorf("Volume %q has unknown key %q", name, key)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Replace replaces the allowlist with another, it allows the allowlist to be
+// reloaded while functions are being deployed
+func (v *Volumes) Replace(other *Volumes) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	v.Volumes = other.Volumes
+	v.byName = other.byName
+}
+
+// Len returns the number of volumes in the allowlist
+func (v *Volumes) Len() int {
+	v.mutex.RLock()
+	defer v.mutex.RUnlock()
+
+	return len(v.Volumes)
+}
+
+// Mount adds the volumes requested by the function annotations to the task
+// group and mounts them into the function task, volumes which are not in the
+// allowlist are rejected
+func (v *Volumes) Mount(group *api.TaskGroup, annotations map[string]string) error {
+	mounts, err := ParseVolumeMounts(annotations)
+	if err != nil {
+		return err
+	}
+
+	v.mutex.RLock()
+	defer v.mutex.RUnlock()
+
+	task := group.Tasks[0]
+	for _, m := range mounts {
+		vol, ok := v.byName[m.Volume]
+		if !ok {
+			return fmt.Errorf("Volume %q is not allowed", m.Volume)
+		}
+
+		if group.Volumes == nil {
+			group.Volumes = map[string]*api.VolumeRequest{}
+		}
+
+		group.Volumes[vol.Name] = &api.VolumeRequest{
+			Name:     vol.Name,
+			Type:     vol.Type,
+			Source:   vol.Source,
+			ReadOnly: vol.ReadOnly,
+		}
+
+		name, destination := vol.Name, m.Destination
+		readOnly := m.ReadOnly || vol.ReadOnly
+
+		task.VolumeMounts = append(task.VolumeMounts, &api.VolumeMount{
+			Volume:      &name,
+			Destination: &destination,
+			ReadOnly:    &readOnly,
+		})
+	}
+
+	return nil
+}
+
+// ParseVolumeMounts returns the mounts requested by the VolumesAnnotation,
+// e.g. "models:/models:ro,scratch:/scratch"
+func ParseVolumeMounts(annotations map[string]string) ([]VolumeMount, error) {
+	mounts := []VolumeMount{}
+
+	value := strings.TrimSpace(annotations[VolumesAnnotation])
+	if value == "" {
+		return mounts, nil
+	}
+
+	destinations := map[string]bool{}
+	for _, m := range strings.Split(value, ",") {
+		parts := strings.Split(strings.TrimSpace(m), ":")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || (len(parts) == 3 && parts[2] != "ro" && parts[2] != "rw") {
+			return nil, fmt.Errorf("Invalid %s annotation, expected volume:/destination[:ro] but got %q", VolumesAnnotation, m)
+		}
+
+		dest := path.Clean(parts[1])
+		if !path.IsAbs(parts[1]) {
+			return nil, fmt.Errorf("Invalid %s annotation, destination %q must be an absolute path", VolumesAnnotation, parts[1])
+		}
+
+		// volumes can not hide or replace the function secrets
+		if dest == "/" || strings.HasPrefix(dest+"/", SecretMountPath) || strings.HasPrefix(SecretMountPath, dest+"/") {
+			return nil, fmt.Errorf("Invalid %s annotation, destination %q overlaps the secrets mount", VolumesAnnotation, parts[1])
+		}
+
+		if destinations[dest] {
+			return nil, fmt.Errorf("Invalid %s annotation, destination %q is mounted more than once", VolumesAnnotation, parts[1])
+		}
+		destinations[dest] = true
+
+		mounts = append(mounts, VolumeMount{
+			Volume:      parts[0],
+			Destination: dest,
+			ReadOnly:    len(parts) == 3 && parts[2] == "ro",
+		})
+	}
+
+	return mounts, nil
+}
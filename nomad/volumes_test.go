@@ -0,0 +1,131 @@
+package nomad
+
+import (
+	"testing"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/stretchr/testify/assert"
+)
+
+const testVolumes = `
+volume "models" {
+  type      = "host"
+  source    = "models"
+  read_only = true
+}
+
+volume "scratch" {
+  type   = "host"
+  source = "scratch"
+}
+
+volume "datasets" {
+  type   = "csi"
+  source = "datasets-ebs"
+}
+`
+
+func setupVolumes(t *testing.T) *Volumes {
+	v, err := ParseVolumes(testVolumes)
+	assert.Nil(t, err)
+
+	return v
+}
+
+func TestParseVolumesRejectsInvalidVolumes(t *testing.T) {
+	volumes := map[string]string{
+		"unknown type":  `volume "a" { type = "nfs" source = "a" }`,
+		"path source":   `volume "a" { type = "host" source = "/a" }`,
+		"no source":     `volume "a" { type = "csi" }`,
+		"docker driver": `volume "a" { type = "csi" source = "a" driver = "rexray/ebs" }`,
+		"duplicate":     `volume "a" { type = "host" source = "a" } volume "a" { type = "host" source = "b" }`,
+		"unknown key":   `volume "a" { type = "host" source = "a" readonly = true }`,
+		"unnamed":       `volume { type = "host" source = "a" }`,
+		"unknown block": `volumes "a" { type = "host" source = "a" }`,
+	}
+
+	for name, data := range volumes {
+		_, err := ParseVolumes(data)
+		assert.NotNil(t, err, name)
+	}
+}
+
+func TestParseVolumesReadsJSON(t *testing.T) {
+	v, err := ParseVolumes(`{"volume": {"models": {"type": "host", "source": "models", "read_only": true}}}`)
+
+	assert.Nil(t, err)
+	assert.Len(t, v.Volumes, 1)
+	assert.Equal(t, "models", v.Volumes[0].Name)
+	assert.True(t, v.Volumes[0].ReadOnly)
+}
+
+func TestParseVolumeMountsReturnsMounts(t *testing.T) {
+	mounts, err := ParseVolumeMounts(map[string]string{VolumesAnnotation: "models:/models:ro, scratch:/tmp/scratch/"})
+
+	assert.Nil(t, err)
+	assert.Equal(t, []VolumeMount{
+		{Volume: "models", Destination: "/models", ReadOnly: true},
+		{Volume: "scratch", Destination: "/tmp/scratch"},
+	}, mounts)
+}
+
+func TestParseVolumeMountsRejectsInvalidMounts(t *testing.T) {
+	for _, value := range []string{"models", "models:models", "models:/models:rx", "a:/", "a:/var/openfaas/secrets/x", "a:/var", "a:/data,b:/data"} {
+		_, err := ParseVolumeMounts(map[string]string{VolumesAnnotation: value})
+		assert.NotNil(t, err, value)
+	}
+}
+
+func setupGroup() *api.TaskGroup {
+	return &api.TaskGroup{Tasks: []*api.Task{&api.Task{Config: map[string]interface{}{}}}}
+}
+
+func TestMountAddsGroupVolumesAndTaskMounts(t *testing.T) {
+	group := setupGroup()
+
+	err := setupVolumes(t).Mount(group, map[string]string{VolumesAnnotation: "models:/models,scratch:/scratch,datasets:/data:ro"})
+
+	assert.Nil(t, err)
+	assert.Equal(t, &api.VolumeRequest{Name: "models", Type: "host", Source: "models", ReadOnly: true}, group.Volumes["models"])
+	assert.Equal(t, &api.VolumeRequest{Name: "scratch", Type: "host", Source: "scratch"}, group.Volumes["scratch"])
+	assert.Equal(t, &api.VolumeRequest{Name: "datasets", Type: "csi", Source: "datasets-ebs"}, group.Volumes["datasets"])
+
+	mounts := group.Tasks[0].VolumeMounts
+	assert.Len(t, mounts, 3)
+	assert.Equal(t, "models", *mounts[0].Volume)
+	assert.Equal(t, "/models", *mounts[0].Destination)
+	assert.True(t, *mounts[0].ReadOnly, "read_only volumes are always mounted read only")
+	assert.False(t, *mounts[1].ReadOnly)
+	assert.True(t, *mounts[2].ReadOnly)
+
+	// the Docker driver options are not used
+	assert.Nil(t, group.Tasks[0].Config["volumes"])
+	assert.Nil(t, group.Tasks[0].Config["volume_driver"])
+}
+
+func TestMountRejectsVolumesNotInAllowlist(t *testing.T) {
+	group := setupGroup()
+
+	err := setupVolumes(t).Mount(group, map[string]string{VolumesAnnotation: "etc:/etc"})
+
+	assert.Contains(t, err.Error(), `"etc" is not allowed`)
+	assert.Nil(t, group.Volumes)
+	assert.Nil(t, group.Tasks[0].VolumeMounts)
+}
+
+func TestVolumesReplaceChangesAllowlist(t *testing.T) {
+	v := setupVolumes(t)
+
+	other, err := ParseVolumes(`volume "scratch" {
+  type   = "host"
+  source = "scratch"
+}`)
+	assert.Nil(t, err)
+
+	v.Replace(other)
+
+	err = v.Mount(setupGroup(), map[string]string{VolumesAnnotation: "models:/models"})
+
+	assert.Contains(t, err.Error(), "not allowed")
+	assert.Equal(t, 1, v.Len())
+}
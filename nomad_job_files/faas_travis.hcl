@@ -22,6 +22,9 @@ job "faas-nomadd" {
     task "nomadd" {
       driver = "docker"
 
+      # allow in-flight requests to drain, must be longer than -shutdown_timeout
+      kill_timeout = "35s"
+
       config {
         image = "localhost:5000/faas-nomad:latest"
 
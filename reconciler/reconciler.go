@@ -0,0 +1,263 @@
+package reconciler
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/faas-nomad/consul"
+	"github.com/hashicorp/faas-nomad/metrics"
+	"github.com/hashicorp/faas-nomad/nomad"
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
+)
+
+// SecretLister returns the names of the secrets held in the secret store
+type SecretLister interface {
+	ListSecrets() ([]string, error)
+}
+
+// Report details the resources found by a reconciliation run
+type Report struct {
+	DryRun        bool      `json:"dryRun"`
+	Time          time.Time `json:"time"`
+	PurgedJobs    []string  `json:"purgedJobs"`
+	StaleCache    []string  `json:"staleCacheEntries"`
+	UnusedSecrets []string  `json:"unusedSecrets"`
+	Errors        []string  `json:"errors,omitempty"`
+}
+
+// Reconciler removes resources which have been orphaned by failed deploys
+// and interrupted deletes
+type Reconciler struct {
+	jobs      nomad.Job
+	resolver  consul.ServiceResolver
+	secrets   SecretLister
+	threshold time.Duration
+	logger    hclog.Logger
+	stats     metrics.StatsD
+	stop      chan struct{}
+	mutex     sync.Mutex
+}
+
+// New creates a new Reconciler, jobs which have been dead for less than the
+// threshold are left alone, secrets can be nil when no secret store is configured
+func New(jobs nomad.Job, resolver consul.ServiceResolver, secrets SecretLister, threshold time.Duration, logger hclog.Logger, stats metrics.StatsD) *Reconciler {
+	return &Reconciler{
+		jobs:      jobs,
+		resolver:  resolver,
+		secrets:   secrets,
+		threshold: threshold,
+		logger:    logger.Named("reconciler"),
+		stats:     stats,
+		stop:      make(chan struct{}),
+	}
+}
+
+// Start runs the reconciler at the given interval until Stop is called
+func (r *Reconciler) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				r.Reconcile(false)
+			case <-r.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the background reconciliation
+func (r *Reconciler) Stop() {
+	close(r.stop)
+}
+
+// Reconcile finds orphaned function jobs, cache entries and secrets, when
+// dryRun is false the jobs are purged and the cache entries removed.
+// Unused secrets are only ever reported.
+func (r *Reconciler) Reconcile(dryRun bool) Report {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.stats.Incr("reconciler.called", nil, 1)
+
+	report := Report{
+		DryRun:        dryRun,
+		Time:          time.Now(),
+		PurgedJobs:    []string{},
+		StaleCache:    []string{},
+		UnusedSecrets: []string{},
+	}
+
+	options := &api.QueryOptions{}
+	options.Prefix = nomad.JobPrefix
+
+	jobs, _, err := r.jobs.List(options)
+	if err != nil {
+		r.logger.Error("Error listing jobs", "error", err)
+		r.stats.Incr("reconciler.error.listjobs", nil, 1)
+		report.Errors = append(report.Errors, err.Error())
+		return report
+	}
+
+	live := map[string]bool{}
+	referenced := map[string]bool{}
+
+	for _, j := range jobs {
+		// runs of scheduled and dispatched functions are garbage collected by Nomad
+		if nomad.IsChildJob(j) {
+			continue
+		}
+
+		function := strings.TrimPrefix(j.ID, nomad.JobPrefix)
+
+		orphaned, err := r.isOrphaned(j)
+		if err != nil {
+			report.Errors = append(report.Errors, err.Error())
+			continue
+		}
+
+		if orphaned {
+			report.PurgedJobs = append(report.PurgedJobs, function)
+			r.purge(j.ID, dryRun, &report)
+			continue
+		}
+
+		live[function] = true
+
+		job, _, err := r.jobs.Info(j.ID, nil)
+		if err != nil {
+			report.Errors = append(report.Errors, err.Error())
+			continue
+		}
+
+		for _, s := range nomad.JobSecrets(job) {
+			referenced[s] = true
+		}
+	}
+
+	for _, f := range r.resolver.CachedFunctions() {
+		if live[f] {
+			continue
+		}
+
+		report.StaleCache = append(report.StaleCache, f)
+		if !dryRun {
+			r.resolver.RemoveCacheItem(f)
+		}
+	}
+
+	if r.secrets != nil {
+		secrets, err := r.secrets.ListSecrets()
+		if err != nil {
+			r.logger.Error("Error listing secrets", "error", err)
+			report.Errors = append(report.Errors, err.Error())
+		}
+
+		for _, s := range secrets {
+			if !referenced[s] {
+				report.UnusedSecrets = append(report.UnusedSecrets, s)
+			}
+		}
+	}
+
+	sort.Strings(report.PurgedJobs)
+	sort.Strings(report.StaleCache)
+	sort.Strings(report.UnusedSecrets)
+
+	r.logger.Info(
+		"Reconciliation complete",
+		"dry_run", dryRun,
+		"jobs", len(report.PurgedJobs),
+		"cache", len(report.StaleCache),
+		"unused_secrets", len(report.UnusedSecrets),
+	)
+
+	return report
+}
+
+func (r *Reconciler) purge(jobID string, dryRun bool, report *Report) {
+	if dryRun {
+		return
+	}
+
+	r.logger.Info("Purging orphaned job", "job", jobID)
+
+	_, _, err := r.jobs.Deregister(jobID, true, nil)
+	if err != nil {
+		r.logger.Error("Error purging job", "job", jobID, "error", err)
+		r.stats.Incr("reconciler.error.purge", []string{"job:" + jobID}, 1)
+		report.Errors = append(report.Errors, err.Error())
+		return
+	}
+
+	r.stats.Incr("reconciler.purged", []string{"job:" + jobID}, 1)
+}
+
+// isOrphaned returns true when a job was stopped, or has only failed
+// allocations, and has been dead for longer than the threshold.  Functions
+// scaled to zero are also dead but are never orphaned.
+func (r *Reconciler) isOrphaned(j *api.JobListStub) (bool, error) {
+	stopped := j.Stop && j.Status == "dead"
+	if !stopped && !hasFailed(j.JobSummary) {
+		return false, nil
+	}
+
+	job, _, err := r.jobs.Info(j.ID, nil)
+	if err != nil {
+		return false, err
+	}
+
+	if isScaledToZero(job) {
+		return false, nil
+	}
+
+	allocs, _, err := r.jobs.Allocations(j.ID, false, nil)
+	if err != nil {
+		return false, err
+	}
+
+	return time.Since(deadSince(j, allocs)) >= r.threshold, nil
+}
+
+func isScaledToZero(job *api.Job) bool {
+	for _, tg := range job.TaskGroups {
+		if tg.Count != nil && *tg.Count == 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// deadSince returns when the last allocation of a job stopped, jobs without
+// allocations are dated by their submission
+func deadSince(j *api.JobListStub, allocs []*api.AllocationListStub) time.Time {
+	since := j.SubmitTime
+	for _, a := range allocs {
+		if a.ModifyTime > since {
+			since = a.ModifyTime
+		}
+	}
+
+	return time.Unix(0, since)
+}
+
+func hasFailed(s *api.JobSummary) bool {
+	if s == nil || len(s.Summary) == 0 {
+		return false
+	}
+
+	for _, tg := range s.Summary {
+		if tg.Failed == 0 || tg.Running > 0 || tg.Starting > 0 || tg.Queued > 0 {
+			return false
+		}
+	}
+
+	return true
+}
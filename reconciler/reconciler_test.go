@@ -0,0 +1,181 @@
+package reconciler
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/faas-nomad/consul"
+	"github.com/hashicorp/faas-nomad/metrics"
+	"github.com/hashicorp/faas-nomad/nomad"
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type mockSecrets struct {
+	secrets []string
+	err     error
+}
+
+func (m *mockSecrets) ListSecrets() ([]string, error) {
+	return m.secrets, m.err
+}
+
+func setupReconciler(jobs []*api.JobListStub, cached []string) (*Reconciler, *nomad.MockJob, *consul.MockResolver) {
+	mockJob := &nomad.MockJob{}
+	mockJob.On("List", mock.Anything).Return(jobs, nil, nil)
+	mockJob.On("Deregister", mock.Anything, true, mock.Anything).Return(nil, nil, nil)
+	mockJob.On("Allocations", mock.Anything, false, mock.Anything).Return(nil, nil, nil)
+
+	secretPath := nomad.SecretDestPrefix + "db_password"
+	mockJob.On("Info", mock.Anything, mock.Anything).Return(&api.Job{
+		TaskGroups: []*api.TaskGroup{&api.TaskGroup{
+			Tasks: []*api.Task{&api.Task{
+				Templates: []*api.Template{&api.Template{DestPath: &secretPath}},
+			}},
+		}},
+	}, nil, nil)
+
+	mockResolver := &consul.MockResolver{}
+	mockResolver.On("CachedFunctions").Return(cached)
+	mockResolver.On("RemoveCacheItem", mock.Anything)
+
+	mockStats := &metrics.MockStatsD{}
+	mockStats.On("Incr", mock.Anything, mock.Anything, mock.Anything)
+
+	secrets := &mockSecrets{secrets: []string{"db_password", "old_token"}}
+
+	return New(mockJob, mockResolver, secrets, time.Hour, hclog.Default(), mockStats), mockJob, mockResolver
+}
+
+func jobStub(name, status string, age time.Duration) *api.JobListStub {
+	return &api.JobListStub{
+		ID:         nomad.JobPrefix + name,
+		Status:     status,
+		SubmitTime: time.Now().Add(-age).UnixNano(),
+	}
+}
+
+func stoppedStub(name string, age time.Duration) *api.JobListStub {
+	j := jobStub(name, "dead", age)
+	j.Stop = true
+
+	return j
+}
+
+func TestReconcilePurgesStoppedJobsOlderThanThreshold(t *testing.T) {
+	r, mockJob, _ := setupReconciler([]*api.JobListStub{stoppedStub("old", 2*time.Hour)}, []string{})
+
+	report := r.Reconcile(false)
+
+	assert.Equal(t, []string{"old"}, report.PurgedJobs)
+	mockJob.AssertCalled(t, "Deregister", nomad.JobPrefix+"old", true, mock.Anything)
+}
+
+func TestReconcileIgnoresStoppedJobsYoungerThanThreshold(t *testing.T) {
+	r, mockJob, _ := setupReconciler([]*api.JobListStub{stoppedStub("new", time.Minute)}, []string{})
+
+	report := r.Reconcile(false)
+
+	assert.Empty(t, report.PurgedJobs)
+	mockJob.AssertNotCalled(t, "Deregister", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestReconcileMeasuresAgeFromLastStoppedAllocation(t *testing.T) {
+	r, mockJob, _ := setupReconciler([]*api.JobListStub{stoppedStub("slow", 2*time.Hour)}, []string{})
+	mockJob.ExpectedCalls = removeCalls(mockJob.ExpectedCalls, "Allocations")
+	mockJob.On("Allocations", mock.Anything, false, mock.Anything).Return([]*api.AllocationListStub{
+		&api.AllocationListStub{ModifyTime: time.Now().Add(-time.Minute).UnixNano()},
+	}, nil, nil)
+
+	report := r.Reconcile(false)
+
+	assert.Empty(t, report.PurgedJobs)
+}
+
+func TestReconcileIgnoresDeadJobsWhichWereNotStopped(t *testing.T) {
+	r, mockJob, _ := setupReconciler([]*api.JobListStub{jobStub("idle", "dead", 2*time.Hour)}, []string{})
+
+	report := r.Reconcile(false)
+
+	assert.Empty(t, report.PurgedJobs)
+	mockJob.AssertNotCalled(t, "Deregister", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestReconcileNeverPurgesJobsScaledToZero(t *testing.T) {
+	r, mockJob, _ := setupReconciler([]*api.JobListStub{stoppedStub("idle", 2*time.Hour)}, []string{})
+	mockJob.ExpectedCalls = removeCalls(mockJob.ExpectedCalls, "Info")
+
+	count := 0
+	mockJob.On("Info", mock.Anything, mock.Anything).Return(&api.Job{
+		TaskGroups: []*api.TaskGroup{&api.TaskGroup{Count: &count}},
+	}, nil, nil)
+
+	report := r.Reconcile(false)
+
+	assert.Empty(t, report.PurgedJobs)
+	mockJob.AssertNotCalled(t, "Deregister", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func removeCalls(calls []*mock.Call, method string) []*mock.Call {
+	kept := []*mock.Call{}
+	for _, c := range calls {
+		if c.Method != method {
+			kept = append(kept, c)
+		}
+	}
+
+	return kept
+}
+
+func TestReconcilePurgesJobsWithOnlyFailedAllocations(t *testing.T) {
+	j := jobStub("failed", "running", 2*time.Hour)
+	j.JobSummary = &api.JobSummary{Summary: map[string]api.TaskGroupSummary{"failed": {Failed: 3}}}
+	r, _, _ := setupReconciler([]*api.JobListStub{j}, []string{})
+
+	report := r.Reconcile(false)
+
+	assert.Equal(t, []string{"failed"}, report.PurgedJobs)
+}
+
+func TestReconcileDryRunDoesNotPurge(t *testing.T) {
+	r, mockJob, mockResolver := setupReconciler([]*api.JobListStub{stoppedStub("old", 2*time.Hour)}, []string{"gone"})
+
+	report := r.Reconcile(true)
+
+	assert.True(t, report.DryRun)
+	assert.Equal(t, []string{"old"}, report.PurgedJobs)
+	assert.Equal(t, []string{"gone"}, report.StaleCache)
+	mockJob.AssertNotCalled(t, "Deregister", mock.Anything, mock.Anything, mock.Anything)
+	mockResolver.AssertNotCalled(t, "RemoveCacheItem", mock.Anything)
+}
+
+func TestReconcileRemovesStaleCacheEntries(t *testing.T) {
+	r, _, mockResolver := setupReconciler([]*api.JobListStub{jobStub("live", "running", time.Minute)}, []string{"live", "gone"})
+
+	report := r.Reconcile(false)
+
+	assert.Equal(t, []string{"gone"}, report.StaleCache)
+	mockResolver.AssertCalled(t, "RemoveCacheItem", "gone")
+	mockResolver.AssertNotCalled(t, "RemoveCacheItem", "live")
+}
+
+func TestReconcileReportsUnusedSecrets(t *testing.T) {
+	r, _, _ := setupReconciler([]*api.JobListStub{jobStub("live", "running", time.Minute)}, []string{})
+
+	report := r.Reconcile(false)
+
+	assert.Equal(t, []string{"old_token"}, report.UnusedSecrets)
+}
+
+func TestReconcileReportsListError(t *testing.T) {
+	r, mockJob, _ := setupReconciler(nil, []string{})
+	mockJob.ExpectedCalls = nil
+	mockJob.On("List", mock.Anything).Return(nil, nil, fmt.Errorf("boom"))
+
+	report := r.Reconcile(false)
+
+	assert.Equal(t, []string{"boom"}, report.Errors)
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/openfaas/faas-provider/auth"
+	"github.com/openfaas/faas-provider/types"
+)
+
+// RegisterRoutes adds the OpenFaaS provider routes to the router, it matches
+// the routes created by bootstrap.Serve so that the provider can manage its
+// own http.Server
+func RegisterRoutes(r *mux.Router, handlers *types.FaaSHandlers, config *types.FaaSConfig) error {
+	if config.EnableBasicAuth {
+		reader := auth.ReadBasicAuthFromDisk{
+			SecretMountPath: config.SecretMountPath,
+		}
+
+		credentials, err := reader.Read()
+		if err != nil {
+			return err
+		}
+
+		handlers.FunctionReader = auth.DecorateWithBasicAuth(handlers.FunctionReader, credentials)
+		handlers.DeployHandler = auth.DecorateWithBasicAuth(handlers.DeployHandler, credentials)
+		handlers.DeleteHandler = auth.DecorateWithBasicAuth(handlers.DeleteHandler, credentials)
+		handlers.UpdateHandler = auth.DecorateWithBasicAuth(handlers.UpdateHandler, credentials)
+		handlers.ReplicaReader = auth.DecorateWithBasicAuth(handlers.ReplicaReader, credentials)
+		handlers.ReplicaUpdater = auth.DecorateWithBasicAuth(handlers.ReplicaUpdater, credentials)
+		handlers.InfoHandler = auth.DecorateWithBasicAuth(handlers.InfoHandler, credentials)
+		handlers.SecretHandler = auth.DecorateWithBasicAuth(handlers.SecretHandler, credentials)
+	}
+
+	// System (auth) endpoints
+	r.HandleFunc("/system/functions", handlers.FunctionReader).Methods(http.MethodGet)
+	r.HandleFunc("/system/functions", handlers.DeployHandler).Methods(http.MethodPost)
+	r.HandleFunc("/system/functions", handlers.DeleteHandler).Methods(http.MethodDelete)
+	r.HandleFunc("/system/functions", handlers.UpdateHandler).Methods(http.MethodPut)
+
+	r.HandleFunc("/system/function/{name:[-a-zA-Z_0-9]+}", handlers.ReplicaReader).Methods(http.MethodGet)
+	r.HandleFunc("/system/scale-function/{name:[-a-zA-Z_0-9]+}", handlers.ReplicaUpdater).Methods(http.MethodPost)
+	r.HandleFunc("/system/info", handlers.InfoHandler).Methods(http.MethodGet)
+
+	r.HandleFunc("/system/secrets", handlers.SecretHandler).Methods(http.MethodGet, http.MethodPut, http.MethodPost, http.MethodDelete)
+
+	// Open endpoints
+	r.HandleFunc("/function/{name:[-a-zA-Z_0-9]+}", handlers.FunctionProxy)
+	r.HandleFunc("/function/{name:[-a-zA-Z_0-9]+}/", handlers.FunctionProxy)
+	r.HandleFunc("/function/{name:[-a-zA-Z_0-9]+}/{params:.*}", handlers.FunctionProxy)
+
+	if config.EnableHealth {
+		r.HandleFunc(HealthPath, handlers.Health).Methods(http.MethodGet)
+	}
+
+	return nil
+}
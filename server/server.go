@@ -0,0 +1,45 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+
+	"github.com/openfaas/faas-provider/types"
+)
+
+// HealthPath is the path of the health endpoint
+const HealthPath = "/healthz"
+
+// New creates the provider's http.Server with the same timeouts used by
+// bootstrap.Serve, when tlsConfig is not nil the server must be started with
+// ListenAndServeTLS
+func New(handler http.Handler, config *types.FaaSConfig, tlsConfig *tls.Config) *http.Server {
+	tcpPort := 8080
+	if config.TCPPort != nil {
+		tcpPort = *config.TCPPort
+	}
+
+	if tlsConfig != nil && tlsConfig.ClientCAs != nil {
+		handler = RequireClientCert(handler)
+	}
+
+	return &http.Server{
+		Addr:           fmt.Sprintf(":%d", tcpPort),
+		ReadTimeout:    config.ReadTimeout,
+		WriteTimeout:   config.WriteTimeout,
+		MaxHeaderBytes: http.DefaultMaxHeaderBytes,
+		Handler:        handler,
+		TLSConfig:      tlsConfig,
+	}
+}
+
+// ListenAndServe starts the server with TLS when it has a TLS config
+func ListenAndServe(s *http.Server) error {
+	if s.TLSConfig != nil {
+		// the certificate is provided by TLSConfig.GetCertificate
+		return s.ListenAndServeTLS("", "")
+	}
+
+	return s.ListenAndServe()
+}
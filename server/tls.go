@@ -0,0 +1,124 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// certCheckInterval is the minimum time between checks for changed
+// certificate files
+var certCheckInterval = 5 * time.Second
+
+// CertReloader loads a certificate and key pair and loads them again when
+// either file changes, so that certificates can be rotated without a restart
+type CertReloader struct {
+	certFile string
+	keyFile  string
+
+	mutex     sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+// NewCertReloader creates a CertReloader, an error is returned when the
+// certificate can not be loaded
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	c := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate, when the files have
+// changed but can not be loaded the previous certificate continues to be used
+func (c *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if time.Since(c.lastCheck) >= certCheckInterval {
+		c.lastCheck = time.Now()
+
+		if c.latestModTime().After(c.modTime) {
+			c.load()
+		}
+	}
+
+	return c.cert, nil
+}
+
+func (c *CertReloader) load() error {
+	modTime := c.latestModTime()
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("Unable to load TLS certificate: %s", err)
+	}
+
+	c.cert = &cert
+	c.modTime = modTime
+
+	return nil
+}
+
+func (c *CertReloader) latestModTime() time.Time {
+	latest := time.Time{}
+	for _, f := range []string{c.certFile, c.keyFile} {
+		if info, err := os.Stat(f); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest
+}
+
+// NewTLSConfig creates the server TLS config, when clientCAFile is set client
+// certificates are verified against it
+func NewTLSConfig(certs *CertReloader, clientCAFile string) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: certs.GetCertificate,
+	}
+
+	if clientCAFile == "" {
+		return config, nil
+	}
+
+	ca, err := ioutil.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("Unable to parse client CA certificate %s", clientCAFile)
+	}
+
+	// certificates are verified when presented and required by
+	// RequireClientCert so that health checks can be made without one
+	config.ClientCAs = pool
+	config.ClientAuth = tls.VerifyClientCertIfGiven
+
+	return config, nil
+}
+
+// RequireClientCert rejects requests which were not made with a verified
+// client certificate, the health endpoint is always allowed
+func RequireClientCert(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != HealthPath && (r.TLS == nil || len(r.TLS.VerifiedChains) == 0) {
+			http.Error(rw, "Client certificate required", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(rw, r)
+	})
+}
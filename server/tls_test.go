@@ -0,0 +1,246 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/openfaas/faas-provider/types"
+	"github.com/stretchr/testify/assert"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+	kpem []byte
+}
+
+func generateCert(t *testing.T, cn string, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+	}
+
+	parentCert, parentKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	} else {
+		parentCert, parentKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parentCert, &key.PublicKey, parentKey)
+	assert.NoError(t, err)
+
+	cert, _ := x509.ParseCertificate(der)
+	kder, _ := x509.MarshalECPrivateKey(key)
+
+	return &testCert{
+		cert: cert,
+		key:  key,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		kpem: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kder}),
+	}
+}
+
+func writeCert(t *testing.T, dir string, c *testCert) (string, string) {
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	assert.NoError(t, ioutil.WriteFile(certFile, c.pem, 0600))
+	assert.NoError(t, ioutil.WriteFile(keyFile, c.kpem, 0600))
+
+	return certFile, keyFile
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "faas-nomad-tls")
+	assert.NoError(t, err)
+
+	return dir
+}
+
+func TestCertReloaderReturnsErrorForMissingFiles(t *testing.T) {
+	_, err := NewCertReloader("/does/not/exist.pem", "/does/not/exist.key")
+
+	assert.Error(t, err)
+}
+
+func TestCertReloaderReloadsChangedCertificate(t *testing.T) {
+	defer func(i time.Duration) { certCheckInterval = i }(certCheckInterval)
+	certCheckInterval = 0
+
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	ca := generateCert(t, "ca", nil)
+	certFile, keyFile := writeCert(t, dir, generateCert(t, "first", ca))
+
+	r, err := NewCertReloader(certFile, keyFile)
+	assert.NoError(t, err)
+
+	cert, _ := r.GetCertificate(nil)
+	leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+	assert.Equal(t, "first", leaf.Subject.CommonName)
+
+	writeCert(t, dir, generateCert(t, "second", ca))
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, future, future)
+
+	cert, _ = r.GetCertificate(nil)
+	leaf, _ = x509.ParseCertificate(cert.Certificate[0])
+	assert.Equal(t, "second", leaf.Subject.CommonName)
+}
+
+func TestCertReloaderKeepsCertificateWhenReloadFails(t *testing.T) {
+	defer func(i time.Duration) { certCheckInterval = i }(certCheckInterval)
+	certCheckInterval = 0
+
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := writeCert(t, dir, generateCert(t, "first", generateCert(t, "ca", nil)))
+
+	r, err := NewCertReloader(certFile, keyFile)
+	assert.NoError(t, err)
+
+	ioutil.WriteFile(certFile, []byte("invalid"), 0600)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, future, future)
+
+	cert, err := r.GetCertificate(nil)
+	assert.NoError(t, err)
+	assert.NotNil(t, cert)
+}
+
+func TestNewTLSConfigReturnsErrorForInvalidClientCA(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := writeCert(t, dir, generateCert(t, "server", generateCert(t, "ca", nil)))
+	caFile := filepath.Join(dir, "ca.pem")
+	ioutil.WriteFile(caFile, []byte("invalid"), 0600)
+
+	r, _ := NewCertReloader(certFile, keyFile)
+	_, err := NewTLSConfig(r, caFile)
+
+	assert.Error(t, err)
+}
+
+func setupMTLSServer(t *testing.T, dir string, ca *testCert) (*http.Server, string) {
+	certFile, keyFile := writeCert(t, dir, generateCert(t, "server", ca))
+	caFile := filepath.Join(dir, "ca.pem")
+	ioutil.WriteFile(caFile, ca.pem, 0600)
+
+	r, err := NewCertReloader(certFile, keyFile)
+	assert.NoError(t, err)
+
+	tlsConfig, err := NewTLSConfig(r, caFile)
+	assert.NoError(t, err)
+
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	})
+
+	s := New(handler, &types.FaaSConfig{}, tlsConfig)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	go s.Serve(tls.NewListener(l, s.TLSConfig))
+
+	return s, "https://" + l.Addr().String()
+}
+
+func makeClient(ca *testCert, client *testCert) *http.Client {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	config := &tls.Config{RootCAs: pool}
+	if client != nil {
+		config.Certificates = []tls.Certificate{{
+			Certificate: [][]byte{client.cert.Raw},
+			PrivateKey:  client.key,
+		}}
+	}
+
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+}
+
+func TestMTLSAllowsRequestsWithClientCertificate(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	ca := generateCert(t, "ca", nil)
+	s, url := setupMTLSServer(t, dir, ca)
+	defer s.Close()
+
+	resp, err := makeClient(ca, generateCert(t, "client", ca)).Get(url + "/system/functions")
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestMTLSRejectsRequestsWithoutClientCertificate(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	ca := generateCert(t, "ca", nil)
+	s, url := setupMTLSServer(t, dir, ca)
+	defer s.Close()
+
+	resp, err := makeClient(ca, nil).Get(url + "/system/functions")
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestMTLSRejectsClientCertificateFromUnknownCA(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	ca := generateCert(t, "ca", nil)
+	s, url := setupMTLSServer(t, dir, ca)
+	defer s.Close()
+
+	resp, err := makeClient(ca, generateCert(t, "client", generateCert(t, "other", nil))).Get(url + "/system/functions")
+
+	// the client does not send a certificate which the server will not accept
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestMTLSAllowsHealthWithoutClientCertificate(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	ca := generateCert(t, "ca", nil)
+	s, url := setupMTLSServer(t, dir, ca)
+	defer s.Close()
+
+	resp, err := makeClient(ca, nil).Get(url + HealthPath)
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
@@ -0,0 +1,9 @@
+package types
+
+// ConnectConfig configures the Consul Connect sidecar deployed with functions
+// which set the connect annotation
+type ConnectConfig struct {
+	// SidecarImage overrides the Envoy image of the sidecar task injected by
+	// Nomad, the Nomad default is used when it is empty
+	SidecarImage string
+}
@@ -0,0 +1,33 @@
+package types
+
+import "sync"
+
+// FunctionDefaults are the resources allocated to functions which do not set
+// limits, they can be changed while the provider is running
+type FunctionDefaults struct {
+	mutex    sync.RWMutex
+	memoryMB int
+	cpu      int
+}
+
+// NewFunctionDefaults creates FunctionDefaults with the given resources
+func NewFunctionDefaults(memoryMB, cpu int) *FunctionDefaults {
+	return &FunctionDefaults{memoryMB: memoryMB, cpu: cpu}
+}
+
+// Set replaces the default resources
+func (d *FunctionDefaults) Set(memoryMB, cpu int) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.memoryMB = memoryMB
+	d.cpu = cpu
+}
+
+// Resources returns the default memory in MB and CPU in MHz
+func (d *FunctionDefaults) Resources() (memoryMB, cpu int) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	return d.memoryMB, d.cpu
+}
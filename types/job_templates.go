@@ -0,0 +1,16 @@
+package types
+
+import "github.com/hashicorp/nomad/api"
+
+// JobTemplates merges operator supplied job templates into generated function jobs
+type JobTemplates interface {
+	// Apply merges the templates selected for a function into its job
+	Apply(job *api.Job, annotations map[string]string) error
+}
+
+// VolumeMounter mounts the volumes requested by a function from an operator allowlist
+type VolumeMounter interface {
+	// Mount adds the volumes selected by the function annotations to the
+	// task group and mounts them into the function task
+	Mount(group *api.TaskGroup, annotations map[string]string) error
+}
@@ -1,9 +1,23 @@
 package types
 
 type ProviderConfig struct {
-	Vault             VaultConfig
+	// Secrets is the store used to deploy function secrets, nil when secrets are not supported
+	Secrets           SecretStore
 	Datacenter        string
 	ConsulAddress     string
 	ConsulDNSEnabled  bool
 	CPUArchConstraint string
+	// SecretChangeMode is the default action taken when a secret changes, restart, signal or noop
+	SecretChangeMode string
+	// SecretChangeSignal is the default signal sent when the change mode is signal
+	SecretChangeSignal string
+	// Defaults are the resources allocated to functions without limits, the
+	// package defaults are used when nil
+	Defaults *FunctionDefaults
+	// JobTemplates are merged into every generated job, nil when no templates are configured
+	JobTemplates JobTemplates
+	// Volumes is the allowlist of volumes functions can mount, nil when volumes are not supported
+	Volumes VolumeMounter
+	// Connect configures the Connect sidecar, nil when Connect is not enabled
+	Connect *ConnectConfig
 }
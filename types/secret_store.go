@@ -0,0 +1,38 @@
+package types
+
+// Supported secret store backends
+const (
+	SecretStoreVault  = "vault"
+	SecretStoreNomad  = "nomad"
+	SecretStoreConsul = "consul"
+)
+
+// SecretStore stores function secrets and provides the Nomad template
+// expressions which render them into a function's task
+type SecretStore interface {
+	// ListSecrets returns the names of the secrets in the store
+	ListSecrets() ([]string, error)
+	// GetSecretMetadata returns the version details of a secret when the store supports them
+	GetSecretMetadata(name string) (SecretMetadata, error)
+	// SetSecret creates or updates a secret
+	SetSecret(name, value string) error
+	// DeleteSecret removes a secret and all of its versions
+	DeleteSecret(name string) error
+	// SecretTemplate returns the template expression which renders the value of a secret
+	SecretTemplate(name string) string
+	// SecretTemplateFormat returns a template which renders the value of a
+	// secret through format, a template action in which %s is replaced by the
+	// expression of the secret value e.g. {{%s | toUpper}}
+	SecretTemplateFormat(name, format string) string
+	// Policies returns the Vault policies a task needs to read secrets, it is
+	// empty when the store does not use Vault
+	Policies() []string
+}
+
+// SecretMetadata contains the version details of a secret, fields are
+// omitted when they are not supported by the secret store
+type SecretMetadata struct {
+	Version     int    `json:"version,omitempty"`
+	CreatedTime string `json:"createdTime,omitempty"`
+	UpdatedTime string `json:"updatedTime,omitempty"`
+}
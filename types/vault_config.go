@@ -8,4 +8,22 @@ type VaultConfig struct {
 	SecretPathPrefix string
 	AppRoleID        string
 	AppSecretID      string
+	// AppSecretIDFile is read for the AppRole secret_id instead of AppSecretID
+	AppSecretIDFile string
+	// AuthMethod used to login to Vault, approle, token, jwt or nomad
+	AuthMethod string
+	// AuthMount is the path the auth method is mounted at when not the default
+	AuthMount string
+	// TokenFile contains the token used by the token auth method
+	TokenFile string
+	// JWTRole is the role used by the jwt and nomad auth methods
+	JWTRole string
+	// JWTFile contains the token used by the jwt and nomad auth methods
+	JWTFile string
+	// KVVersion is the version of the k/v secrets engine mounted at the secret
+	// path prefix, 0 means the version is detected from Vault
+	KVVersion int
+	// KVMount is the mount path of the k/v secrets engine, when empty the
+	// first segment of the secret path prefix is used
+	KVMount string
 }
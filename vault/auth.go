@@ -0,0 +1,194 @@
+package vault
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/faas-nomad/types"
+	"github.com/hashicorp/vault/api"
+)
+
+// Supported Vault authentication methods
+const (
+	AuthMethodAppRole = "approle"
+	AuthMethodToken   = "token"
+	AuthMethodJWT     = "jwt"
+	AuthMethodNomad   = "nomad"
+)
+
+// Authenticator obtains a Vault token for the provider
+type Authenticator interface {
+	Login(vs *VaultService) (*api.Secret, error)
+}
+
+// NewAuthenticator creates the Authenticator for the auth method in the config
+func NewAuthenticator(config *types.VaultConfig) (Authenticator, error) {
+	switch config.AuthMethod {
+	case "", AuthMethodAppRole:
+		return &AppRoleAuth{
+			Mount:        mountOrDefault(config.AuthMount, "approle"),
+			RoleID:       config.AppRoleID,
+			SecretID:     config.AppSecretID,
+			SecretIDFile: config.AppSecretIDFile,
+		}, nil
+	case AuthMethodToken:
+		return &TokenFileAuth{Path: config.TokenFile}, nil
+	case AuthMethodJWT:
+		return &JWTAuth{
+			Mount: mountOrDefault(config.AuthMount, "jwt"),
+			Role:  config.JWTRole,
+			Path:  config.JWTFile,
+		}, nil
+	case AuthMethodNomad:
+		return NewNomadWorkloadIdentityAuth(config.AuthMount, config.JWTRole, config.JWTFile), nil
+	}
+
+	return nil, fmt.Errorf("Unknown Vault auth method %q", config.AuthMethod)
+}
+
+// AppRoleAuth logs in using the AppRole auth method, the secret_id can be
+// read from a file so that it can be delivered by a trusted orchestrator
+type AppRoleAuth struct {
+	Mount        string
+	RoleID       string
+	SecretID     string
+	SecretIDFile string
+}
+
+// Login implements the Authenticator interface
+func (a *AppRoleAuth) Login(vs *VaultService) (*api.Secret, error) {
+	secretID := a.SecretID
+	if a.SecretIDFile != "" {
+		id, err := readFile(a.SecretIDFile)
+		if err != nil {
+			return nil, err
+		}
+
+		secretID = id
+	}
+
+	return vs.login(a.Mount, map[string]interface{}{"role_id": a.RoleID, "secret_id": secretID})
+}
+
+// TokenFileAuth uses a static token which is read from a file, the file is
+// read again on every login so that the token can be rotated
+type TokenFileAuth struct {
+	Path string
+}
+
+// Login implements the Authenticator interface
+func (a *TokenFileAuth) Login(vs *VaultService) (*api.Secret, error) {
+	token, err := readFile(a.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	vs.Client.SetToken(token)
+
+	lResp, lErr := vs.DoRequest(http.MethodGet, "/v1/auth/token/lookup-self", nil)
+	if lErr != nil {
+		return nil, lErr
+	}
+	defer lResp.Body.Close()
+
+	if lResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Vault response status code %v", lResp.StatusCode)
+	}
+
+	lookup := struct {
+		Data struct {
+			TTL       int  `json:"ttl"`
+			Renewable bool `json:"renewable"`
+		} `json:"data"`
+	}{}
+
+	if err := json.NewDecoder(lResp.Body).Decode(&lookup); err != nil {
+		return nil, err
+	}
+
+	return &api.Secret{
+		Auth: &api.SecretAuth{
+			ClientToken:   token,
+			LeaseDuration: lookup.Data.TTL,
+			Renewable:     lookup.Data.Renewable,
+		},
+	}, nil
+}
+
+// JWTAuth logs in using the JWT/OIDC auth method with a token read from a file
+type JWTAuth struct {
+	Mount string
+	Role  string
+	Path  string
+}
+
+// Login implements the Authenticator interface
+func (a *JWTAuth) Login(vs *VaultService) (*api.Secret, error) {
+	jwt, err := readFile(a.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	return vs.login(a.Mount, map[string]interface{}{"role": a.Role, "jwt": jwt})
+}
+
+// NewNomadWorkloadIdentityAuth creates a JWTAuth which uses the workload
+// identity token Nomad writes to the task secrets directory
+func NewNomadWorkloadIdentityAuth(mount, role, path string) *JWTAuth {
+	if path == "" {
+		path = filepath.Join(os.Getenv("NOMAD_SECRETS_DIR"), "nomad_token")
+	}
+
+	return &JWTAuth{
+		Mount: mountOrDefault(mount, "jwt-nomad"),
+		Role:  role,
+		Path:  path,
+	}
+}
+
+// login posts the credentials to the login endpoint of an auth method
+func (vs *VaultService) login(mount string, body map[string]interface{}) (*api.Secret, error) {
+	lResp, lErr := vs.DoRequest(http.MethodPost, fmt.Sprintf("/v1/auth/%s/login", mount), body)
+	if lErr != nil {
+		return nil, lErr
+	}
+	defer lResp.Body.Close()
+
+	if lResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Vault response status code %v", lResp.StatusCode)
+	}
+
+	var vaultLogin api.Secret
+	lBody, _ := ioutil.ReadAll(lResp.Body)
+	if err := json.Unmarshal(lBody, &vaultLogin); err != nil {
+		return nil, err
+	}
+
+	return &vaultLogin, nil
+}
+
+func mountOrDefault(mount, def string) string {
+	if mount == "" {
+		return def
+	}
+
+	return strings.Trim(mount, "/")
+}
+
+func readFile(path string) (string, error) {
+	if path == "" {
+		return "", fmt.Errorf("No credentials file configured")
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("Unable to read credentials file: %s", err)
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
@@ -0,0 +1,218 @@
+package vault
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/faas-nomad/types"
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockVault is a minimal stand-in for the Vault HTTP API
+type mockVault struct {
+	*httptest.Server
+	mutex      sync.Mutex
+	logins     []map[string]interface{}
+	loginPaths []string
+	renewals   int
+	renewFail  bool
+}
+
+func newMockVault() *mockVault {
+	m := &mockVault{}
+	m.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+
+		if strings.HasPrefix(r.URL.Path, "/v1/sys/internal/ui/mounts") {
+			rw.Write([]byte(`{"data":{"path":"secret/","type":"kv","options":{"version":"2"}}}`))
+			return
+		}
+
+		switch r.URL.Path {
+		case "/v1/auth/token/lookup-self":
+			if r.Header.Get("X-Vault-Token") != "static-token" {
+				rw.WriteHeader(http.StatusForbidden)
+				return
+			}
+			rw.Write([]byte(`{"data":{"ttl":0,"renewable":false}}`))
+		case "/v1/auth/token/renew-self":
+			m.renewals++
+			if m.renewFail {
+				rw.WriteHeader(http.StatusForbidden)
+				return
+			}
+			rw.Write([]byte(`{"auth":{"client_token":"login-token","lease_duration":3600,"renewable":true}}`))
+		default:
+			body := map[string]interface{}{}
+			json.NewDecoder(r.Body).Decode(&body)
+			m.logins = append(m.logins, body)
+			m.loginPaths = append(m.loginPaths, r.URL.Path)
+			rw.Write([]byte(`{"auth":{"client_token":"login-token","lease_duration":3600,"renewable":true}}`))
+		}
+	}))
+
+	return m
+}
+
+func (m *mockVault) loginCount() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return len(m.logins)
+}
+
+func writeTempFile(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "vault")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "credentials")
+	ioutil.WriteFile(path, []byte(content+"\n"), 0600)
+
+	return path
+}
+
+func TestLoginWithAppRoleReadsSecretIDFromFile(t *testing.T) {
+	m := newMockVault()
+	defer m.Close()
+
+	secretFile := writeTempFile(t, "file-secret-id")
+	defer os.RemoveAll(filepath.Dir(secretFile))
+
+	vs := NewVaultService(&types.VaultConfig{Addr: m.URL, AppRoleID: "role", AppSecretIDFile: secretFile}, hclog.Default())
+	defer vs.Stop()
+
+	_, err := vs.Login()
+
+	assert.Nil(t, err)
+	assert.Equal(t, "/v1/auth/approle/login", m.loginPaths[0])
+	assert.Equal(t, "file-secret-id", m.logins[0]["secret_id"])
+	assert.Equal(t, "login-token", vs.Client.Token())
+}
+
+func TestLoginWithTokenFile(t *testing.T) {
+	m := newMockVault()
+	defer m.Close()
+
+	tokenFile := writeTempFile(t, "static-token")
+	defer os.RemoveAll(filepath.Dir(tokenFile))
+
+	vs := NewVaultService(&types.VaultConfig{Addr: m.URL, AuthMethod: AuthMethodToken, TokenFile: tokenFile}, hclog.Default())
+	defer vs.Stop()
+
+	secret, err := vs.Login()
+
+	assert.Nil(t, err)
+	assert.Equal(t, "static-token", secret.Auth.ClientToken)
+	assert.Equal(t, 0, m.loginCount())
+}
+
+func TestLoginWithJWT(t *testing.T) {
+	m := newMockVault()
+	defer m.Close()
+
+	jwtFile := writeTempFile(t, "header.payload.signature")
+	defer os.RemoveAll(filepath.Dir(jwtFile))
+
+	vs := NewVaultService(&types.VaultConfig{Addr: m.URL, AuthMethod: AuthMethodJWT, JWTRole: "faas", JWTFile: jwtFile}, hclog.Default())
+	defer vs.Stop()
+
+	_, err := vs.Login()
+
+	assert.Nil(t, err)
+	assert.Equal(t, "/v1/auth/jwt/login", m.loginPaths[0])
+	assert.Equal(t, "faas", m.logins[0]["role"])
+	assert.Equal(t, "header.payload.signature", m.logins[0]["jwt"])
+}
+
+func TestLoginWithNomadWorkloadIdentity(t *testing.T) {
+	m := newMockVault()
+	defer m.Close()
+
+	jwtFile := writeTempFile(t, "workload.identity.jwt")
+	defer os.RemoveAll(filepath.Dir(jwtFile))
+	os.Setenv("NOMAD_SECRETS_DIR", filepath.Dir(jwtFile))
+	os.Rename(jwtFile, filepath.Join(filepath.Dir(jwtFile), "nomad_token"))
+	defer os.Unsetenv("NOMAD_SECRETS_DIR")
+
+	vs := NewVaultService(&types.VaultConfig{Addr: m.URL, AuthMethod: AuthMethodNomad, JWTRole: "faas"}, hclog.Default())
+	defer vs.Stop()
+
+	_, err := vs.Login()
+
+	assert.Nil(t, err)
+	assert.Equal(t, "/v1/auth/jwt-nomad/login", m.loginPaths[0])
+	assert.Equal(t, "workload.identity.jwt", m.logins[0]["jwt"])
+}
+
+func TestLoginWithUnknownMethodReturnsError(t *testing.T) {
+	vs := NewVaultService(&types.VaultConfig{AuthMethod: "magic"}, hclog.Default())
+
+	_, err := vs.Login()
+
+	assert.NotNil(t, err)
+}
+
+func TestRenewalFailureLogsInAgain(t *testing.T) {
+	m := newMockVault()
+	m.renewFail = true
+	defer m.Close()
+
+	loginRetryMin = time.Millisecond
+
+	vs := NewVaultService(&types.VaultConfig{Addr: m.URL, AppRoleID: "role", AppSecretID: "secret"}, hclog.Default())
+	defer vs.Stop()
+
+	_, err := vs.Login()
+	assert.Nil(t, err)
+
+	deadline := time.Now().Add(time.Second)
+	for m.loginCount() < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	assert.True(t, m.loginCount() >= 2, "Expected the provider to login again")
+}
+
+func TestRetryLoginSucceedsWhenVaultBecomesAvailable(t *testing.T) {
+	loginRetryMin = time.Millisecond
+
+	secretFile := filepath.Join(os.TempDir(), "faas-nomad-missing-secret-id")
+	os.Remove(secretFile)
+	defer os.Remove(secretFile)
+
+	m := newMockVault()
+	defer m.Close()
+
+	vs := NewVaultService(&types.VaultConfig{Addr: m.URL, AppRoleID: "role", AppSecretIDFile: secretFile}, hclog.Default())
+	defer vs.Stop()
+
+	_, err := vs.Login()
+	assert.NotNil(t, err)
+	assert.False(t, vs.Available())
+
+	vs.RetryLogin()
+	ioutil.WriteFile(secretFile, []byte("late-secret-id"), 0600)
+
+	// templates are rendered by deploys while the background login runs
+	deadline := time.Now().Add(time.Second)
+	for !vs.Available() && time.Now().Before(deadline) {
+		vs.SecretTemplate("figlet")
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	assert.Equal(t, 1, m.loginCount())
+	assert.True(t, vs.Available())
+	assert.Equal(t, 2, vs.config().KVVersion, "the k/v version is detected after a late login")
+}
@@ -0,0 +1,114 @@
+package vault
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/faas-nomad/types"
+)
+
+// SecretPath returns the API path used to read and write a secret
+func SecretPath(config types.VaultConfig, name string) string {
+	return kvPath(config, "data", name)
+}
+
+// MetadataPath returns the API path used to list secrets and read their metadata,
+// for version 1 of the k/v secrets engine this is the same as the secret path
+func MetadataPath(config types.VaultConfig, name string) string {
+	return kvPath(config, "metadata", name)
+}
+
+// SecretTemplate returns the Nomad template which renders the value of a secret
+func SecretTemplate(config types.VaultConfig, name string) string {
+	return SecretTemplateFormat(config, name, "{{%s}}")
+}
+
+// SecretTemplateFormat returns the Nomad template which renders the value of
+// a secret through the format action
+func SecretTemplateFormat(config types.VaultConfig, name, format string) string {
+	value := ".Data.value"
+	if config.KVVersion == 2 {
+		value = ".Data.data.value"
+	}
+
+	return fmt.Sprintf(`{{with secret "%s"}}`, SecretPath(config, name)) + fmt.Sprintf(format, value) + "{{end}}"
+}
+
+// SecretBody returns the request body used to write a secret value
+func SecretBody(config types.VaultConfig, value string) map[string]interface{} {
+	if config.KVVersion == 2 {
+		return map[string]interface{}{"data": map[string]interface{}{"value": value}}
+	}
+
+	return map[string]interface{}{"value": value}
+}
+
+func kvPath(config types.VaultConfig, kind, name string) string {
+	path := strings.Trim(config.SecretPathPrefix, "/")
+	if name != "" {
+		path = path + "/" + name
+	}
+
+	if config.KVVersion != 2 {
+		return path
+	}
+
+	mount := kvMount(config)
+	return mount + "/" + kind + "/" + strings.TrimPrefix(strings.TrimPrefix(path, mount), "/")
+}
+
+func kvMount(config types.VaultConfig) string {
+	if config.KVMount != "" {
+		return strings.Trim(config.KVMount, "/")
+	}
+
+	return strings.Split(strings.Trim(config.SecretPathPrefix, "/"), "/")[0]
+}
+
+// DetectKVVersion queries Vault for the version and mount path of the k/v
+// secrets engine used for the secret path prefix
+func (vs *VaultService) DetectKVVersion() error {
+	response, err := vs.DoRequest(http.MethodGet, "/v1/sys/internal/ui/mounts/"+strings.Trim(vs.Config.SecretPathPrefix, "/"), nil)
+	if err != nil {
+		return fmt.Errorf("Error in request to Vault: %s", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("Vault returned unexpected response: %v", response.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return fmt.Errorf("Error reading response body: %s", err)
+	}
+
+	mount := struct {
+		Data struct {
+			Path    string            `json:"path"`
+			Options map[string]string `json:"options"`
+		} `json:"data"`
+	}{}
+
+	if err := json.Unmarshal(body, &mount); err != nil {
+		return fmt.Errorf("Error in json deserialisation: %s", err)
+	}
+
+	vs.mutex.Lock()
+	defer vs.mutex.Unlock()
+
+	vs.Config.KVVersion = 1
+	if v, err := strconv.Atoi(mount.Data.Options["version"]); err == nil {
+		vs.Config.KVVersion = v
+	}
+
+	if mount.Data.Path != "" {
+		vs.Config.KVMount = strings.Trim(mount.Data.Path, "/")
+	}
+
+	return nil
+}
@@ -0,0 +1,60 @@
+package vault
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/faas-nomad/types"
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecretPathForKVVersion1(t *testing.T) {
+	config := types.VaultConfig{SecretPathPrefix: "secret/openfaas", KVVersion: 1}
+
+	assert.Equal(t, "secret/openfaas/figlet", SecretPath(config, "figlet"))
+	assert.Equal(t, "secret/openfaas", MetadataPath(config, ""))
+}
+
+func TestSecretPathForKVVersion2(t *testing.T) {
+	config := types.VaultConfig{SecretPathPrefix: "secret/openfaas", KVVersion: 2}
+
+	assert.Equal(t, "secret/data/openfaas/figlet", SecretPath(config, "figlet"))
+	assert.Equal(t, "secret/metadata/openfaas", MetadataPath(config, ""))
+}
+
+func TestSecretPathForKVVersion2WithNestedMount(t *testing.T) {
+	config := types.VaultConfig{SecretPathPrefix: "kv/apps/openfaas", KVVersion: 2, KVMount: "kv/apps/"}
+
+	assert.Equal(t, "kv/apps/data/openfaas/figlet", SecretPath(config, "figlet"))
+}
+
+func TestSecretTemplateForKVVersion2(t *testing.T) {
+	config := types.VaultConfig{SecretPathPrefix: "secret/openfaas", KVVersion: 2}
+
+	assert.Equal(t, `{{with secret "secret/data/openfaas/figlet"}}{{.Data.data.value}}{{end}}`, SecretTemplate(config, "figlet"))
+}
+
+func TestSecretTemplateFormatAppliesFormatToValue(t *testing.T) {
+	config := types.VaultConfig{SecretPathPrefix: "secret/openfaas", KVVersion: 2}
+
+	assert.Equal(t, `{{with secret "secret/data/openfaas/figlet"}}{{.Data.data.value | toUpper}}{{end}}`, SecretTemplateFormat(config, "figlet", "{{%s | toUpper}}"))
+}
+
+func TestDetectKVVersionReadsMountOptions(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/sys/internal/ui/mounts/secret/openfaas", r.URL.Path)
+		rw.Write([]byte(`{"data":{"path":"secret/","type":"kv","options":{"version":"2"}}}`))
+	}))
+	defer ts.Close()
+
+	config := &types.VaultConfig{Addr: ts.URL, SecretPathPrefix: "secret/openfaas"}
+	vs := NewVaultService(config, hclog.Default())
+
+	err := vs.DetectKVVersion()
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, config.KVVersion)
+	assert.Equal(t, "secret", config.KVMount)
+}
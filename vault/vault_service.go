@@ -6,16 +6,31 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/hashicorp/faas-nomad/types"
 	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/vault/api"
 )
 
+var (
+	loginRetryMin = 1 * time.Second
+	loginRetryMax = 1 * time.Minute
+)
+
 type VaultService struct {
 	Client *api.Client
 	Config *types.VaultConfig
 	logger hclog.Logger
+	auth   Authenticator
+	stop   chan struct{}
+	once   sync.Once
+
+	// available is set after the first successful login once the k/v
+	// version is known, the mutex also guards the k/v settings in Config
+	mutex     sync.RWMutex
+	available bool
 }
 
 func NewVaultService(config *types.VaultConfig, log hclog.Logger) *VaultService {
@@ -30,46 +45,190 @@ func NewVaultService(config *types.VaultConfig, log hclog.Logger) *VaultService
 		Client: vaultClient,
 		Config: config,
 		logger: log.Named("vault_service"),
+		stop:   make(chan struct{}),
 	}
 
 	return vs
 }
 
-// Gets and sets the initial access token from Vault
+// Gets and sets the initial access token from Vault, the token is then
+// renewed in the background and the provider logs in again when renewal fails
 func (vs *VaultService) Login() (api.Secret, error) {
+	secret, err := vs.authenticate()
+	if err != nil {
+		return api.Secret{}, err
+	}
 
-	var vaultLogin api.Secret
+	go vs.supervise(secret)
 
-	lResp, lErr := vs.DoRequest("POST", "/v1/auth/approle/login",
-		map[string]interface{}{"role_id": vs.Config.AppRoleID, "secret_id": vs.Config.AppSecretID})
+	return *secret, nil
+}
+
+// RetryLogin keeps trying to log in to Vault in the background until it
+// succeeds, it is used when the initial Login fails
+func (vs *VaultService) RetryLogin() {
+	go func() {
+		secret, ok := vs.loginWithBackoff()
+		if ok {
+			vs.logger.Info("Vault authentication successful!")
+			vs.supervise(secret)
+		}
+	}()
+}
+
+// Available returns true once the provider has logged in to Vault and
+// secrets can be used, a failed initial login is retried in the background
+func (vs *VaultService) Available() bool {
+	vs.mutex.RLock()
+	defer vs.mutex.RUnlock()
+
+	return vs.available
+}
+
+// Stop ends token renewal and any login retries
+func (vs *VaultService) Stop() {
+	vs.once.Do(func() { close(vs.stop) })
+}
 
-	if lErr != nil {
-		return vaultLogin, lErr
+func (vs *VaultService) authenticate() (*api.Secret, error) {
+	if vs.auth == nil {
+		auth, err := NewAuthenticator(vs.Config)
+		if err != nil {
+			return nil, err
+		}
+
+		vs.auth = auth
+	}
+
+	secret, err := vs.auth.Login(vs)
+	if err != nil {
+		return nil, err
 	}
 
-	if lResp.StatusCode != http.StatusOK {
-		return vaultLogin, fmt.Errorf("Vault response status code %v", lResp.StatusCode)
+	if secret.Auth == nil || len(secret.Auth.ClientToken) == 0 {
+		return nil, fmt.Errorf("Vault login did not return a token")
 	}
 
-	lBody, _ := ioutil.ReadAll(lResp.Body)
-	parseErr := json.Unmarshal(lBody, &vaultLogin)
-	if parseErr != nil {
-		return vaultLogin, parseErr
+	vs.Client.SetToken(secret.Auth.ClientToken)
+	vs.loggedIn()
+
+	return secret, nil
+}
+
+// loggedIn detects the k/v version after the first successful login, which
+// may happen in the background long after startup
+func (vs *VaultService) loggedIn() {
+	if vs.Available() {
+		return
 	}
 
-	if vaultLogin.Auth != nil && len(vaultLogin.Auth.ClientToken) > 0 {
-		vs.Client.SetToken(vaultLogin.Auth.ClientToken)
+	if vs.config().KVVersion == 0 {
+		if err := vs.DetectKVVersion(); err != nil {
+			vs.logger.Error("Unable to detect Vault k/v version, defaulting to version 1", "error", err)
 
-		r, renewErr := vs.Client.NewRenewer(&api.RenewerInput{
-			Secret: &vaultLogin,
-		})
-		if renewErr != nil {
-			vs.logger.Error(renewErr.Error())
+			vs.mutex.Lock()
+			vs.Config.KVVersion = 1
+			vs.mutex.Unlock()
 		}
-		go r.Renew()
 	}
 
-	return vaultLogin, nil
+	vs.mutex.Lock()
+	vs.available = true
+	vs.mutex.Unlock()
+
+	vs.logger.Info("Vault k/v secrets engine", "version", vs.config().KVVersion)
+}
+
+// config returns a copy of the configuration, the k/v version and mount are
+// set after a delayed login while requests are being served
+func (vs *VaultService) config() types.VaultConfig {
+	vs.mutex.RLock()
+	defer vs.mutex.RUnlock()
+
+	return *vs.Config
+}
+
+// supervise renews the token until renewal stops, then logs in again
+func (vs *VaultService) supervise(secret *api.Secret) {
+	for {
+		if stopped := vs.renew(secret); stopped {
+			return
+		}
+
+		vs.logger.Info("Vault token can no longer be renewed, logging in again")
+
+		var ok bool
+		secret, ok = vs.loginWithBackoff()
+		if !ok {
+			return
+		}
+	}
+}
+
+// renew blocks until the token can no longer be renewed, it returns true
+// when the service has been stopped
+func (vs *VaultService) renew(secret *api.Secret) bool {
+	if !secret.Auth.Renewable {
+		// tokens without a TTL never expire
+		if secret.Auth.LeaseDuration == 0 {
+			<-vs.stop
+			return true
+		}
+
+		select {
+		case <-vs.stop:
+			return true
+		case <-time.After(time.Duration(secret.Auth.LeaseDuration) * time.Second * 2 / 3):
+			return false
+		}
+	}
+
+	r, err := vs.Client.NewRenewer(&api.RenewerInput{Secret: secret})
+	if err != nil {
+		vs.logger.Error("Unable to create Vault token renewer", "error", err)
+		return false
+	}
+
+	go r.Renew()
+	defer r.Stop()
+
+	for {
+		select {
+		case <-vs.stop:
+			return true
+		case <-r.RenewCh():
+			vs.logger.Debug("Vault token renewed")
+		case err := <-r.DoneCh():
+			if err != nil {
+				vs.logger.Error("Vault token renewal failed", "error", err)
+			}
+			return false
+		}
+	}
+}
+
+func (vs *VaultService) loginWithBackoff() (*api.Secret, bool) {
+	delay := loginRetryMin
+
+	for {
+		secret, err := vs.authenticate()
+		if err == nil {
+			return secret, true
+		}
+
+		vs.logger.Error("Unable to login to Vault, retrying", "error", err, "delay", delay)
+
+		select {
+		case <-vs.stop:
+			return nil, false
+		case <-time.After(delay):
+		}
+
+		delay = delay * 2
+		if delay > loginRetryMax {
+			delay = loginRetryMax
+		}
+	}
 }
 
 // Execute request to the configured Vault server
@@ -88,3 +247,128 @@ func (vs *VaultService) DoRequest(method string, path string, body interface{})
 	}
 	return client.Do(request)
 }
+
+// ListSecrets returns the names of the secrets stored under the secret path prefix
+func (vs *VaultService) ListSecrets() ([]string, error) {
+	response, err := vs.DoRequest("LIST", "/v1/"+MetadataPath(vs.config(), ""), nil)
+	if err != nil {
+		return nil, fmt.Errorf("Error in request to Vault: %s", err)
+	}
+	defer response.Body.Close()
+
+	// Vault returns 404 when there are no secrets under the prefix
+	if response.StatusCode == http.StatusNotFound {
+		return []string{}, nil
+	}
+
+	var secretList api.Secret
+	secretsBody, bodyErr := ioutil.ReadAll(response.Body)
+	if bodyErr != nil {
+		return nil, fmt.Errorf("Error reading response body: %s", bodyErr)
+	}
+
+	unmarshalErr := json.Unmarshal(secretsBody, &secretList)
+	if unmarshalErr != nil {
+		return nil, fmt.Errorf("Error in json deserialisation: %s", unmarshalErr)
+	}
+
+	secrets := []string{}
+	if keys, ok := secretList.Data["keys"].([]interface{}); ok {
+		for _, k := range keys {
+			secrets = append(secrets, k.(string))
+		}
+	}
+
+	return secrets, nil
+}
+
+// GetSecretMetadata returns the version details of a secret, an empty
+// metadata is returned for version 1 of the k/v secrets engine
+func (vs *VaultService) GetSecretMetadata(name string) (types.SecretMetadata, error) {
+	metadata := types.SecretMetadata{}
+	if vs.config().KVVersion != 2 {
+		return metadata, nil
+	}
+
+	response, err := vs.DoRequest(http.MethodGet, "/v1/"+MetadataPath(vs.config(), name), nil)
+	if err != nil {
+		return metadata, fmt.Errorf("Error in request to Vault: %s", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return metadata, fmt.Errorf("Vault returned unexpected response: %v", response.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return metadata, fmt.Errorf("Error reading response body: %s", err)
+	}
+
+	kvMetadata := struct {
+		Data struct {
+			CurrentVersion int    `json:"current_version"`
+			CreatedTime    string `json:"created_time"`
+			UpdatedTime    string `json:"updated_time"`
+		} `json:"data"`
+	}{}
+
+	if err := json.Unmarshal(body, &kvMetadata); err != nil {
+		return metadata, fmt.Errorf("Error in json deserialisation: %s", err)
+	}
+
+	metadata.Version = kvMetadata.Data.CurrentVersion
+	metadata.CreatedTime = kvMetadata.Data.CreatedTime
+	metadata.UpdatedTime = kvMetadata.Data.UpdatedTime
+
+	return metadata, nil
+}
+
+// SetSecret creates or updates the value of a secret
+func (vs *VaultService) SetSecret(name, value string) error {
+	config := vs.config()
+	response, err := vs.DoRequest(http.MethodPost, "/v1/"+SecretPath(config, name), SecretBody(config, value))
+	if err != nil {
+		return fmt.Errorf("Error in request to Vault: %s", err)
+	}
+	defer response.Body.Close()
+
+	// k/v version 1 returns 204, version 2 returns 200 with the new version metadata
+	if response.StatusCode != http.StatusNoContent && response.StatusCode != http.StatusOK {
+		return fmt.Errorf("Vault returned unexpected response: %v", response.StatusCode)
+	}
+
+	return nil
+}
+
+// DeleteSecret removes a secret, for k/v version 2 all versions are removed
+func (vs *VaultService) DeleteSecret(name string) error {
+	// deleting the metadata of a k/v version 2 secret removes all of its versions
+	response, err := vs.DoRequest(http.MethodDelete, "/v1/"+MetadataPath(vs.config(), name), nil)
+	if err != nil {
+		return fmt.Errorf("Error in request to Vault: %s", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("Vault returned unexpected response: %v", response.StatusCode)
+	}
+
+	return nil
+}
+
+// SecretTemplate returns the Nomad template which renders the value of a secret
+func (vs *VaultService) SecretTemplate(name string) string {
+	return SecretTemplate(vs.config(), name)
+}
+
+// SecretTemplateFormat returns the Nomad template which renders the value of
+// a secret through the format action
+func (vs *VaultService) SecretTemplateFormat(name, format string) string {
+	return SecretTemplateFormat(vs.config(), name, format)
+}
+
+// Policies returns the Vault policy attached to functions which use secrets
+func (vs *VaultService) Policies() []string {
+	return []string{vs.Config.DefaultPolicy}
+}